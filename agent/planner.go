@@ -1,65 +1,125 @@
 package agent
 
 import (
+	"reflect"
+	"sync"
+
 	"github.com/openagentsinc/autodev/llm"
+	"github.com/openagentsinc/autodev/pkg/plan"
 )
 
-// Task represents a single task or subtask in the plan
-type Task struct {
-	ID       string
-	Goal     string
-	Subtasks []*Task
-	State    string
-}
-
-// Plan represents the overall plan with a main goal and tasks
-type Plan struct {
-	MainGoal string
-	Tasks    []*Task
-}
-
-// NewPlan creates a new Plan with a main goal and initial tasks
-func NewPlan(mainGoal string, initialTasks []*Task) *Plan {
-	return &Plan{
-		MainGoal: mainGoal,
-		Tasks:    initialTasks,
-	}
-}
-
 // Agent represents our AI agent with planning capabilities
 type Agent struct {
-	CurrentPlan         *Plan
+	CurrentPlan         *plan.Plan
 	ConversationHistory []llm.Message
+
+	// PlanPath is the file the plan is saved to whenever it changes, so it
+	// survives restarts. The plan is only kept in memory if it is empty.
+	PlanPath string
+
+	// planMu guards the plan, which the planner changes while pages show it
+	planMu sync.RWMutex
+
+	// historyMu guards the conversation, which chat replies add to while
+	// new messages arrive
+	historyMu sync.Mutex
 }
 
 // NewAgent creates a new Agent with an initial plan
-func NewAgent(plan *Plan) *Agent {
+func NewAgent(p *plan.Plan) *Agent {
 	return &Agent{
-		CurrentPlan: plan,
+		CurrentPlan: p,
 	}
 }
 
 // GetPlan returns the current plan of the agent
-func (a *Agent) GetPlan() *Plan {
+func (a *Agent) GetPlan() *plan.Plan {
 	return a.CurrentPlan
 }
 
-func (a *Agent) ResetPlan() {
-	a.CurrentPlan = NewPlan(a.CurrentPlan.MainGoal, []*Task{})
+// ReadPlan calls fn with the plan, which fn must not change
+func (a *Agent) ReadPlan(fn func(p *plan.Plan)) {
+	a.planMu.RLock()
+	defer a.planMu.RUnlock()
+	fn(a.CurrentPlan)
+}
+
+// UpdatePlan calls fn to change the plan, then saves it
+func (a *Agent) UpdatePlan(fn func(p *plan.Plan) error) error {
+	a.planMu.Lock()
+	defer a.planMu.Unlock()
+	err := fn(a.CurrentPlan)
+	// fn may have changed the plan before failing, so save either way
+	if saveErr := a.savePlan(); err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// ReplacePlan replaces the plan, e.g. with an uploaded one, and saves it
+func (a *Agent) ReplacePlan(p *plan.Plan) error {
+	a.planMu.Lock()
+	defer a.planMu.Unlock()
+	a.CurrentPlan = p
+	return a.savePlan()
+}
+
+// ResetPlan replaces the plan with an empty one for the same goal and
+// saves it
+func (a *Agent) ResetPlan() error {
+	a.planMu.Lock()
+	defer a.planMu.Unlock()
+	a.CurrentPlan = plan.NewPlan(a.CurrentPlan.MainGoal)
+	return a.savePlan()
 }
 
+// savePlan writes the plan to PlanPath, if set. The caller must hold planMu.
+func (a *Agent) savePlan() error {
+	if a.PlanPath == "" {
+		return nil
+	}
+	return a.CurrentPlan.Save(a.PlanPath)
+}
+
+// GetConversationHistory returns a copy of the conversation
 func (a *Agent) GetConversationHistory() []llm.Message {
-	return a.ConversationHistory
+	a.historyMu.Lock()
+	defer a.historyMu.Unlock()
+	return append([]llm.Message(nil), a.ConversationHistory...)
 }
 
 func (a *Agent) SetConversationHistory(history []llm.Message) {
+	a.historyMu.Lock()
+	defer a.historyMu.Unlock()
 	a.ConversationHistory = history
 }
 
-func (a *Agent) AddToConversationHistory(message llm.Message) {
+// AddToConversationHistory appends a message and returns a copy of the
+// conversation up to and including it
+func (a *Agent) AddToConversationHistory(message llm.Message) []llm.Message {
+	a.historyMu.Lock()
+	defer a.historyMu.Unlock()
 	a.ConversationHistory = append(a.ConversationHistory, message)
+	return append([]llm.Message(nil), a.ConversationHistory...)
+}
+
+// AddReply appends a reply to the conversation. answered is the
+// conversation the reply was generated for and compacted a shorter version
+// of it, see history.Manager. If the conversation still starts with
+// answered, that part is replaced with compacted so later replies build on
+// it. Messages added while the reply was generated are kept.
+func (a *Agent) AddReply(answered, compacted []llm.Message, reply llm.Message) {
+	a.historyMu.Lock()
+	defer a.historyMu.Unlock()
+	n := len(answered)
+	if len(a.ConversationHistory) >= n && reflect.DeepEqual(a.ConversationHistory[:n], answered) {
+		a.ConversationHistory = append(append([]llm.Message(nil), compacted...), a.ConversationHistory[n:]...)
+	}
+	a.ConversationHistory = append(a.ConversationHistory, reply)
 }
 
 func (a *Agent) ClearConversationHistory() {
+	a.historyMu.Lock()
+	defer a.historyMu.Unlock()
 	a.ConversationHistory = []llm.Message{}
 }
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openagentsinc/autodev/llm"
+	"github.com/openagentsinc/autodev/pkg/plan"
+)
+
+func texts(messages []llm.Message) []string {
+	var result []string
+	for _, message := range messages {
+		result = append(result, message.Role+": "+message.Text())
+	}
+	return result
+}
+
+func TestAddReply(t *testing.T) {
+	user := func(text string) llm.Message { return llm.NewTextMessage("user", text) }
+	assistant := func(text string) llm.Message { return llm.NewTextMessage("assistant", text) }
+
+	tests := []struct {
+		name      string
+		later     []llm.Message
+		compacted []llm.Message
+		clear     bool
+		want      []string
+	}{
+		{
+			name: "nothing added meanwhile",
+			want: []string{"user: hi", "assistant: hello", "user: fix it", "assistant: done"},
+		},
+		{
+			name:  "message added meanwhile",
+			later: []llm.Message{user("and test it")},
+			want:  []string{"user: hi", "assistant: hello", "user: fix it", "user: and test it", "assistant: done"},
+		},
+		{
+			name:      "compacted",
+			later:     []llm.Message{user("and test it")},
+			compacted: []llm.Message{user("[summary] fix it")},
+			want:      []string{"user: [summary] fix it", "user: and test it", "assistant: done"},
+		},
+		{
+			// The compacted messages no longer match, so they are not used
+			name:      "cleared meanwhile",
+			compacted: []llm.Message{user("[summary] fix it")},
+			clear:     true,
+			want:      []string{"assistant: done"},
+		},
+	}
+	for _, tt := range tests {
+		a := NewAgent(plan.NewPlan("goal"))
+		a.SetConversationHistory([]llm.Message{user("hi"), assistant("hello")})
+		answered := a.AddToConversationHistory(user("fix it"))
+		for _, message := range tt.later {
+			a.AddToConversationHistory(message)
+		}
+		if tt.clear {
+			a.ClearConversationHistory()
+		}
+		compacted := answered
+		if tt.compacted != nil {
+			compacted = tt.compacted
+		}
+
+		a.AddReply(answered, compacted, assistant("done"))
+		if got := texts(a.GetConversationHistory()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: conversation = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
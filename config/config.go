@@ -1,18 +1,129 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
-	"github.com/openagentsinc/autodev/llm"
+	anthropic "github.com/openagentsinc/autodev/llm"
+	"github.com/openagentsinc/autodev/pkg/history"
+	"github.com/openagentsinc/autodev/pkg/llm"
+	"github.com/openagentsinc/autodev/pkg/sandbox"
+	"github.com/openagentsinc/autodev/pkg/usage"
+)
+
+// DefaultPlanPath is where the plan is saved unless PLAN_PATH is set
+const DefaultPlanPath = "plan.json"
+
+// DefaultWorkspaceDir is where the coding agent works unless WORKSPACE_DIR
+// is set
+const DefaultWorkspaceDir = "workspace"
+
+// DefaultMemoryPath is where the coding agent's memory is saved unless
+// MEMORY_PATH is set
+const DefaultMemoryPath = "memory.jsonl"
+
+// Sandboxes the coding agent can run commands in, selected with SANDBOX
+const (
+	SandboxContainer = "container"
+	SandboxLocal     = "local"
+)
+
+// Default container limits, see loadContainerConfig
+const (
+	DefaultSandboxCPUs      = 2
+	DefaultSandboxMemory    = "2g"
+	DefaultSandboxPidsLimit = 512
 )
 
 type Config struct {
 	GreptileApiKey  string
 	GithubToken     string
 	AnthropicAPIKey string
-	LLM             *llm.LLM
+	OpenAIAPIKey    string
+	OpenAIBaseURL   string
+	LLMProvider     string
+	LLMModel        string
+	LLMTimeout      time.Duration
+	LLMMaxRetries   int
+	LLM             llm.Provider
+	Usage           *usage.Tracker
+	History         history.Policy
+	Agents          map[string]AgentConfig
+	ChromePath      string
+	PlanPath        string
+	WorkspaceDir    string
+	MemoryPath      string
+	EmbeddingModel  string
+
+	// Sandbox is SandboxContainer to run the coding agent's commands in a
+	// container configured by Container, or SandboxLocal to run them on the
+	// host
+	Sandbox   string
+	Container sandbox.ContainerConfig
+}
+
+// AgentConfig holds an agent's defaults for LLM requests, so each agent can
+// run with its own prompt and model
+type AgentConfig struct {
+	Model        string
+	SystemPrompt string
+	Temperature  *float64
+	MaxTokens    int
+
+	// Tools has the agent call its actions as native tools instead of
+	// writing CodeAct tags
+	Tools bool
+}
+
+// defaultAgents are the built-in agent defaults. Each field can be
+// overridden with <NAME>_MODEL, <NAME>_SYSTEM_PROMPT, <NAME>_TEMPERATURE,
+// <NAME>_MAX_TOKENS and <NAME>_TOOLS environment variables, e.g.
+// PLANNER_MODEL.
+var defaultAgents = map[string]AgentConfig{
+	"planner": {
+		SystemPrompt: "You are AutoDev's planner. Break the user's goal down into small, concrete software engineering tasks that can each be completed and verified independently.",
+		MaxTokens:    1024,
+	},
+	"coder": {
+		SystemPrompt: "You are AutoDev's coding agent. You complete software engineering tasks by running shell commands and editing files, one step at a time.",
+		MaxTokens:    4096,
+	},
+	"browser": {
+		SystemPrompt: "You are AutoDev's research agent. You read web pages and documentation to answer a question for the coding agent, and finish with what you found and where.",
+		MaxTokens:    4096,
+	},
+	"verifier": {
+		SystemPrompt: "You are AutoDev's verifier. You check that a task was done correctly by reading the code and running its tests and builds, without changing any files, and finish with whether it passes and why.",
+		MaxTokens:    4096,
+	},
+}
+
+// Options returns the request options for the agent's defaults
+func (ac AgentConfig) Options() []llm.Option {
+	var opts []llm.Option
+	if ac.Model != "" {
+		opts = append(opts, llm.WithModel(ac.Model))
+	}
+	if ac.SystemPrompt != "" {
+		opts = append(opts, llm.WithSystem(ac.SystemPrompt))
+	}
+	if ac.Temperature != nil {
+		opts = append(opts, llm.WithTemperature(*ac.Temperature))
+	}
+	if ac.MaxTokens > 0 {
+		opts = append(opts, llm.WithMaxTokens(ac.MaxTokens))
+	}
+	return opts
+}
+
+// Agent returns the defaults for the named agent
+func (c *Config) Agent(name string) AgentConfig {
+	return c.Agents[name]
 }
 
 func LoadConfig() (*Config, error) {
@@ -25,23 +136,242 @@ func LoadConfig() (*Config, error) {
 		AnthropicAPIKey: os.Getenv("ANTHROPIC_API_KEY"),
 		GreptileApiKey:  os.Getenv("GREPTILE_API_KEY"),
 		GithubToken:     os.Getenv("GITHUB_TOKEN"),
+		OpenAIAPIKey:    os.Getenv("OPENAI_API_KEY"),
+		OpenAIBaseURL:   os.Getenv("OPENAI_BASE_URL"),
+		LLMProvider:     strings.ToLower(os.Getenv("LLM_PROVIDER")),
+		LLMModel:        os.Getenv("LLM_MODEL"),
+		ChromePath:      os.Getenv("CHROME_PATH"),
+		PlanPath:        os.Getenv("PLAN_PATH"),
+		WorkspaceDir:    os.Getenv("WORKSPACE_DIR"),
+		MemoryPath:      os.Getenv("MEMORY_PATH"),
+		EmbeddingModel:  os.Getenv("EMBEDDING_MODEL"),
+		Sandbox:         strings.ToLower(os.Getenv("SANDBOX")),
 	}
 
 	if config.GreptileApiKey == "" || config.GithubToken == "" {
 		return nil, fmt.Errorf("GREPTILE_API_KEY and GITHUB_TOKEN must be set")
 	}
 
-	if config.AnthropicAPIKey == "" {
-		return nil, fmt.Errorf("ANTHROPIC_API_KEY must be set")
+	if config.PlanPath == "" {
+		config.PlanPath = DefaultPlanPath
+	}
+	if config.WorkspaceDir == "" {
+		config.WorkspaceDir = DefaultWorkspaceDir
+	}
+	if config.MemoryPath == "" {
+		config.MemoryPath = DefaultMemoryPath
 	}
 
-	// Initialize the LLM
-	llmClient, err := llm.NewLLM(config.AnthropicAPIKey)
+	switch config.Sandbox {
+	case "":
+		config.Sandbox = SandboxContainer
+	case SandboxContainer, SandboxLocal:
+	default:
+		return nil, fmt.Errorf("invalid SANDBOX: %s", config.Sandbox)
+	}
+	config.Container, err = loadContainerConfig(config.WorkspaceDir)
 	if err != nil {
 		return nil, err
 	}
 
-	config.LLM = llmClient
+	config.LLMTimeout = anthropic.DefaultTimeout
+	if timeout := os.Getenv("LLM_TIMEOUT"); timeout != "" {
+		config.LLMTimeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LLM_TIMEOUT: %v", err)
+		}
+	}
+
+	config.LLMMaxRetries = anthropic.DefaultMaxRetries
+	if maxRetries := os.Getenv("LLM_MAX_RETRIES"); maxRetries != "" {
+		config.LLMMaxRetries, err = strconv.Atoi(maxRetries)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LLM_MAX_RETRIES: %v", err)
+		}
+	}
+
+	config.History, err = loadHistoryPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	config.Agents = make(map[string]AgentConfig)
+	for name, defaults := range defaultAgents {
+		config.Agents[name], err = loadAgentConfig(name, defaults)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Track token usage and cost, priced with LLM_PRICES if set
+	prices := usage.DefaultPrices()
+	if path := os.Getenv("LLM_PRICES"); path != "" {
+		prices, err = usage.LoadPrices(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	config.Usage = usage.NewTracker(prices)
+
+	// Initialize the LLM provider
+	provider, err := newProvider(config)
+	if err != nil {
+		return nil, err
+	}
+
+	config.LLM = llm.NewTrackedProvider(provider, config.Usage)
 
 	return config, nil
 }
+
+// loadHistoryPolicy reads the conversation compaction policy from
+// HISTORY_POLICY ("summarize" or "truncate"), HISTORY_MAX_TOKENS,
+// HISTORY_KEEP_TOKENS and HISTORY_KEEP_RECENT
+func loadHistoryPolicy() (history.Policy, error) {
+	policy := history.DefaultPolicy()
+
+	switch mode := strings.ToLower(os.Getenv("HISTORY_POLICY")); mode {
+	case "":
+	case history.ModeSummarize, history.ModeTruncate:
+		policy.Mode = mode
+	default:
+		return policy, fmt.Errorf("invalid HISTORY_POLICY: %s", mode)
+	}
+
+	for name, field := range map[string]*int{
+		"HISTORY_MAX_TOKENS":  &policy.MaxTokens,
+		"HISTORY_KEEP_TOKENS": &policy.KeepTokens,
+		"HISTORY_KEEP_RECENT": &policy.KeepRecent,
+	} {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return policy, fmt.Errorf("invalid %s: %v", name, err)
+			}
+			*field = n
+		}
+	}
+
+	return policy, nil
+}
+
+// loadContainerConfig reads the coding agent's container from
+// SANDBOX_RUNTIME, SANDBOX_IMAGE, SANDBOX_USER and SANDBOX_TIMEOUT, its
+// limits from SANDBOX_CPUS, SANDBOX_MEMORY and SANDBOX_PIDS_LIMIT, and
+// whether it has network access from SANDBOX_NETWORK. The workspace
+// directory is mounted in it.
+func loadContainerConfig(workspace string) (sandbox.ContainerConfig, error) {
+	cc := sandbox.ContainerConfig{
+		Runtime:   os.Getenv("SANDBOX_RUNTIME"),
+		Image:     os.Getenv("SANDBOX_IMAGE"),
+		User:      os.Getenv("SANDBOX_USER"),
+		Workspace: workspace,
+		CPUs:      DefaultSandboxCPUs,
+		Memory:    DefaultSandboxMemory,
+		PidsLimit: DefaultSandboxPidsLimit,
+	}
+	var err error
+
+	if network := os.Getenv("SANDBOX_NETWORK"); network != "" {
+		cc.Network, err = strconv.ParseBool(network)
+		if err != nil {
+			return cc, fmt.Errorf("invalid SANDBOX_NETWORK: %v", err)
+		}
+	}
+	if cpus := os.Getenv("SANDBOX_CPUS"); cpus != "" {
+		cc.CPUs, err = strconv.ParseFloat(cpus, 64)
+		if err != nil {
+			return cc, fmt.Errorf("invalid SANDBOX_CPUS: %v", err)
+		}
+	}
+	if memory := os.Getenv("SANDBOX_MEMORY"); memory != "" {
+		cc.Memory = memory
+	}
+	if pidsLimit := os.Getenv("SANDBOX_PIDS_LIMIT"); pidsLimit != "" {
+		cc.PidsLimit, err = strconv.Atoi(pidsLimit)
+		if err != nil {
+			return cc, fmt.Errorf("invalid SANDBOX_PIDS_LIMIT: %v", err)
+		}
+	}
+	if timeout := os.Getenv("SANDBOX_TIMEOUT"); timeout != "" {
+		cc.Timeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			return cc, fmt.Errorf("invalid SANDBOX_TIMEOUT: %v", err)
+		}
+	}
+
+	return cc, nil
+}
+
+// loadAgentConfig applies environment overrides to an agent's defaults
+func loadAgentConfig(name string, defaults AgentConfig) (AgentConfig, error) {
+	prefix := strings.ToUpper(name) + "_"
+	ac := defaults
+
+	if model := os.Getenv(prefix + "MODEL"); model != "" {
+		ac.Model = model
+	}
+	if prompt := os.Getenv(prefix + "SYSTEM_PROMPT"); prompt != "" {
+		ac.SystemPrompt = prompt
+	}
+	if temperature := os.Getenv(prefix + "TEMPERATURE"); temperature != "" {
+		t, err := strconv.ParseFloat(temperature, 64)
+		if err != nil {
+			return ac, fmt.Errorf("invalid %sTEMPERATURE: %v", prefix, err)
+		}
+		ac.Temperature = &t
+	}
+	if maxTokens := os.Getenv(prefix + "MAX_TOKENS"); maxTokens != "" {
+		n, err := strconv.Atoi(maxTokens)
+		if err != nil {
+			return ac, fmt.Errorf("invalid %sMAX_TOKENS: %v", prefix, err)
+		}
+		ac.MaxTokens = n
+	}
+	if tools := os.Getenv(prefix + "TOOLS"); tools != "" {
+		b, err := strconv.ParseBool(tools)
+		if err != nil {
+			return ac, fmt.Errorf("invalid %sTOOLS: %v", prefix, err)
+		}
+		ac.Tools = b
+	}
+
+	return ac, nil
+}
+
+// newProvider creates the LLM provider selected by LLM_PROVIDER:
+// "anthropic" (the default), "openai" for any OpenAI-compatible server
+// such as llama.cpp, Ollama or vLLM, or "scripted" to replay the responses
+// in the JSON file named by LLM_SCRIPT.
+func newProvider(config *Config) (llm.Provider, error) {
+	switch config.LLMProvider {
+	case "", "anthropic":
+		if config.AnthropicAPIKey == "" {
+			return nil, fmt.Errorf("ANTHROPIC_API_KEY must be set")
+		}
+		provider, err := llm.NewAnthropicProvider(config.AnthropicAPIKey, config.LLMModel)
+		if err != nil {
+			return nil, err
+		}
+		provider.Client.Timeout = config.LLMTimeout
+		provider.Client.MaxRetries = config.LLMMaxRetries
+		return provider, nil
+	case "openai":
+		if config.OpenAIAPIKey == "" && config.OpenAIBaseURL == "" {
+			return nil, fmt.Errorf("OPENAI_API_KEY or OPENAI_BASE_URL must be set")
+		}
+		return llm.NewOpenAIProvider(config.OpenAIAPIKey, config.OpenAIBaseURL, config.LLMModel), nil
+	case "scripted":
+		data, err := os.ReadFile(os.Getenv("LLM_SCRIPT"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read LLM_SCRIPT: %v", err)
+		}
+		var responses []*llm.Response
+		if err := json.Unmarshal(data, &responses); err != nil {
+			return nil, fmt.Errorf("failed to parse LLM_SCRIPT: %v", err)
+		}
+		return llm.NewScriptedProvider(responses...), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER: %s", config.LLMProvider)
+	}
+}
@@ -4,9 +4,13 @@ go 1.22.1
 
 require (
 	github.com/a-h/templ v0.2.707
+	github.com/creack/pty v1.1.21
 	github.com/extism/go-sdk v1.2.0
 	github.com/joho/godotenv v1.5.1
 	github.com/labstack/echo/v4 v4.12.0
+	golang.org/x/net v0.24.0
+	golang.org/x/sys v0.19.0
+	gopkg.in/yaml.v3 v3.0.1
 	tractor.dev/toolkit-go v0.0.0-20240304053737-324323efde45
 )
 
@@ -20,8 +24,6 @@ require (
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
 	golang.org/x/crypto v0.22.0 // indirect
-	golang.org/x/net v0.24.0 // indirect
-	golang.org/x/sys v0.19.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
 )
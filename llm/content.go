@@ -0,0 +1,160 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Content block types
+const (
+	BlockText       = "text"
+	BlockImage      = "image"
+	BlockToolUse    = "tool_use"
+	BlockToolResult = "tool_result"
+)
+
+// ContentBlock is a single typed block of message content. Only the fields
+// relevant to the block Type are set.
+type ContentBlock struct {
+	Type string `json:"type"`
+
+	// text
+	Text string `json:"text,omitempty"`
+
+	// image
+	Source *ImageSource `json:"source,omitempty"`
+
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+
+	// tool_result
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   string `json:"content,omitempty"`
+	IsError   bool   `json:"is_error,omitempty"`
+
+	// CacheControl marks the end of a cacheable prompt prefix
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
+}
+
+// CacheControl is a prompt caching breakpoint. Everything in the request up
+// to and including the marked block is cached and reused by later requests
+// with the same prefix.
+type CacheControl struct {
+	Type string `json:"type"`
+}
+
+// Ephemeral returns the cache control for the default short-lived cache
+func Ephemeral() *CacheControl {
+	return &CacheControl{Type: "ephemeral"}
+}
+
+// ImageSource holds base64 encoded image data for an image block.
+type ImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+// NewTextBlock creates a text content block
+func NewTextBlock(text string) ContentBlock {
+	return ContentBlock{Type: BlockText, Text: text}
+}
+
+// NewCachedTextBlock creates a text content block marked as a cache breakpoint
+func NewCachedTextBlock(text string) ContentBlock {
+	block := NewTextBlock(text)
+	block.CacheControl = Ephemeral()
+	return block
+}
+
+// NewImageBlock creates an image content block from base64 encoded data
+func NewImageBlock(mediaType, data string) ContentBlock {
+	return ContentBlock{
+		Type: BlockImage,
+		Source: &ImageSource{
+			Type:      "base64",
+			MediaType: mediaType,
+			Data:      data,
+		},
+	}
+}
+
+// NewToolUseBlock creates a tool_use content block
+func NewToolUseBlock(id, name string, input json.RawMessage) ContentBlock {
+	if len(input) == 0 {
+		input = json.RawMessage("{}")
+	}
+	return ContentBlock{Type: BlockToolUse, ID: id, Name: name, Input: input}
+}
+
+// NewToolResultBlock creates a tool_result content block answering the
+// tool_use block with the given id
+func NewToolResultBlock(toolUseID, content string, isError bool) ContentBlock {
+	return ContentBlock{Type: BlockToolResult, ToolUseID: toolUseID, Content: content, IsError: isError}
+}
+
+// Message is a single turn in a conversation
+type Message struct {
+	Role    string         `json:"role"`
+	Content []ContentBlock `json:"content"`
+}
+
+// NewTextMessage creates a message with a single text block
+func NewTextMessage(role, text string) Message {
+	return Message{Role: role, Content: []ContentBlock{NewTextBlock(text)}}
+}
+
+// Text returns the concatenated text of all text blocks in the message
+func (m Message) Text() string {
+	return blocksText(m.Content)
+}
+
+// UnmarshalJSON accepts content either as a list of blocks or, as the API
+// also allows, a plain string.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	m.Role = raw.Role
+	m.Content = nil
+
+	var text string
+	if err := json.Unmarshal(raw.Content, &text); err == nil {
+		m.Content = []ContentBlock{NewTextBlock(text)}
+		return nil
+	}
+	return json.Unmarshal(raw.Content, &m.Content)
+}
+
+// Tool describes a tool the model may call. InputSchema is a JSON schema
+// object describing the tool input.
+type Tool struct {
+	Name         string                 `json:"name"`
+	Description  string                 `json:"description,omitempty"`
+	InputSchema  map[string]interface{} `json:"input_schema"`
+	CacheControl *CacheControl          `json:"cache_control,omitempty"`
+}
+
+// ToolChoice controls how the model uses the provided tools. Type is one of
+// "auto", "any" or "tool"; Name is required when Type is "tool".
+type ToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+func blocksText(blocks []ContentBlock) string {
+	var b strings.Builder
+	for _, block := range blocks {
+		if block.Type == BlockText {
+			b.WriteString(block.Text)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,115 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Error kinds, matched with errors.Is against errors returned by the client
+var (
+	ErrRateLimited    = errors.New("rate limited")
+	ErrOverloaded     = errors.New("overloaded")
+	ErrAuthentication = errors.New("authentication failed")
+	ErrInvalidRequest = errors.New("invalid request")
+	ErrServer         = errors.New("server error")
+)
+
+// APIError is an error response from the API
+type APIError struct {
+	StatusCode int
+	Type       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+// NewAPIError creates an APIError from a failed response and its body
+func NewAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    string(body),
+		RetryAfter: parseRetryAfter(resp.Header),
+	}
+
+	var errorBody struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &errorBody); err == nil && errorBody.Error.Message != "" {
+		apiErr.Type = errorBody.Error.Type
+		apiErr.Message = errorBody.Error.Message
+	}
+
+	return apiErr
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode == 0 {
+		// Errors sent as events in the middle of a stream have no status code.
+		return fmt.Sprintf("stream error (%s): %s", e.Type, e.Message)
+	}
+	if e.Type != "" {
+		return fmt.Sprintf("API request failed with status code %d (%s): %s", e.StatusCode, e.Type, e.Message)
+	}
+	return fmt.Sprintf("API request failed with status code %d: %s", e.StatusCode, e.Message)
+}
+
+// Unwrap returns the error kind, based on the error type if the API sent one
+// and on the status code otherwise
+func (e *APIError) Unwrap() error {
+	switch e.Type {
+	case "rate_limit_error":
+		return ErrRateLimited
+	case "overloaded_error":
+		return ErrOverloaded
+	case "authentication_error", "permission_error":
+		return ErrAuthentication
+	case "invalid_request_error", "not_found_error", "request_too_large":
+		return ErrInvalidRequest
+	case "api_error":
+		return ErrServer
+	}
+
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode == 529:
+		return ErrOverloaded
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrAuthentication
+	case e.StatusCode >= 500:
+		return ErrServer
+	case e.StatusCode >= 400:
+		return ErrInvalidRequest
+	}
+	return nil
+}
+
+// Retryable reports whether the request may succeed if sent again
+func (e *APIError) Retryable() bool {
+	kind := e.Unwrap()
+	return kind == ErrRateLimited || kind == ErrOverloaded || kind == ErrServer
+}
+
+// parseRetryAfter reads the retry-after header, which holds either a number
+// of seconds or an HTTP date
+func parseRetryAfter(header http.Header) time.Duration {
+	value := header.Get("retry-after")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -2,39 +2,117 @@ package llm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"os"
+	"time"
 )
 
 const (
 	AnthropicAPIURL     = "https://api.anthropic.com/v1/messages"
 	AnthropicAPIVersion = "2023-06-01"
 	DefaultModel        = "claude-3-5-sonnet-20240620"
+
+	DefaultTimeout        = 5 * time.Minute
+	DefaultMaxRetries     = 3
+	DefaultRetryBaseDelay = 1 * time.Second
+	DefaultRetryMaxDelay  = 30 * time.Second
 )
 
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+type AnthropicRequest struct {
+	Model         string         `json:"model"`
+	MaxTokens     int            `json:"max_tokens"`
+	Messages      []Message      `json:"messages"`
+	System        []ContentBlock `json:"system,omitempty"`
+	Temperature   *float64       `json:"temperature,omitempty"`
+	TopP          *float64       `json:"top_p,omitempty"`
+	StopSequences []string       `json:"stop_sequences,omitempty"`
+	Metadata      *Metadata      `json:"metadata,omitempty"`
+	Tools         []Tool         `json:"tools,omitempty"`
+	ToolChoice    *ToolChoice    `json:"tool_choice,omitempty"`
+	Stream        bool           `json:"stream,omitempty"`
 }
 
-type AnthropicRequest struct {
-	Model     string    `json:"model"`
-	MaxTokens int       `json:"max_tokens"`
-	Messages  []Message `json:"messages"`
+// Metadata describes the request. UserID is an opaque identifier for the
+// end user, which the API uses to detect abuse.
+type Metadata struct {
+	UserID string `json:"user_id,omitempty"`
 }
 
 type AnthropicResponse struct {
-	Content []struct {
-		Text string `json:"text"`
-	} `json:"content"`
+	ID           string         `json:"id"`
+	Model        string         `json:"model"`
+	Role         string         `json:"role"`
+	Content      []ContentBlock `json:"content"`
+	StopReason   string         `json:"stop_reason"`
+	StopSequence string         `json:"stop_sequence"`
+	Usage        Usage          `json:"usage"`
+}
+
+// Text returns the concatenated text of all text blocks in the response
+func (r *AnthropicResponse) Text() string {
+	return blocksText(r.Content)
+}
+
+// ToolUses returns the tool_use blocks in the response, in order
+func (r *AnthropicResponse) ToolUses() []ContentBlock {
+	var uses []ContentBlock
+	for _, block := range r.Content {
+		if block.Type == BlockToolUse {
+			uses = append(uses, block)
+		}
+	}
+	return uses
+}
+
+// Message returns the response as an assistant message that can be appended
+// to the conversation
+func (r *AnthropicResponse) Message() Message {
+	return Message{Role: "assistant", Content: r.Content}
+}
+
+type Usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// Add returns the sum of two usages
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		InputTokens:              u.InputTokens + other.InputTokens,
+		OutputTokens:             u.OutputTokens + other.OutputTokens,
+		CacheCreationInputTokens: u.CacheCreationInputTokens + other.CacheCreationInputTokens,
+		CacheReadInputTokens:     u.CacheReadInputTokens + other.CacheReadInputTokens,
+	}
 }
 
 type LLM struct {
 	APIKey string
 	Model  string
+	APIURL string
+
+	// HTTPClient is used to send requests. A new http.Client is used if nil.
+	HTTPClient *http.Client
+
+	// Timeout limits how long each attempt waits for response headers.
+	// Zero means no limit.
+	Timeout time.Duration
+
+	// MaxRetries is the number of times a rate limited, overloaded or
+	// failed request is retried. Retries wait with exponential backoff and
+	// jitter between RetryBaseDelay and RetryMaxDelay, or as long as the
+	// retry-after header asks, up to RetryMaxDelay.
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
 }
 
 func NewLLM(apiKey string) (*LLM, error) {
@@ -42,65 +120,235 @@ func NewLLM(apiKey string) (*LLM, error) {
 		return nil, fmt.Errorf("API key is required")
 	}
 	return &LLM{
-		APIKey: apiKey,
-		Model:  DefaultModel,
+		APIKey:         apiKey,
+		Model:          DefaultModel,
+		APIURL:         AnthropicAPIURL,
+		Timeout:        DefaultTimeout,
+		MaxRetries:     DefaultMaxRetries,
+		RetryBaseDelay: DefaultRetryBaseDelay,
+		RetryMaxDelay:  DefaultRetryMaxDelay,
 	}, nil
 }
 
-func (l *LLM) GenerateResponse(messages []Message, maxTokens int) (string, error) {
+func (l *LLM) GenerateResponse(messages []Message, maxTokens int, opts ...Option) (string, error) {
+	anthropicResp, err := l.CreateMessage(context.Background(), NewRequest(messages, maxTokens, opts...))
+	if err != nil {
+		return "", err
+	}
+
+	if len(anthropicResp.Content) == 0 {
+		return "", fmt.Errorf("no content in response")
+	}
+
+	return anthropicResp.Text(), nil
+}
+
+// CreateMessage sends a request to the Messages API and returns the full
+// response, including tool_use blocks, the stop reason and usage. The client's
+// model is used when the request does not set one.
+func (l *LLM) CreateMessage(ctx context.Context, requestBody AnthropicRequest) (*AnthropicResponse, error) {
+	if requestBody.Model == "" {
+		requestBody.Model = l.Model
+	}
+	requestBody.Stream = false
+
+	body, err := l.post(ctx, l.apiURL(), requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var anthropicResp AnthropicResponse
+	err = json.Unmarshal(body, &anthropicResp)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling response: %v", err)
+	}
+
+	return &anthropicResp, nil
+}
+
+// CountTokens returns the number of input tokens the request would use,
+// as reported by the token counting endpoint.
+func (l *LLM) CountTokens(ctx context.Context, requestBody AnthropicRequest) (int, error) {
+	if requestBody.Model == "" {
+		requestBody.Model = l.Model
+	}
+
+	countRequest := struct {
+		Model    string         `json:"model"`
+		Messages []Message      `json:"messages"`
+		System   []ContentBlock `json:"system,omitempty"`
+		Tools    []Tool         `json:"tools,omitempty"`
+	}{
+		Model:    requestBody.Model,
+		Messages: requestBody.Messages,
+		System:   requestBody.System,
+		Tools:    requestBody.Tools,
+	}
+
+	body, err := l.post(ctx, l.apiURL()+"/count_tokens", countRequest)
+	if err != nil {
+		return 0, err
+	}
+
+	var countResp struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := json.Unmarshal(body, &countResp); err != nil {
+		return 0, fmt.Errorf("error unmarshalling response: %v", err)
+	}
+
+	return countResp.InputTokens, nil
+}
+
+func (l *LLM) apiURL() string {
+	if l.APIURL == "" {
+		return AnthropicAPIURL
+	}
+	return l.APIURL
+}
+
+// post sends a request and returns the body of a successful response.
+func (l *LLM) post(ctx context.Context, url string, requestBody interface{}) ([]byte, error) {
+	resp, err := l.send(ctx, url, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	return body, nil
+}
+
+// send posts a request to the API, retrying rate limited, overloaded and
+// failed requests, and returns the first successful response. Failures are
+// returned as *APIError. The caller is responsible for closing the body.
+func (l *LLM) send(ctx context.Context, url string, requestBody interface{}) (*http.Response, error) {
 	if l.APIKey == "" {
 		l.APIKey = os.Getenv("ANTHROPIC_API_KEY")
 		if l.APIKey == "" {
-			return "", fmt.Errorf("ANTHROPIC_API_KEY not set")
+			return nil, fmt.Errorf("ANTHROPIC_API_KEY not set")
 		}
 	}
 
-	requestBody := AnthropicRequest{
-		Model:     l.Model,
-		MaxTokens: maxTokens,
-		Messages:  messages,
-	}
-
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", fmt.Errorf("error marshalling request: %v", err)
+		return nil, fmt.Errorf("error marshalling request: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", AnthropicAPIURL, bytes.NewBuffer(jsonData))
+	for attempt := 0; ; attempt++ {
+		resp, err := l.attempt(ctx, url, jsonData)
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		var retryAfter time.Duration
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			if !apiErr.Retryable() {
+				return nil, err
+			}
+			retryAfter = apiErr.RetryAfter
+		}
+		if attempt >= l.MaxRetries {
+			return nil, err
+		}
+
+		select {
+		case <-time.After(l.backoff(attempt, retryAfter)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// attempt sends a request once. The attempt is cancelled if response headers
+// do not arrive within the client's timeout.
+func (l *LLM) attempt(ctx context.Context, url string, jsonData []byte) (*http.Response, error) {
+	attemptCtx, cancel := context.WithCancel(ctx)
+
+	req, err := http.NewRequestWithContext(attemptCtx, "POST", url, bytes.NewReader(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("error creating request: %v", err)
+		cancel()
+		return nil, fmt.Errorf("error creating request: %v", err)
 	}
 
 	req.Header.Set("x-api-key", l.APIKey)
 	req.Header.Set("anthropic-version", AnthropicAPIVersion)
 	req.Header.Set("content-type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("error making request: %v", err)
+	client := l.HTTPClient
+	if client == nil {
+		client = &http.Client{}
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	var timer *time.Timer
+	if l.Timeout > 0 {
+		timer = time.AfterFunc(l.Timeout, cancel)
+	}
+
+	resp, err := client.Do(req)
+	if timer != nil && !timer.Stop() && ctx.Err() == nil {
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, fmt.Errorf("error making request: timed out after %s", l.Timeout)
+	}
 	if err != nil {
-		return "", fmt.Errorf("error reading response body: %v", err)
+		cancel()
+		return nil, fmt.Errorf("error making request: %v", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status code %d: %s", resp.StatusCode, string(body))
+		defer cancel()
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, NewAPIError(resp, body)
 	}
 
-	var anthropicResp AnthropicResponse
-	err = json.Unmarshal(body, &anthropicResp)
-	if err != nil {
-		return "", fmt.Errorf("error unmarshalling response: %v", err)
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// backoff returns how long to wait before retrying. The retry-after delay
+// is used when the API sent one, up to RetryMaxDelay, otherwise the delay
+// doubles with every attempt and is jittered to spread out concurrent
+// retries.
+func (l *LLM) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	base, max := l.RetryBaseDelay, l.RetryMaxDelay
+	if base <= 0 {
+		base = DefaultRetryBaseDelay
+	}
+	if max <= 0 {
+		max = DefaultRetryMaxDelay
 	}
 
-	if len(anthropicResp.Content) == 0 {
-		return "", fmt.Errorf("no content in response")
+	if retryAfter > 0 {
+		return min(retryAfter, max)
+	}
+
+	delay := base << uint(attempt)
+	if delay <= 0 || delay > max {
+		delay = max
 	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
 
-	return anthropicResp.Content[0].Text, nil
+// cancelOnClose releases a request's context when its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
 }
 
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,267 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedStatus is one response of a scriptedServer
+type scriptedStatus struct {
+	status     int
+	retryAfter string
+	body       string
+}
+
+const okBody = `{"id":"msg_1","role":"assistant","content":[{"type":"text","text":"Hi"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":1}}`
+
+// scriptedServer answers each request with the next status in the script
+// and counts the requests it received
+func scriptedServer(t *testing.T, script ...scriptedStatus) (*httptest.Server, func() int) {
+	t.Helper()
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if requests >= len(script) {
+			t.Errorf("unexpected request %d", requests+1)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		step := script[requests]
+		requests++
+		if step.retryAfter != "" {
+			w.Header().Set("retry-after", step.retryAfter)
+		}
+		w.WriteHeader(step.status)
+		fmt.Fprint(w, step.body)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func testLLM(url string) *LLM {
+	return &LLM{
+		APIKey:         "test",
+		APIURL:         url,
+		MaxRetries:     2,
+		RetryBaseDelay: time.Millisecond,
+		RetryMaxDelay:  10 * time.Millisecond,
+	}
+}
+
+func errorBody(kind string) string {
+	return fmt.Sprintf(`{"type":"error","error":{"type":%q,"message":"scripted %s"}}`, kind, kind)
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   []scriptedStatus
+		wantErr  error
+		requests int
+	}{
+		{
+			name:     "success",
+			script:   []scriptedStatus{{status: 200, body: okBody}},
+			requests: 1,
+		},
+		{
+			name: "rate limited then success",
+			script: []scriptedStatus{
+				{status: 429, retryAfter: "0.001", body: errorBody("rate_limit_error")},
+				{status: 200, body: okBody},
+			},
+			requests: 2,
+		},
+		{
+			name: "overloaded then server error then success",
+			script: []scriptedStatus{
+				{status: 529, body: errorBody("overloaded_error")},
+				{status: 500, body: errorBody("api_error")},
+				{status: 200, body: okBody},
+			},
+			requests: 3,
+		},
+		{
+			name: "overloaded until retries run out",
+			script: []scriptedStatus{
+				{status: 529, body: errorBody("overloaded_error")},
+				{status: 529, body: errorBody("overloaded_error")},
+				{status: 529, body: errorBody("overloaded_error")},
+			},
+			wantErr:  ErrOverloaded,
+			requests: 3,
+		},
+		{
+			name:     "authentication is not retried",
+			script:   []scriptedStatus{{status: 401, body: errorBody("authentication_error")}},
+			wantErr:  ErrAuthentication,
+			requests: 1,
+		},
+		{
+			name:     "invalid request is not retried",
+			script:   []scriptedStatus{{status: 400, body: errorBody("invalid_request_error")}},
+			wantErr:  ErrInvalidRequest,
+			requests: 1,
+		},
+		{
+			name: "long retry-after is clamped",
+			script: []scriptedStatus{
+				{status: 429, retryAfter: "3600"},
+				{status: 200, body: okBody},
+			},
+			requests: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := scriptedServer(t, tt.script...)
+			l := testLLM(server.URL)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			resp, err := l.CreateMessage(ctx, NewRequest([]Message{NewTextMessage("user", "Hi")}, 10))
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if resp.Text() != "Hi" {
+				t.Errorf("text = %q, want Hi", resp.Text())
+			}
+			if got := requests(); got != tt.requests {
+				t.Errorf("got %d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	server, requests := scriptedServer(t,
+		scriptedStatus{status: 529, body: errorBody("overloaded_error")},
+		scriptedStatus{status: 200, body: okBody},
+	)
+	l := testLLM(server.URL)
+	l.RetryBaseDelay = time.Hour
+	l.RetryMaxDelay = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := l.CreateMessage(ctx, NewRequest([]Message{NewTextMessage("user", "Hi")}, 10))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want the context deadline", err)
+	}
+	if got := requests(); got != 1 {
+		t.Errorf("got %d requests, want 1", got)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	l := testLLM(server.URL)
+	l.MaxRetries = 0
+	l.Timeout = 20 * time.Millisecond
+
+	_, err := l.CreateMessage(context.Background(), NewRequest([]Message{NewTextMessage("user", "Hi")}, 10))
+	if err == nil {
+		t.Fatal("want a timeout error")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	l := &LLM{RetryBaseDelay: 100 * time.Millisecond, RetryMaxDelay: time.Second}
+
+	if got := l.backoff(0, time.Hour); got != time.Second {
+		t.Errorf("backoff with a long retry-after = %s, want the 1s maximum", got)
+	}
+	if got := l.backoff(0, 300*time.Millisecond); got != 300*time.Millisecond {
+		t.Errorf("backoff with retry-after = %s, want 300ms", got)
+	}
+	for attempt := 0; attempt < 10; attempt++ {
+		delay := 100 * time.Millisecond << uint(attempt)
+		if delay > time.Second {
+			delay = time.Second
+		}
+		got := l.backoff(attempt, 0)
+		if got < delay/2 || got > delay {
+			t.Errorf("backoff(%d) = %s, want between %s and %s", attempt, got, delay/2, delay)
+		}
+	}
+}
+
+// The cache breakpoints set by WithPromptCaching reach the API on the last
+// tool, the system prompt and the message before the newest one
+func TestPromptCachingRequestBody(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid request body: %v", err)
+		}
+		fmt.Fprint(w, okBody)
+	}))
+	defer server.Close()
+
+	tools := []Tool{
+		{Name: "read", InputSchema: map[string]interface{}{"type": "object"}},
+		{Name: "write", InputSchema: map[string]interface{}{"type": "object"}},
+	}
+	messages := []Message{
+		NewTextMessage("user", "Fix the bug."),
+		NewTextMessage("assistant", "Reading main.go."),
+		NewTextMessage("user", "It is fixed now?"),
+	}
+	req := NewRequest(messages, 100, WithSystem("You are a coder."), WithTools(tools...), WithPromptCaching())
+	if _, err := testLLM(server.URL).CreateMessage(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	cacheControl := func(block interface{}) interface{} {
+		return block.(map[string]interface{})["cache_control"]
+	}
+	ephemeral := map[string]interface{}{"type": "ephemeral"}
+	sentTools := body["tools"].([]interface{})
+	sentSystem := body["system"].([]interface{})
+	sentMessages := body["messages"].([]interface{})
+	content := func(i int) []interface{} {
+		return sentMessages[i].(map[string]interface{})["content"].([]interface{})
+	}
+
+	tests := []struct {
+		name  string
+		block interface{}
+		want  interface{}
+	}{
+		{name: "first tool", block: sentTools[0]},
+		{name: "last tool", block: sentTools[1], want: ephemeral},
+		{name: "system prompt", block: sentSystem[len(sentSystem)-1], want: ephemeral},
+		{name: "first message", block: content(0)[0]},
+		{name: "message before the newest", block: content(1)[0], want: ephemeral},
+		{name: "newest message", block: content(2)[0]},
+	}
+	for _, tt := range tests {
+		if got := cacheControl(tt.block); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: cache_control = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package llm
+
+// Option sets an optional parameter on a request
+type Option func(*AnthropicRequest)
+
+// NewRequest creates a request for the conversation with the given options applied
+func NewRequest(messages []Message, maxTokens int, opts ...Option) AnthropicRequest {
+	req := AnthropicRequest{
+		MaxTokens: maxTokens,
+		Messages:  messages,
+	}
+	req.Apply(opts...)
+	return req
+}
+
+// Apply sets the given options on the request
+func (r *AnthropicRequest) Apply(opts ...Option) {
+	for _, opt := range opts {
+		opt(r)
+	}
+}
+
+// WithModel overrides the client's default model
+func WithModel(model string) Option {
+	return func(r *AnthropicRequest) {
+		r.Model = model
+	}
+}
+
+// WithMaxTokens overrides the maximum number of tokens to generate
+func WithMaxTokens(maxTokens int) Option {
+	return func(r *AnthropicRequest) {
+		r.MaxTokens = maxTokens
+	}
+}
+
+// WithSystem sets the system prompt
+func WithSystem(prompt string) Option {
+	return func(r *AnthropicRequest) {
+		r.System = []ContentBlock{NewTextBlock(prompt)}
+	}
+}
+
+// WithSystemBlocks appends blocks to the system prompt, e.g. stable context
+// such as the goal and plan that should follow the agent's instructions
+func WithSystemBlocks(blocks ...ContentBlock) Option {
+	return func(r *AnthropicRequest) {
+		r.System = append(append([]ContentBlock(nil), r.System...), blocks...)
+	}
+}
+
+// WithTemperature sets the sampling temperature, between 0 and 1
+func WithTemperature(temperature float64) Option {
+	return func(r *AnthropicRequest) {
+		r.Temperature = &temperature
+	}
+}
+
+// WithTopP sets nucleus sampling, between 0 and 1
+func WithTopP(topP float64) Option {
+	return func(r *AnthropicRequest) {
+		r.TopP = &topP
+	}
+}
+
+// WithStopSequences sets custom sequences that stop generation
+func WithStopSequences(sequences ...string) Option {
+	return func(r *AnthropicRequest) {
+		r.StopSequences = sequences
+	}
+}
+
+// WithMetadata sets the request metadata
+func WithMetadata(userID string) Option {
+	return func(r *AnthropicRequest) {
+		r.Metadata = &Metadata{UserID: userID}
+	}
+}
+
+// WithTools offers tools to the model
+func WithTools(tools ...Tool) Option {
+	return func(r *AnthropicRequest) {
+		r.Tools = tools
+	}
+}
+
+// MaxCacheBreakpoints is the number of cache breakpoints allowed per request
+const MaxCacheBreakpoints = 4
+
+// WithPromptCaching marks the stable prefix of the request as cacheable:
+// the tool definitions, the system prompt and the conversation up to the
+// newest message. Breakpoints already set are kept and count towards the
+// limit. Apply it after the options that set tools, system and messages.
+func WithPromptCaching() Option {
+	return func(r *AnthropicRequest) {
+		breakpoints := countBreakpoints(r)
+
+		if n := len(r.Tools); n > 0 && r.Tools[n-1].CacheControl == nil && breakpoints < MaxCacheBreakpoints {
+			r.Tools = append([]Tool(nil), r.Tools...)
+			r.Tools[n-1].CacheControl = Ephemeral()
+			breakpoints++
+		}
+
+		if n := len(r.System); n > 0 && r.System[n-1].CacheControl == nil && breakpoints < MaxCacheBreakpoints {
+			r.System = append([]ContentBlock(nil), r.System...)
+			r.System[n-1].CacheControl = Ephemeral()
+			breakpoints++
+		}
+
+		// The newest message changes on every call, so cache up to the one
+		// before it.
+		if n := len(r.Messages); n > 1 && breakpoints < MaxCacheBreakpoints {
+			last := &r.Messages[n-2]
+			if k := len(last.Content); k > 0 && last.Content[k-1].CacheControl == nil {
+				r.Messages = append([]Message(nil), r.Messages...)
+				last = &r.Messages[n-2]
+				last.Content = append([]ContentBlock(nil), last.Content...)
+				last.Content[k-1].CacheControl = Ephemeral()
+			}
+		}
+	}
+}
+
+func countBreakpoints(r *AnthropicRequest) int {
+	count := 0
+	for _, tool := range r.Tools {
+		if tool.CacheControl != nil {
+			count++
+		}
+	}
+	for _, block := range r.System {
+		if block.CacheControl != nil {
+			count++
+		}
+	}
+	for _, message := range r.Messages {
+		for _, block := range message.Content {
+			if block.CacheControl != nil {
+				count++
+			}
+		}
+	}
+	return count
+}
@@ -0,0 +1,242 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Event types sent by the Messages API when streaming.
+// See https://docs.anthropic.com/en/api/messages-streaming
+const (
+	EventMessageStart      = "message_start"
+	EventContentBlockStart = "content_block_start"
+	EventContentBlockDelta = "content_block_delta"
+	EventContentBlockStop  = "content_block_stop"
+	EventMessageDelta      = "message_delta"
+	EventMessageStop       = "message_stop"
+	EventPing              = "ping"
+	EventError             = "error"
+)
+
+// StreamEvent is a single decoded event from a streamed response.
+// Only the fields relevant to the event Type are set. Block is set on
+// content_block_stop and holds the completed block, with tool_use input
+// assembled from its partial JSON deltas. Model and the input usage are set
+// on message_start, the output usage on message_delta.
+type StreamEvent struct {
+	Type       string
+	Model      string
+	Index      int
+	Text       string
+	Block      *ContentBlock
+	StopReason string
+	Usage      *Usage
+	Err        error
+
+	partialJSON string
+}
+
+// streamPayload is the union of the JSON payloads carried by stream events.
+type streamPayload struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message *struct {
+		Model string `json:"model"`
+		Usage Usage  `json:"usage"`
+	} `json:"message"`
+	ContentBlock *ContentBlock `json:"content_block"`
+	Delta        *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *Usage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// StreamResponse sends the conversation to the API with streaming enabled and
+// returns a channel of events. Text arrives incrementally as text deltas, the
+// stop reason and final usage arrive with the message_delta event. The channel
+// is closed when the stream ends, fails or ctx is cancelled. A failure mid-stream
+// is reported as a final event with Err set.
+func (l *LLM) StreamResponse(ctx context.Context, messages []Message, maxTokens int, opts ...Option) (<-chan StreamEvent, error) {
+	return l.StreamMessage(ctx, NewRequest(messages, maxTokens, opts...))
+}
+
+// StreamMessage is like StreamResponse but takes a full request, so tools
+// and other request options can be set.
+func (l *LLM) StreamMessage(ctx context.Context, requestBody AnthropicRequest) (<-chan StreamEvent, error) {
+	if requestBody.Model == "" {
+		requestBody.Model = l.Model
+	}
+	requestBody.Stream = true
+
+	resp, err := l.send(ctx, l.apiURL(), requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan StreamEvent)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+
+		emit := func(event StreamEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if err := readStream(resp.Body, emit); err != nil {
+			emit(StreamEvent{Type: EventError, Err: err})
+		}
+	}()
+
+	return events, nil
+}
+
+// readStream decodes server-sent events from r and passes them to emit until
+// the stream ends, an error event arrives or emit returns false.
+func readStream(r io.Reader, emit func(StreamEvent) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var eventType string
+	var data strings.Builder
+
+	// Blocks under construction, keyed by their index in the message.
+	blocks := make(map[int]*ContentBlock)
+	partialJSON := make(map[int]*strings.Builder)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case line == "":
+			if data.Len() == 0 {
+				eventType = ""
+				continue
+			}
+			event, err := decodeStreamEvent(eventType, data.String())
+			eventType = ""
+			data.Reset()
+			if err != nil {
+				return err
+			}
+			if event.Type == EventPing {
+				continue
+			}
+			assembleBlock(event, blocks, partialJSON)
+			if !emit(*event) {
+				return nil
+			}
+			if event.Type == EventMessageStop {
+				return nil
+			}
+		case strings.HasPrefix(line, ":"):
+			// Comment line, used as a keep-alive.
+		case strings.HasPrefix(line, "event:"):
+			eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading stream: %v", err)
+	}
+	return fmt.Errorf("stream ended before message_stop")
+}
+
+// assembleBlock accumulates content block events so the completed block can be
+// attached to its content_block_stop event.
+func assembleBlock(event *StreamEvent, blocks map[int]*ContentBlock, partialJSON map[int]*strings.Builder) {
+	switch event.Type {
+	case EventContentBlockStart:
+		if event.Block != nil {
+			blocks[event.Index] = event.Block
+			partialJSON[event.Index] = &strings.Builder{}
+		}
+		event.Block = nil
+	case EventContentBlockDelta:
+		block, ok := blocks[event.Index]
+		if !ok {
+			return
+		}
+		block.Text += event.Text
+		partialJSON[event.Index].WriteString(event.partialJSON)
+	case EventContentBlockStop:
+		block, ok := blocks[event.Index]
+		if !ok {
+			return
+		}
+		if block.Type == BlockToolUse {
+			if input := partialJSON[event.Index].String(); input != "" {
+				block.Input = json.RawMessage(input)
+			} else if len(block.Input) == 0 {
+				block.Input = json.RawMessage("{}")
+			}
+		}
+		event.Block = block
+		delete(blocks, event.Index)
+		delete(partialJSON, event.Index)
+	}
+}
+
+func decodeStreamEvent(eventType, data string) (*StreamEvent, error) {
+	var payload streamPayload
+	if err := json.Unmarshal([]byte(data), &payload); err != nil {
+		return nil, fmt.Errorf("error unmarshalling stream event: %v", err)
+	}
+
+	if eventType == "" {
+		eventType = payload.Type
+	}
+	event := &StreamEvent{Type: eventType, Index: payload.Index}
+
+	switch eventType {
+	case EventMessageStart:
+		if payload.Message != nil {
+			usage := payload.Message.Usage
+			event.Model = payload.Message.Model
+			event.Usage = &usage
+		}
+	case EventContentBlockStart:
+		event.Block = payload.ContentBlock
+	case EventContentBlockDelta:
+		if payload.Delta != nil {
+			switch payload.Delta.Type {
+			case "text_delta":
+				event.Text = payload.Delta.Text
+			case "input_json_delta":
+				event.partialJSON = payload.Delta.PartialJSON
+			}
+		}
+	case EventMessageDelta:
+		if payload.Delta != nil {
+			event.StopReason = payload.Delta.StopReason
+		}
+		event.Usage = payload.Usage
+	case EventError:
+		if payload.Error != nil {
+			return nil, &APIError{Type: payload.Error.Type, Message: payload.Error.Message}
+		}
+		return nil, fmt.Errorf("stream error: %s", data)
+	}
+
+	return event, nil
+}
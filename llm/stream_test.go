@@ -0,0 +1,171 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// replayServer serves a recorded event stream from testdata to every
+// request, checking that the request asked for streaming
+func replayServer(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	stream, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body AnthropicRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid request body: %v", err)
+		}
+		if !body.Stream {
+			t.Errorf("request did not ask for a stream")
+		}
+		w.Header().Set("content-type", "text/event-stream")
+		w.Write(stream)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func streamFrom(t *testing.T, name string) []StreamEvent {
+	t.Helper()
+	server := replayServer(t, name)
+	l := &LLM{APIKey: "test", APIURL: server.URL}
+
+	events, err := l.StreamResponse(context.Background(), []Message{NewTextMessage("user", "Hi")}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var collected []StreamEvent
+	for event := range events {
+		collected = append(collected, event)
+	}
+	return collected
+}
+
+func TestStreamText(t *testing.T) {
+	events := streamFrom(t, "stream_text.sse")
+
+	var text strings.Builder
+	var stopReason string
+	var usage Usage
+	for _, event := range events {
+		if event.Err != nil {
+			t.Fatalf("unexpected error: %v", event.Err)
+		}
+		if event.Type == EventPing {
+			t.Errorf("ping events should be skipped")
+		}
+		text.WriteString(event.Text)
+		if event.StopReason != "" {
+			stopReason = event.StopReason
+		}
+		if event.Usage != nil {
+			usage = usage.Add(*event.Usage)
+		}
+	}
+
+	if got := text.String(); got != "Hello, world!" {
+		t.Errorf("text = %q, want %q", got, "Hello, world!")
+	}
+	if stopReason != "end_turn" {
+		t.Errorf("stop reason = %q, want end_turn", stopReason)
+	}
+	if usage.InputTokens != 25 || usage.OutputTokens != 16 {
+		t.Errorf("usage = %+v, want 25 input and 16 output tokens", usage)
+	}
+	if last := events[len(events)-1]; last.Type != EventMessageStop {
+		t.Errorf("last event = %s, want message_stop", last.Type)
+	}
+}
+
+func TestStreamToolUse(t *testing.T) {
+	var blocks []*ContentBlock
+	for _, event := range streamFrom(t, "stream_tool_use.sse") {
+		if event.Err != nil {
+			t.Fatalf("unexpected error: %v", event.Err)
+		}
+		if event.Type == EventContentBlockStop {
+			blocks = append(blocks, event.Block)
+		}
+	}
+
+	if len(blocks) != 2 {
+		t.Fatalf("got %d completed blocks, want 2", len(blocks))
+	}
+	if blocks[0].Type != BlockText || blocks[0].Text != "Let me check." {
+		t.Errorf("first block = %+v, want the text", blocks[0])
+	}
+	tool := blocks[1]
+	if tool.Type != BlockToolUse || tool.Name != "run" || tool.ID != "toolu_01T1x1fJ34qAmk2tNTrN7Up6" {
+		t.Fatalf("second block = %+v, want the run tool_use", tool)
+	}
+	var input struct {
+		Command string `json:"command"`
+	}
+	if err := json.Unmarshal(tool.Input, &input); err != nil {
+		t.Fatalf("tool input %s is not valid JSON: %v", tool.Input, err)
+	}
+	if input.Command != "ls -la" {
+		t.Errorf("command = %q, want %q", input.Command, "ls -la")
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	events := streamFrom(t, "stream_error.sse")
+
+	last := events[len(events)-1]
+	if last.Err == nil {
+		t.Fatalf("last event = %+v, want an error", last)
+	}
+	if !errors.Is(last.Err, ErrOverloaded) {
+		t.Errorf("error = %v, want ErrOverloaded", last.Err)
+	}
+	if events[len(events)-2].Text != "Partial" {
+		t.Errorf("text before the error was not delivered")
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	stream, err := os.ReadFile(filepath.Join("testdata", "stream_text.sse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := string(stream[:strings.Index(string(stream), "event: message_delta")])
+
+	var last StreamEvent
+	err = readStream(strings.NewReader(truncated), func(event StreamEvent) bool {
+		last = event
+		return true
+	})
+	if err == nil {
+		t.Fatalf("want an error for a stream without message_stop")
+	}
+	if last.Type != EventContentBlockStop {
+		t.Errorf("last event = %s, want content_block_stop", last.Type)
+	}
+}
+
+func TestStreamCancelled(t *testing.T) {
+	server := replayServer(t, "stream_text.sse")
+	l := &LLM{APIKey: "test", APIURL: server.URL}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := l.StreamResponse(ctx, []Message{NewTextMessage("user", "Hi")}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-events
+	cancel()
+	for range events {
+	}
+}
@@ -1,9 +1,14 @@
 package action
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 
+	"github.com/openagentsinc/autodev/pkg/diff"
 	"github.com/openagentsinc/autodev/pkg/observation"
+	"github.com/openagentsinc/autodev/pkg/plan"
+	"github.com/openagentsinc/autodev/pkg/workspace"
 )
 
 // ActionType represents the type of action
@@ -16,6 +21,8 @@ const (
 	TypeBrowse     ActionType = "BROWSE"
 	TypeRead       ActionType = "READ"
 	TypeWrite      ActionType = "WRITE"
+	TypeEdit       ActionType = "EDIT"
+	TypePatch      ActionType = "PATCH"
 	TypeRecall     ActionType = "RECALL"
 	TypeThink      ActionType = "THINK"
 	TypeEcho       ActionType = "ECHO"
@@ -41,19 +48,6 @@ type BaseAction struct {
 	ActionType ActionType `json:"action"`
 }
 
-func (ba BaseAction) ToMemory() map[string]interface{} {
-	return map[string]interface{}{
-		"action": ba.ActionType,
-		"args":   map[string]interface{}{},
-	}
-}
-
-func (ba BaseAction) ToDict() map[string]interface{} {
-	dict := ba.ToMemory()
-	dict["message"] = ba.Message()
-	return dict
-}
-
 func (ba BaseAction) Message() string {
 	return ""
 }
@@ -62,6 +56,26 @@ func (ba BaseAction) Type() ActionType {
 	return ba.ActionType
 }
 
+// toMemory returns the dictionary form of an action, with its fields as
+// args, which ActionFromDict turns back into the action
+func toMemory(a Action) map[string]interface{} {
+	args := make(map[string]interface{})
+	data, _ := json.Marshal(a)
+	json.Unmarshal(data, &args)
+	delete(args, "action")
+	return map[string]interface{}{
+		"action": string(a.Type()),
+		"args":   args,
+	}
+}
+
+// toDict returns the dictionary form of an action with its message
+func toDict(a Action) map[string]interface{} {
+	dict := a.ToMemory()
+	dict["message"] = a.Message()
+	return dict
+}
+
 // NullAction represents an action that does nothing
 type NullAction struct {
 	BaseAction
@@ -79,6 +93,14 @@ func (na NullAction) IsExecutable() bool {
 	return false
 }
 
+func (na NullAction) ToMemory() map[string]interface{} {
+	return toMemory(na)
+}
+
+func (na NullAction) ToDict() map[string]interface{} {
+	return toDict(na)
+}
+
 func (na NullAction) Message() string {
 	return "No action"
 }
@@ -106,6 +128,14 @@ func (cra CmdRunAction) IsExecutable() bool {
 	return true
 }
 
+func (cra CmdRunAction) ToMemory() map[string]interface{} {
+	return toMemory(cra)
+}
+
+func (cra CmdRunAction) ToDict() map[string]interface{} {
+	return toDict(cra)
+}
+
 func (cra CmdRunAction) Message() string {
 	return fmt.Sprintf("Running command: %s", cra.Command)
 }
@@ -131,6 +161,14 @@ func (cka CmdKillAction) IsExecutable() bool {
 	return true
 }
 
+func (cka CmdKillAction) ToMemory() map[string]interface{} {
+	return toMemory(cka)
+}
+
+func (cka CmdKillAction) ToDict() map[string]interface{} {
+	return toDict(cka)
+}
+
 func (cka CmdKillAction) Message() string {
 	return fmt.Sprintf("Killing command: %d", cka.ID)
 }
@@ -149,14 +187,25 @@ func NewBrowseURLAction(url string) *BrowseURLAction {
 }
 
 func (bua BrowseURLAction) Run(controller AgentController) (observation.Observation, error) {
-	// Implement browser functionality here
-	return nil, fmt.Errorf("BrowseURLAction not implemented")
+	browser, ok := controller.(Browser)
+	if !ok {
+		return nil, fmt.Errorf("browsing is not supported")
+	}
+	return browser.Browse(bua.URL)
 }
 
 func (bua BrowseURLAction) IsExecutable() bool {
 	return true
 }
 
+func (bua BrowseURLAction) ToMemory() map[string]interface{} {
+	return toMemory(bua)
+}
+
+func (bua BrowseURLAction) ToDict() map[string]interface{} {
+	return toDict(bua)
+}
+
 func (bua BrowseURLAction) Message() string {
 	return fmt.Sprintf("Browsing URL: %s", bua.URL)
 }
@@ -179,14 +228,29 @@ func NewFileReadAction(path string, start, end int) *FileReadAction {
 }
 
 func (fra FileReadAction) Run(controller AgentController) (observation.Observation, error) {
-	// Implement file read functionality here
-	return nil, fmt.Errorf("FileReadAction not implemented")
+	ws := controller.Workspace()
+	if ws == nil {
+		return nil, fmt.Errorf("no workspace to read %s from", fra.Path)
+	}
+	content, err := workspace.ReadLines(ws, fra.Path, fra.Start, fra.End)
+	if err != nil {
+		return nil, err
+	}
+	return observation.NewFileReadObservation(content, fra.Path), nil
 }
 
 func (fra FileReadAction) IsExecutable() bool {
 	return true
 }
 
+func (fra FileReadAction) ToMemory() map[string]interface{} {
+	return toMemory(fra)
+}
+
+func (fra FileReadAction) ToDict() map[string]interface{} {
+	return toDict(fra)
+}
+
 func (fra FileReadAction) Message() string {
 	return fmt.Sprintf("Reading file: %s", fra.Path)
 }
@@ -211,18 +275,135 @@ func NewFileWriteAction(path, content string, start, end int) *FileWriteAction {
 }
 
 func (fwa FileWriteAction) Run(controller AgentController) (observation.Observation, error) {
-	// Implement file write functionality here
-	return nil, fmt.Errorf("FileWriteAction not implemented")
+	ws := controller.Workspace()
+	if ws == nil {
+		return nil, fmt.Errorf("no workspace to write %s to", fwa.Path)
+	}
+	if err := workspace.WriteLines(ws, fwa.Path, fwa.Content, fwa.Start, fwa.End); err != nil {
+		return nil, err
+	}
+	return observation.NewFileWriteObservation("", fwa.Path), nil
 }
 
 func (fwa FileWriteAction) IsExecutable() bool {
 	return true
 }
 
+func (fwa FileWriteAction) ToMemory() map[string]interface{} {
+	return toMemory(fwa)
+}
+
+func (fwa FileWriteAction) ToDict() map[string]interface{} {
+	return toDict(fwa)
+}
+
 func (fwa FileWriteAction) Message() string {
 	return fmt.Sprintf("Writing file: %s", fwa.Path)
 }
 
+// FileEditAction represents an action to replace an exact string in a file
+type FileEditAction struct {
+	BaseAction
+	Path    string `json:"path"`
+	Search  string `json:"search"`
+	Replace string `json:"replace"`
+}
+
+func NewFileEditAction(path, search, replace string) *FileEditAction {
+	return &FileEditAction{
+		BaseAction: BaseAction{ActionType: TypeEdit},
+		Path:       path,
+		Search:     search,
+		Replace:    replace,
+	}
+}
+
+func (fea FileEditAction) Run(controller AgentController) (observation.Observation, error) {
+	return editFile(controller, fea.Path, func(text string) (string, error) {
+		return diff.Replace(text, fea.Search, fea.Replace)
+	})
+}
+
+func (fea FileEditAction) IsExecutable() bool {
+	return true
+}
+
+func (fea FileEditAction) ToMemory() map[string]interface{} {
+	return toMemory(fea)
+}
+
+func (fea FileEditAction) ToDict() map[string]interface{} {
+	return toDict(fea)
+}
+
+func (fea FileEditAction) Message() string {
+	return fmt.Sprintf("Editing file: %s", fea.Path)
+}
+
+// FilePatchAction represents an action to apply a unified diff to a file
+type FilePatchAction struct {
+	BaseAction
+	Path  string `json:"path"`
+	Patch string `json:"patch"`
+}
+
+func NewFilePatchAction(path, patch string) *FilePatchAction {
+	return &FilePatchAction{
+		BaseAction: BaseAction{ActionType: TypePatch},
+		Path:       path,
+		Patch:      patch,
+	}
+}
+
+func (fpa FilePatchAction) Run(controller AgentController) (observation.Observation, error) {
+	return editFile(controller, fpa.Path, func(text string) (string, error) {
+		return diff.Apply(text, fpa.Patch)
+	})
+}
+
+func (fpa FilePatchAction) IsExecutable() bool {
+	return true
+}
+
+func (fpa FilePatchAction) ToMemory() map[string]interface{} {
+	return toMemory(fpa)
+}
+
+func (fpa FilePatchAction) ToDict() map[string]interface{} {
+	return toDict(fpa)
+}
+
+func (fpa FilePatchAction) Message() string {
+	return fmt.Sprintf("Patching file: %s", fpa.Path)
+}
+
+// editFile rewrites a file in the workspace with edit, returning an
+// observation with the diff of the change
+func editFile(controller AgentController, path string, edit func(string) (string, error)) (observation.Observation, error) {
+	ws := controller.Workspace()
+	if ws == nil {
+		return nil, fmt.Errorf("no workspace to edit %s in", path)
+	}
+
+	data, err := workspace.ReadFile(ws, path)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := edit(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to edit %s: %v", path, err)
+	}
+	if err := workspace.WriteFile(ws, path, []byte(updated)); err != nil {
+		return nil, err
+	}
+
+	changes := diff.Unified("a/"+path, "b/"+path, string(data), updated)
+	if changes == "" {
+		changes = "The file is unchanged."
+	}
+	return observation.NewFileWriteObservation(changes, path), nil
+}
+
 // AgentRecallAction represents an action for the agent to recall information
 type AgentRecallAction struct {
 	BaseAction
@@ -238,13 +419,30 @@ func NewAgentRecallAction(query string) *AgentRecallAction {
 
 func (ara AgentRecallAction) Run(controller AgentController) (observation.Observation, error) {
 	memories := controller.Agent().SearchMemory(ara.Query)
-	return observation.NewAgentRecallObservation("Recalling memories...", memories), nil
+	if len(memories) == 0 {
+		return observation.NewAgentRecallObservation("No relevant memories found.", memories), nil
+	}
+
+	var content strings.Builder
+	fmt.Fprintf(&content, "Memories relevant to %q, most relevant first:", ara.Query)
+	for i, memory := range memories {
+		fmt.Fprintf(&content, "\n\n[%d]\n%s", i+1, memory)
+	}
+	return observation.NewAgentRecallObservation(content.String(), memories), nil
 }
 
 func (ara AgentRecallAction) IsExecutable() bool {
 	return true
 }
 
+func (ara AgentRecallAction) ToMemory() map[string]interface{} {
+	return toMemory(ara)
+}
+
+func (ara AgentRecallAction) ToDict() map[string]interface{} {
+	return toDict(ara)
+}
+
 func (ara AgentRecallAction) Message() string {
 	return fmt.Sprintf("Recalling: %s", ara.Query)
 }
@@ -270,16 +468,299 @@ func (ata AgentThinkAction) IsExecutable() bool {
 	return false
 }
 
+func (ata AgentThinkAction) ToMemory() map[string]interface{} {
+	return toMemory(ata)
+}
+
+func (ata AgentThinkAction) ToDict() map[string]interface{} {
+	return toDict(ata)
+}
+
 func (ata AgentThinkAction) Message() string {
 	return ata.Thought
 }
 
-// Implement other action types (AgentEchoAction, AgentSummarizeAction, AgentFinishAction, AgentDelegateAction, AddTaskAction, ModifyTaskAction) similarly...
+// AgentFinishAction signals that the agent has completed its task
+type AgentFinishAction struct {
+	BaseAction
+	Outputs map[string]interface{} `json:"outputs"`
+	Thought string                 `json:"thought"`
+}
+
+func NewAgentFinishAction(outputs map[string]interface{}, thought string) *AgentFinishAction {
+	return &AgentFinishAction{
+		BaseAction: BaseAction{ActionType: TypeFinish},
+		Outputs:    outputs,
+		Thought:    thought,
+	}
+}
+
+func (afa AgentFinishAction) Run(controller AgentController) (observation.Observation, error) {
+	controller.Finish(afa.Outputs)
+	return observation.NewNullObservation(), nil
+}
+
+func (afa AgentFinishAction) IsExecutable() bool {
+	return true
+}
+
+func (afa AgentFinishAction) ToMemory() map[string]interface{} {
+	return toMemory(afa)
+}
+
+func (afa AgentFinishAction) ToDict() map[string]interface{} {
+	return toDict(afa)
+}
+
+func (afa AgentFinishAction) Message() string {
+	if afa.Thought != "" {
+		return afa.Thought
+	}
+	return "All done! What's next on the agenda?"
+}
+
+// AgentEchoAction represents an action for the agent to send a message
+type AgentEchoAction struct {
+	BaseAction
+	Content string `json:"content"`
+}
+
+func NewAgentEchoAction(content string) *AgentEchoAction {
+	return &AgentEchoAction{
+		BaseAction: BaseAction{ActionType: TypeEcho},
+		Content:    content,
+	}
+}
+
+func (aea AgentEchoAction) Run(controller AgentController) (observation.Observation, error) {
+	return observation.NewAgentMessageObservation(aea.Content), nil
+}
+
+func (aea AgentEchoAction) IsExecutable() bool {
+	return true
+}
+
+func (aea AgentEchoAction) ToMemory() map[string]interface{} {
+	return toMemory(aea)
+}
+
+func (aea AgentEchoAction) ToDict() map[string]interface{} {
+	return toDict(aea)
+}
+
+func (aea AgentEchoAction) Message() string {
+	return aea.Content
+}
+
+// AgentSummarizeAction represents a summary of the agent's progress
+type AgentSummarizeAction struct {
+	BaseAction
+	Summary string `json:"summary"`
+}
+
+func NewAgentSummarizeAction(summary string) *AgentSummarizeAction {
+	return &AgentSummarizeAction{
+		BaseAction: BaseAction{ActionType: TypeSummarize},
+		Summary:    summary,
+	}
+}
+
+func (asa AgentSummarizeAction) Run(controller AgentController) (observation.Observation, error) {
+	return nil, fmt.Errorf("AgentSummarizeAction is not executable")
+}
+
+func (asa AgentSummarizeAction) IsExecutable() bool {
+	return false
+}
+
+func (asa AgentSummarizeAction) ToMemory() map[string]interface{} {
+	return toMemory(asa)
+}
+
+func (asa AgentSummarizeAction) ToDict() map[string]interface{} {
+	return toDict(asa)
+}
+
+func (asa AgentSummarizeAction) Message() string {
+	return asa.Summary
+}
+
+// AgentDelegateAction represents an action to hand a task to another agent
+type AgentDelegateAction struct {
+	BaseAction
+	Agent   string                 `json:"agent"`
+	Inputs  map[string]interface{} `json:"inputs"`
+	Thought string                 `json:"thought"`
+}
+
+func NewAgentDelegateAction(agent string, inputs map[string]interface{}, thought string) *AgentDelegateAction {
+	return &AgentDelegateAction{
+		BaseAction: BaseAction{ActionType: TypeDelegate},
+		Agent:      agent,
+		Inputs:     inputs,
+		Thought:    thought,
+	}
+}
+
+func (ada AgentDelegateAction) Run(controller AgentController) (observation.Observation, error) {
+	delegator, ok := controller.(Delegator)
+	if !ok {
+		return nil, fmt.Errorf("delegating to %s is not supported", ada.Agent)
+	}
+	return delegator.Delegate(ada.Agent, ada.Inputs)
+}
+
+func (ada AgentDelegateAction) IsExecutable() bool {
+	return true
+}
+
+func (ada AgentDelegateAction) ToMemory() map[string]interface{} {
+	return toMemory(ada)
+}
+
+func (ada AgentDelegateAction) ToDict() map[string]interface{} {
+	return toDict(ada)
+}
+
+func (ada AgentDelegateAction) Message() string {
+	return fmt.Sprintf("I'm asking %s for help with this task.", ada.Agent)
+}
+
+// AddTaskAction represents an action to add a task to the plan
+type AddTaskAction struct {
+	BaseAction
+	Parent   string   `json:"parent"`
+	Goal     string   `json:"goal"`
+	Subtasks []string `json:"subtasks"`
+	Thought  string   `json:"thought"`
+}
+
+func NewAddTaskAction(parent, goal string, subtasks []string, thought string) *AddTaskAction {
+	return &AddTaskAction{
+		BaseAction: BaseAction{ActionType: TypeAddTask},
+		Parent:     parent,
+		Goal:       goal,
+		Subtasks:   subtasks,
+		Thought:    thought,
+	}
+}
+
+// Run adds the task under its parent, along with any subtasks given by
+// their goals
+func (ata AddTaskAction) Run(controller AgentController) (observation.Observation, error) {
+	p := controller.Plan()
+	if p == nil {
+		return nil, fmt.Errorf("there is no plan to add a task to")
+	}
+
+	parentID := ata.Parent
+	if parentID == "" {
+		parentID = p.Task.ID
+	}
+	err := p.Change(plan.ActorAgent, ata.Thought, func() error {
+		if err := p.AddSubtask(parentID, ata.Goal, nil); err != nil {
+			return err
+		}
+		parent, _ := p.GetTaskByID(parentID)
+		task := parent.Subtasks[len(parent.Subtasks)-1]
+		for _, goal := range ata.Subtasks {
+			if err := p.AddSubtask(task.ID, goal, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return observation.NewNullObservation(), nil
+}
+
+func (ata AddTaskAction) IsExecutable() bool {
+	return true
+}
+
+func (ata AddTaskAction) ToMemory() map[string]interface{} {
+	return toMemory(ata)
+}
+
+func (ata AddTaskAction) ToDict() map[string]interface{} {
+	return toDict(ata)
+}
+
+func (ata AddTaskAction) Message() string {
+	return fmt.Sprintf("Added task: %s", ata.Goal)
+}
+
+// ModifyTaskAction represents an action to change the state of a task
+type ModifyTaskAction struct {
+	BaseAction
+	ID      string `json:"id"`
+	State   string `json:"state"`
+	Thought string `json:"thought"`
+}
+
+func NewModifyTaskAction(id, state, thought string) *ModifyTaskAction {
+	return &ModifyTaskAction{
+		BaseAction: BaseAction{ActionType: TypeModifyTask},
+		ID:         id,
+		State:      state,
+		Thought:    thought,
+	}
+}
+
+func (mta ModifyTaskAction) Run(controller AgentController) (observation.Observation, error) {
+	p := controller.Plan()
+	if p == nil {
+		return nil, fmt.Errorf("there is no plan to modify")
+	}
+	err := p.Change(plan.ActorAgent, mta.Thought, func() error {
+		return p.SetSubtaskState(mta.ID, mta.State)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return observation.NewNullObservation(), nil
+}
+
+func (mta ModifyTaskAction) IsExecutable() bool {
+	return true
+}
+
+func (mta ModifyTaskAction) ToMemory() map[string]interface{} {
+	return toMemory(mta)
+}
+
+func (mta ModifyTaskAction) ToDict() map[string]interface{} {
+	return toDict(mta)
+}
+
+func (mta ModifyTaskAction) Message() string {
+	return fmt.Sprintf("Set task %s to %s", mta.ID, mta.State)
+}
 
 // AgentController interface (to be implemented elsewhere)
 type AgentController interface {
 	ActionManager() ActionManager
 	Agent() Agent
+	Workspace() workspace.FS
+
+	// Plan returns the plan the agent is working on, if any
+	Plan() *plan.Plan
+
+	// Finish records the agent's outputs and marks it complete
+	Finish(outputs map[string]interface{})
+}
+
+// Browser is implemented by controllers that can fetch web pages
+type Browser interface {
+	Browse(url string) (observation.Observation, error)
+}
+
+// Delegator is implemented by controllers that can hand tasks to other
+// agents
+type Delegator interface {
+	Delegate(agent string, inputs map[string]interface{}) (observation.Observation, error)
 }
 
 // ActionManager interface (to be implemented elsewhere)
@@ -321,21 +802,72 @@ func ActionFromDict(actionMap map[string]interface{}) (Action, error) {
 	case TypeRead:
 		path, _ := args["path"].(string)
 		start, _ := args["start"].(float64)
-		end, _ := args["end"].(float64)
+		end, ok := args["end"].(float64)
+		if !ok {
+			end = workspace.EOF
+		}
 		return NewFileReadAction(path, int(start), int(end)), nil
 	case TypeWrite:
 		path, _ := args["path"].(string)
 		content, _ := args["content"].(string)
 		start, _ := args["start"].(float64)
-		end, _ := args["end"].(float64)
+		end, ok := args["end"].(float64)
+		if !ok {
+			end = workspace.EOF
+		}
 		return NewFileWriteAction(path, content, int(start), int(end)), nil
+	case TypeEdit:
+		path, _ := args["path"].(string)
+		search, _ := args["search"].(string)
+		replace, _ := args["replace"].(string)
+		return NewFileEditAction(path, search, replace), nil
+	case TypePatch:
+		path, _ := args["path"].(string)
+		patch, _ := args["patch"].(string)
+		return NewFilePatchAction(path, patch), nil
 	case TypeRecall:
 		query, _ := args["query"].(string)
 		return NewAgentRecallAction(query), nil
 	case TypeThink:
 		thought, _ := args["thought"].(string)
 		return NewAgentThinkAction(thought), nil
-	// Implement other action types...
+	case TypeFinish:
+		outputs, _ := args["outputs"].(map[string]interface{})
+		thought, _ := args["thought"].(string)
+		return NewAgentFinishAction(outputs, thought), nil
+	case TypeEcho:
+		content, _ := args["content"].(string)
+		return NewAgentEchoAction(content), nil
+	case TypeSummarize:
+		summary, _ := args["summary"].(string)
+		return NewAgentSummarizeAction(summary), nil
+	case TypeDelegate:
+		agent, _ := args["agent"].(string)
+		inputs, _ := args["inputs"].(map[string]interface{})
+		thought, _ := args["thought"].(string)
+		return NewAgentDelegateAction(agent, inputs, thought), nil
+	case TypeAddTask:
+		parent, _ := args["parent"].(string)
+		goal, _ := args["goal"].(string)
+		thought, _ := args["thought"].(string)
+		var subtasks []string
+		list, _ := args["subtasks"].([]interface{})
+		for _, item := range list {
+			switch subtask := item.(type) {
+			case string:
+				subtasks = append(subtasks, subtask)
+			case map[string]interface{}:
+				if goal, ok := subtask["goal"].(string); ok {
+					subtasks = append(subtasks, goal)
+				}
+			}
+		}
+		return NewAddTaskAction(parent, goal, subtasks, thought), nil
+	case TypeModifyTask:
+		id, _ := args["id"].(string)
+		state, _ := args["state"].(string)
+		thought, _ := args["thought"].(string)
+		return NewModifyTaskAction(id, state, thought), nil
 	default:
 		return nil, fmt.Errorf("unknown action type: %s", actionType)
 	}
@@ -0,0 +1,81 @@
+package action
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openagentsinc/autodev/pkg/workspace"
+)
+
+var actionTypes = []ActionType{
+	TypeNull, TypeRun, TypeKill, TypeBrowse, TypeRead, TypeWrite, TypeEdit, TypePatch,
+	TypeRecall, TypeThink, TypeEcho, TypeSummarize, TypeFinish, TypeDelegate,
+	TypeAddTask, TypeModifyTask,
+}
+
+// An action's dictionary form turns back into the same action
+func TestActionFromDict(t *testing.T) {
+	tests := []Action{
+		NewNullAction(),
+		NewCmdRunAction("go test ./...", false),
+		NewCmdRunAction("npm run dev", true),
+		NewCmdKillAction(2),
+		NewBrowseURLAction("http://localhost:3000"),
+		NewFileReadAction("main.go", 0, workspace.EOF),
+		NewFileReadAction("main.go", 10, 20),
+		NewFileWriteAction("main.go", "package main\n", 0, workspace.EOF),
+		NewFileWriteAction("main.go", "\treturn nil\n", 4, 5),
+		NewFileEditAction("main.go", "\treturn nil\n", "\treturn err\n"),
+		NewFileEditAction("main.go", "// TODO\n", ""),
+		NewFilePatchAction("main.go", "@@ -1 +1 @@\n-package main\n+package server\n"),
+		NewAgentRecallAction("how are tests run"),
+		NewAgentThinkAction("the handler needs a lock"),
+		NewAgentEchoAction("hello"),
+		NewAgentSummarizeAction("fixed the handler"),
+		NewAgentFinishAction(map[string]interface{}{"summary": "done", "files": []interface{}{"main.go"}}, "all tests pass"),
+		NewAgentFinishAction(nil, ""),
+		NewAgentDelegateAction("verifier", map[string]interface{}{"task": "run the tests", "attempt": float64(2)}, "check the fix"),
+		NewAgentDelegateAction("browser", nil, ""),
+		NewAddTaskAction("0.1", "write tests", []string{"handler", "store"}, "split the work"),
+		NewAddTaskAction("", "ship it", nil, ""),
+		NewModifyTaskAction("0.1", "completed", "tests pass"),
+	}
+
+	covered := make(map[ActionType]bool)
+	for _, want := range tests {
+		covered[want.Type()] = true
+		dict := want.ToDict()
+		if dict["message"] != want.Message() {
+			t.Errorf("%s: ToDict() message = %v, want %q", want.Type(), dict["message"], want.Message())
+		}
+		got, err := ActionFromDict(dict)
+		if err != nil {
+			t.Errorf("ActionFromDict(%v) error = %v", dict, err)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("ActionFromDict(%v) = %#v, want %#v", dict, got, want)
+		}
+	}
+	for _, actionType := range actionTypes {
+		if !covered[actionType] {
+			t.Errorf("no round trip test for %s", actionType)
+		}
+	}
+}
+
+func TestActionFromDictErrors(t *testing.T) {
+	tests := []struct {
+		dict map[string]interface{}
+		err  string
+	}{
+		{dict: map[string]interface{}{"args": map[string]interface{}{}}, err: "'action' key"},
+		{dict: map[string]interface{}{"action": "RUN"}, err: "'args' key"},
+		{dict: map[string]interface{}{"action": "FLY", "args": map[string]interface{}{}}, err: "unknown action type: FLY"},
+	}
+	for _, tt := range tests {
+		_, err := ActionFromDict(tt.dict)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ActionFromDict(%v) error = %v, want %q", tt.dict, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/openagentsinc/autodev/llm"
+	"github.com/openagentsinc/autodev/pkg/observation"
+)
+
+// toolActions lists the action types offered to the model as tools, with
+// their descriptions and input properties.
+var toolActions = []struct {
+	actionType  ActionType
+	description string
+	properties  map[string]interface{}
+	required    []string
+}{
+	{
+		actionType:  TypeRun,
+		description: "Run a shell command in the sandbox and return its output and exit code.",
+		properties: map[string]interface{}{
+			"command":    map[string]interface{}{"type": "string", "description": "The shell command to run"},
+			"background": map[string]interface{}{"type": "boolean", "description": "Run the command in the background"},
+		},
+		required: []string{"command"},
+	},
+	{
+		actionType:  TypeKill,
+		description: "Kill a background command by its id.",
+		properties: map[string]interface{}{
+			"id": map[string]interface{}{"type": "integer", "description": "The id of the background command"},
+		},
+		required: []string{"id"},
+	},
+	{
+		actionType:  TypeBrowse,
+		description: "Open a URL in the browser and return the page content.",
+		properties: map[string]interface{}{
+			"url": map[string]interface{}{"type": "string", "description": "The URL to open"},
+		},
+		required: []string{"url"},
+	},
+	{
+		actionType:  TypeRead,
+		description: "Read a file, optionally limited to a range of lines.",
+		properties: map[string]interface{}{
+			"path":  map[string]interface{}{"type": "string", "description": "Path of the file to read"},
+			"start": map[string]interface{}{"type": "integer", "description": "First line to read, starting at 0"},
+			"end":   map[string]interface{}{"type": "integer", "description": "Line to stop reading at, or -1 for the end of the file"},
+		},
+		required: []string{"path"},
+	},
+	{
+		actionType:  TypeWrite,
+		description: "Write content to a file, optionally replacing only a range of lines.",
+		properties: map[string]interface{}{
+			"path":    map[string]interface{}{"type": "string", "description": "Path of the file to write"},
+			"content": map[string]interface{}{"type": "string", "description": "The content to write"},
+			"start":   map[string]interface{}{"type": "integer", "description": "First line to replace, starting at 0"},
+			"end":     map[string]interface{}{"type": "integer", "description": "Line to stop replacing at, or -1 for the end of the file"},
+		},
+		required: []string{"path", "content"},
+	},
+	{
+		actionType:  TypeEdit,
+		description: "Replace an exact piece of text in a file. The search text must appear exactly once, so include enough surrounding lines to make it unique.",
+		properties: map[string]interface{}{
+			"path":    map[string]interface{}{"type": "string", "description": "Path of the file to edit"},
+			"search":  map[string]interface{}{"type": "string", "description": "The exact text to replace, including whitespace"},
+			"replace": map[string]interface{}{"type": "string", "description": "The text to replace it with"},
+		},
+		required: []string{"path", "search", "replace"},
+	},
+	{
+		actionType:  TypePatch,
+		description: "Apply a unified diff to a file. Hunks are located by their context lines, so line numbers may be approximate.",
+		properties: map[string]interface{}{
+			"path":  map[string]interface{}{"type": "string", "description": "Path of the file to patch"},
+			"patch": map[string]interface{}{"type": "string", "description": "The unified diff, with @@ hunk headers"},
+		},
+		required: []string{"path", "patch"},
+	},
+	{
+		actionType:  TypeRecall,
+		description: "Search the agent's memory for information relevant to a query.",
+		properties: map[string]interface{}{
+			"query": map[string]interface{}{"type": "string", "description": "What to search for"},
+		},
+		required: []string{"query"},
+	},
+	{
+		actionType:  TypeThink,
+		description: "Record a thought without taking any action.",
+		properties: map[string]interface{}{
+			"thought": map[string]interface{}{"type": "string", "description": "The thought"},
+		},
+		required: []string{"thought"},
+	},
+	{
+		actionType:  TypeDelegate,
+		description: "Hand a task to another agent and return its outputs when it finishes.",
+		properties: map[string]interface{}{
+			"agent":   map[string]interface{}{"type": "string", "description": "Name of the agent to delegate to"},
+			"inputs":  map[string]interface{}{"type": "object", "description": "Inputs for the agent, such as the task"},
+			"thought": map[string]interface{}{"type": "string", "description": "Why the task is delegated"},
+		},
+		required: []string{"agent", "inputs"},
+	},
+	{
+		actionType:  TypeAddTask,
+		description: "Add a task to the plan, under a parent task.",
+		properties: map[string]interface{}{
+			"parent":   map[string]interface{}{"type": "string", "description": "ID of the parent task, empty for the main task"},
+			"goal":     map[string]interface{}{"type": "string", "description": "The goal of the new task"},
+			"subtasks": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "description": "Goals of subtasks to add under the new task"},
+		},
+		required: []string{"parent", "goal"},
+	},
+	{
+		actionType:  TypeModifyTask,
+		description: "Change the state of a task in the plan.",
+		properties: map[string]interface{}{
+			"id":    map[string]interface{}{"type": "string", "description": "ID of the task"},
+			"state": map[string]interface{}{"type": "string", "enum": []string{"open", "in_progress", "completed", "abandoned", "verified"}, "description": "The new state"},
+		},
+		required: []string{"id", "state"},
+	},
+	{
+		actionType:  TypeFinish,
+		description: "Finish the task once it is complete.",
+		properties: map[string]interface{}{
+			"thought": map[string]interface{}{"type": "string", "description": "A summary of what was done"},
+		},
+		required: []string{},
+	},
+}
+
+// ToolName returns the tool name used for an action type
+func ToolName(actionType ActionType) string {
+	return strings.ToLower(string(actionType))
+}
+
+// Tools returns the tool definitions for the actions the model may take
+func Tools() []llm.Tool {
+	tools := make([]llm.Tool, len(toolActions))
+	for i, ta := range toolActions {
+		tools[i] = llm.Tool{
+			Name:        ToolName(ta.actionType),
+			Description: ta.description,
+			InputSchema: map[string]interface{}{
+				"type":       "object",
+				"properties": ta.properties,
+				"required":   ta.required,
+			},
+		}
+	}
+	return tools
+}
+
+// ActionFromToolUse creates an Action from a tool_use block returned by the model
+func ActionFromToolUse(block llm.ContentBlock) (Action, error) {
+	if block.Type != llm.BlockToolUse {
+		return nil, fmt.Errorf("expected a %s block, got %s", llm.BlockToolUse, block.Type)
+	}
+
+	args := make(map[string]interface{})
+	if len(block.Input) > 0 {
+		if err := json.Unmarshal(block.Input, &args); err != nil {
+			return nil, fmt.Errorf("invalid input for tool %s: %v", block.Name, err)
+		}
+	}
+
+	return ActionFromDict(map[string]interface{}{
+		"action": strings.ToUpper(block.Name),
+		"args":   args,
+	})
+}
+
+// ToolResult creates the tool_result block that answers a tool_use block with
+// the observation produced by running its action. Command results end with
+// the exit code.
+func ToolResult(toolUseID string, obs observation.Observation) llm.ContentBlock {
+	content := obs.GetContent()
+	isError := obs.GetType() == observation.TypeError
+	if cmd, ok := obs.(*observation.CmdOutputObservation); ok {
+		content = fmt.Sprintf("%s\n[Command finished with exit code %d]", cmd.Content, cmd.ExitCode)
+		isError = cmd.Error()
+	}
+	return llm.NewToolResultBlock(toolUseID, content, isError)
+}
@@ -0,0 +1,110 @@
+package action
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openagentsinc/autodev/llm"
+	"github.com/openagentsinc/autodev/pkg/observation"
+	"github.com/openagentsinc/autodev/pkg/workspace"
+)
+
+// A tool_use block calling each tool becomes the action it names
+func TestActionFromToolUse(t *testing.T) {
+	tests := []struct {
+		tool  string
+		input string
+		want  Action
+	}{
+		{tool: "run", input: `{"command": "go test ./..."}`, want: NewCmdRunAction("go test ./...", false)},
+		{tool: "run", input: `{"command": "npm run dev", "background": true}`, want: NewCmdRunAction("npm run dev", true)},
+		{tool: "kill", input: `{"id": 2}`, want: NewCmdKillAction(2)},
+		{tool: "browse", input: `{"url": "https://example.com"}`, want: NewBrowseURLAction("https://example.com")},
+		{tool: "read", input: `{"path": "main.go"}`, want: NewFileReadAction("main.go", 0, workspace.EOF)},
+		{tool: "read", input: `{"path": "main.go", "start": 10, "end": 20}`, want: NewFileReadAction("main.go", 10, 20)},
+		{tool: "write", input: `{"path": "main.go", "content": "package main\n"}`, want: NewFileWriteAction("main.go", "package main\n", 0, workspace.EOF)},
+		{tool: "edit", input: `{"path": "main.go", "search": "a", "replace": "b"}`, want: NewFileEditAction("main.go", "a", "b")},
+		{tool: "patch", input: `{"path": "main.go", "patch": "@@ -1 +1 @@\n-a\n+b\n"}`, want: NewFilePatchAction("main.go", "@@ -1 +1 @@\n-a\n+b\n")},
+		{tool: "recall", input: `{"query": "database port"}`, want: NewAgentRecallAction("database port")},
+		{tool: "think", input: `{"thought": "Hmm."}`, want: NewAgentThinkAction("Hmm.")},
+		{
+			tool:  "delegate",
+			input: `{"agent": "verifier", "inputs": {"task": "run the tests"}, "thought": "check"}`,
+			want:  NewAgentDelegateAction("verifier", map[string]interface{}{"task": "run the tests"}, "check"),
+		},
+		{tool: "add_task", input: `{"parent": "0.1", "goal": "tests", "subtasks": ["a", "b"]}`, want: NewAddTaskAction("0.1", "tests", []string{"a", "b"}, "")},
+		{tool: "modify_task", input: `{"id": "0.1", "state": "completed"}`, want: NewModifyTaskAction("0.1", "completed", "")},
+		{tool: "finish", input: `{"thought": "Done."}`, want: NewAgentFinishAction(nil, "Done.")},
+		{tool: "finish", want: NewAgentFinishAction(nil, "")},
+	}
+
+	tested := make(map[string]bool)
+	for _, tt := range tests {
+		tested[tt.tool] = true
+		block := llm.NewToolUseBlock("toolu_1", tt.tool, json.RawMessage(tt.input))
+		got, err := ActionFromToolUse(block)
+		if err != nil {
+			t.Errorf("ActionFromToolUse(%s %s) error = %v", tt.tool, tt.input, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ActionFromToolUse(%s %s) = %#v, want %#v", tt.tool, tt.input, got, tt.want)
+		}
+	}
+	for _, tool := range Tools() {
+		if !tested[tool.Name] {
+			t.Errorf("no test calls the %s tool", tool.Name)
+		}
+	}
+}
+
+func TestActionFromToolUseErrors(t *testing.T) {
+	tests := []struct {
+		block llm.ContentBlock
+		err   string
+	}{
+		{block: llm.NewTextBlock("run"), err: "expected a tool_use block"},
+		{block: llm.NewToolUseBlock("toolu_1", "run", json.RawMessage(`{"command":`)), err: "invalid input for tool run"},
+		{block: llm.NewToolUseBlock("toolu_1", "fly", nil), err: "unknown action type: FLY"},
+	}
+	for _, tt := range tests {
+		_, err := ActionFromToolUse(tt.block)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ActionFromToolUse(%+v) error = %v, want %q", tt.block, err, tt.err)
+		}
+	}
+}
+
+func TestTools(t *testing.T) {
+	for _, tool := range Tools() {
+		properties := tool.InputSchema["properties"].(map[string]interface{})
+		for _, name := range tool.InputSchema["required"].([]string) {
+			if _, ok := properties[name]; !ok {
+				t.Errorf("%s requires %s, which is not one of its properties", tool.Name, name)
+			}
+		}
+		if tool.Description == "" {
+			t.Errorf("%s has no description", tool.Name)
+		}
+	}
+}
+
+func TestToolResult(t *testing.T) {
+	tests := []struct {
+		obs     observation.Observation
+		content string
+		isError bool
+	}{
+		{obs: observation.NewCmdOutputObservation("ok\n", -1, "make", 0), content: "ok\n\n[Command finished with exit code 0]"},
+		{obs: observation.NewCmdOutputObservation("", -1, "false", 1), content: "\n[Command finished with exit code 1]", isError: true},
+		{obs: observation.NewAgentErrorObservation("permission denied"), content: "permission denied", isError: true},
+		{obs: observation.NewFileReadObservation("package main\n", "main.go"), content: "package main\n"},
+	}
+	for _, tt := range tests {
+		got := ToolResult("toolu_1", tt.obs)
+		want := llm.NewToolResultBlock("toolu_1", tt.content, tt.isError)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ToolResult(%#v) = %+v, want %+v", tt.obs, got, want)
+		}
+	}
+}
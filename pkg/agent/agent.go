@@ -1,16 +1,20 @@
 package agent
 
 import (
+	"context"
+
 	"github.com/openagentsinc/autodev/pkg/action"
 	"github.com/openagentsinc/autodev/pkg/llm"
+	"github.com/openagentsinc/autodev/pkg/memory"
 	"github.com/openagentsinc/autodev/pkg/plugin"
 	"github.com/openagentsinc/autodev/pkg/state"
 )
 
 // Agent defines the interface for all agent implementations
 type Agent interface {
-	// Step performs one step of the agent's execution
-	Step(state *state.State) action.Action
+	// Step performs one step of the agent's execution, returning the next
+	// action to take
+	Step(ctx context.Context, state *state.State) (action.Action, error)
 
 	// SearchMemory searches the agent's memory for relevant information
 	SearchMemory(query string) []string
@@ -22,15 +26,19 @@ type Agent interface {
 	IsComplete() bool
 }
 
+// RecallLimit is the number of memories SearchMemory returns
+const RecallLimit = 5
+
 // BaseAgent provides a basic implementation of the Agent interface
 type BaseAgent struct {
-	llm        llm.LLM
+	llm        llm.Provider
 	complete   bool
 	sandboxReq []plugin.PluginRequirement
+	memory     *memory.Memory
 }
 
 // NewBaseAgent creates a new BaseAgent
-func NewBaseAgent(l llm.LLM, req []plugin.PluginRequirement) *BaseAgent {
+func NewBaseAgent(l llm.Provider, req []plugin.PluginRequirement) *BaseAgent {
 	return &BaseAgent{
 		llm:        l,
 		complete:   false,
@@ -48,14 +56,44 @@ func (ba *BaseAgent) Reset() {
 	ba.complete = false
 }
 
-// SearchMemory is a placeholder method that should be implemented by specific agents
+// Requirements returns the plugins the agent needs in its sandbox
+func (ba *BaseAgent) Requirements() []plugin.PluginRequirement {
+	return ba.sandboxReq
+}
+
+// SetMemory gives the agent a memory to remember its steps in and search
+func (ba *BaseAgent) SetMemory(m *memory.Memory) {
+	ba.memory = m
+}
+
+// Remember adds an action or observation, in its ToMemory form, to the
+// agent's memory
+func (ba *BaseAgent) Remember(item map[string]interface{}) error {
+	if ba.memory == nil {
+		return nil
+	}
+	return ba.memory.Add(context.Background(), item)
+}
+
+// SearchMemory returns the remembered steps most relevant to the query, best
+// first, or nil if the agent has no memory
 func (ba *BaseAgent) SearchMemory(query string) []string {
-	// This should be implemented by specific agent types
-	return nil
+	if ba.memory == nil {
+		return nil
+	}
+	results, err := ba.memory.Search(context.Background(), query, RecallLimit)
+	if err != nil {
+		return nil
+	}
+	memories := make([]string, len(results))
+	for i, result := range results {
+		memories[i] = result.Text
+	}
+	return memories
 }
 
 // Step is a placeholder method that should be implemented by specific agents
-func (ba *BaseAgent) Step(state *state.State) action.Action {
+func (ba *BaseAgent) Step(ctx context.Context, state *state.State) (action.Action, error) {
 	// This should be implemented by specific agent types
-	return nil
+	return nil, nil
 }
@@ -0,0 +1,310 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	anthropic "github.com/openagentsinc/autodev/llm"
+	"github.com/openagentsinc/autodev/pkg/action"
+	"github.com/openagentsinc/autodev/pkg/llm"
+	"github.com/openagentsinc/autodev/pkg/observation"
+	"github.com/openagentsinc/autodev/pkg/plugin"
+	"github.com/openagentsinc/autodev/pkg/state"
+)
+
+// codeActInstructions explains the CodeAct reply format to the model
+const codeActInstructions = `You can interact with a sandboxed workspace by replying in one of these formats.
+
+To run a shell command, put it in an <execute_bash> tag. Add background="true" to leave a long-running command, such as a server, running in the background:
+<execute_bash>
+ls -la
+</execute_bash>
+
+To create or overwrite a file, put its complete content in a <write> tag:
+<write path="hello.py">
+print("hello")
+</write>
+
+To change part of a file, put the exact lines to replace, which must appear once in the file, in a <search> tag and their replacement in a <replace> tag:
+<edit path="hello.py">
+<search>
+print("hello")
+</search>
+<replace>
+print("hello, world")
+</replace>
+</edit>
+
+To make several changes to a file at once, put a unified diff of that one file in a <patch> tag:
+<patch path="hello.py">
+@@ -1 +1,2 @@
+ print("hello")
++print("goodbye")
+</patch>
+
+To read a web page, such as documentation, put its URL in a <browse> tag:
+<browse>https://pkg.go.dev/net/http</browse>
+
+When the task is done, reply with a <finish> tag containing a short summary:
+<finish>Created hello.py, which prints hello.</finish>
+
+You may explain your reasoning before the tag. Use at most one tag per reply, then wait for its result. A reply without a tag is treated as a thought.`
+
+// delegateInstructions are added when other agents can take on tasks
+const delegateInstructions = `
+
+To hand a self-contained task to another agent, describe it in a <delegate> tag naming one of these agents: %s. You will see what it finished with:
+<delegate agent="%s">Check that the tests in ./pkg/... pass.</delegate>`
+
+// recallInstructions are added when the agent has a memory
+const recallInstructions = `
+
+To search your memory of earlier commands, files and pages for something you need again, put a query in a <recall> tag:
+<recall>database connection settings</recall>`
+
+// continuePrompt is sent after a reply that took no action
+const continuePrompt = "Continue working on the task. Run a command with <execute_bash>, change a file with <write>, <edit> or <patch>, read a web page with <browse>, or reply with <finish> when you are done."
+
+// codeActStopSequences end a reply after its first action tag
+var codeActStopSequences = []string{"</execute_bash>", "</write>", "</edit>", "</patch>", "</browse>", "</finish>", "</delegate>"}
+
+var (
+	executeBashPattern = regexp.MustCompile(`(?s)<execute_bash(\s+background="(true|false)")?\s*>(.*?)</execute_bash>`)
+	writePattern       = regexp.MustCompile(`(?s)<write\s+path="([^"]+)"\s*>\n?(.*?)</write>`)
+	editPattern        = regexp.MustCompile(`(?s)<edit\s+path="([^"]+)"\s*>\s*<search>\n?(.*?)</search>\s*<replace>\n?(.*?)</replace>\s*</edit>`)
+	patchPattern       = regexp.MustCompile(`(?s)<patch\s+path="([^"]+)"\s*>\n?(.*?)</patch>`)
+	browsePattern      = regexp.MustCompile(`(?s)<browse\s*>(.*?)</browse>`)
+	finishPattern      = regexp.MustCompile(`(?s)<finish\s*>(.*?)</finish>`)
+	recallPattern      = regexp.MustCompile(`(?s)<recall\s*>(.*?)</recall>`)
+	delegatePattern    = regexp.MustCompile(`(?s)<delegate\s+agent="([^"]+)"\s*>(.*?)</delegate>`)
+)
+
+// CodeActAgent implements the CodeAct agent: the model acts by writing
+// shell commands and file contents in tags, and sees their output in the
+// next turn
+type CodeActAgent struct {
+	*BaseAgent
+
+	// MaxOutputChars truncates long observations in the prompt
+	MaxOutputChars int
+
+	// Delegates names the agents the model may delegate tasks to, see
+	// agent.Registry
+	Delegates []string
+
+	// Tools has the model call the actions as native tools, see
+	// action.Tools, instead of writing tags
+	Tools bool
+
+	options []llm.Option
+}
+
+// NewCodeActAgent creates a CodeActAgent. The options set request defaults
+// such as the model and system prompt.
+func NewCodeActAgent(l llm.Provider, req []plugin.PluginRequirement, opts ...llm.Option) *CodeActAgent {
+	return &CodeActAgent{
+		BaseAgent:      NewBaseAgent(l, req),
+		MaxOutputChars: 10000,
+		options:        opts,
+	}
+}
+
+// Step asks the model for the next action given the state so far
+func (ca *CodeActAgent) Step(ctx context.Context, s *state.State) (action.Action, error) {
+	if ca.Tools {
+		return ca.toolStep(ctx, s)
+	}
+
+	instructions := codeActInstructions
+	stopSequences := codeActStopSequences
+	if ca.memory != nil {
+		instructions += recallInstructions
+		stopSequences = append(append([]string(nil), stopSequences...), "</recall>")
+	}
+	if len(ca.Delegates) > 0 {
+		instructions += fmt.Sprintf(delegateInstructions, strings.Join(ca.Delegates, ", "), ca.Delegates[0])
+	}
+	opts := append(append([]llm.Option(nil), ca.options...),
+		llm.WithSystemBlocks(anthropic.NewTextBlock(instructions)),
+		llm.WithStopSequences(stopSequences...),
+		llm.WithPromptCaching(),
+	)
+	req := llm.NewRequest(ca.Messages(s), 4096, opts...)
+
+	response, err := ca.llm.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := response.Text()
+	if response.StopReason == "stop_sequence" {
+		reply += response.StopSequence
+	}
+
+	act := ParseCodeAct(reply)
+	if act.Type() == action.TypeFinish {
+		ca.complete = true
+	}
+	return act, nil
+}
+
+// Messages builds the conversation for the model from the state: the task
+// and plan, then each action taken with the observation it produced
+func (ca *CodeActAgent) Messages(s *state.State) []llm.Message {
+	var task strings.Builder
+	if s.Plan != nil {
+		fmt.Fprintf(&task, "Your task: %s\n", s.Plan.MainGoal)
+		if len(s.Plan.Task.Subtasks) > 0 {
+			fmt.Fprintf(&task, "\nCurrent plan:\n%s", s.Plan.String())
+		}
+		if current := s.Plan.GetCurrentTask(); current != nil && current != s.Plan.Task {
+			fmt.Fprintf(&task, "\nYou are working on task %s: %s\n", current.ID, current.Goal)
+		}
+	}
+	keys := make([]string, 0, len(s.Inputs))
+	for key := range s.Inputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&task, "\n%s: %v\n", key, s.Inputs[key])
+	}
+
+	messages := []llm.Message{anthropic.NewTextMessage("user", strings.TrimSpace(task.String()))}
+	for i, entry := range s.History {
+		if ca.Tools && isToolCall(entry.Action) {
+			messages = ca.appendToolCall(messages, i, entry)
+			continue
+		}
+		messages = appendMessage(messages, "assistant", codeActText(entry.Action))
+		if text := ca.observationText(entry.Observation); text != "" {
+			messages = appendMessage(messages, "user", text)
+		}
+	}
+
+	if len(s.BackgroundCommandsObs) > 0 {
+		var b strings.Builder
+		b.WriteString("New output from background commands:")
+		for _, obs := range s.BackgroundCommandsObs {
+			fmt.Fprintf(&b, "\n\n[Command %d: %s]\n%s", obs.CommandID, obs.Command, ca.truncate(obs.Content))
+		}
+		messages = appendMessage(messages, "user", b.String())
+	}
+
+	if messages[len(messages)-1].Role == "assistant" {
+		if ca.Tools {
+			messages = appendMessage(messages, "user", toolContinuePrompt)
+		} else {
+			messages = appendMessage(messages, "user", continuePrompt)
+		}
+	}
+	return messages
+}
+
+// ParseCodeAct turns a model reply into an action. Replies without a
+// recognized tag become thoughts.
+func ParseCodeAct(reply string) action.Action {
+	if m := finishPattern.FindStringSubmatch(reply); m != nil {
+		return action.NewAgentFinishAction(nil, strings.TrimSpace(m[1]))
+	}
+	if m := executeBashPattern.FindStringSubmatch(reply); m != nil {
+		return action.NewCmdRunAction(strings.TrimSpace(m[3]), m[2] == "true")
+	}
+	if m := writePattern.FindStringSubmatch(reply); m != nil {
+		return action.NewFileWriteAction(m[1], m[2], 0, -1)
+	}
+	if m := editPattern.FindStringSubmatch(reply); m != nil {
+		return action.NewFileEditAction(m[1], m[2], m[3])
+	}
+	if m := patchPattern.FindStringSubmatch(reply); m != nil {
+		return action.NewFilePatchAction(m[1], m[2])
+	}
+	if m := browsePattern.FindStringSubmatch(reply); m != nil {
+		return action.NewBrowseURLAction(strings.TrimSpace(m[1]))
+	}
+	if m := recallPattern.FindStringSubmatch(reply); m != nil {
+		return action.NewAgentRecallAction(strings.TrimSpace(m[1]))
+	}
+	if m := delegatePattern.FindStringSubmatch(reply); m != nil {
+		task := strings.TrimSpace(m[2])
+		return action.NewAgentDelegateAction(m[1], map[string]interface{}{"task": task}, "")
+	}
+	return action.NewAgentThinkAction(strings.TrimSpace(reply))
+}
+
+// codeActText renders an action back into the reply format that produced it
+func codeActText(act action.Action) string {
+	switch a := act.(type) {
+	case *action.CmdRunAction:
+		if a.Background {
+			return fmt.Sprintf("<execute_bash background=\"true\">\n%s\n</execute_bash>", a.Command)
+		}
+		return fmt.Sprintf("<execute_bash>\n%s\n</execute_bash>", a.Command)
+	case *action.FileWriteAction:
+		return fmt.Sprintf("<write path=\"%s\">\n%s</write>", a.Path, a.Content)
+	case *action.FileEditAction:
+		return fmt.Sprintf("<edit path=\"%s\">\n<search>\n%s</search>\n<replace>\n%s</replace>\n</edit>", a.Path, a.Search, a.Replace)
+	case *action.FilePatchAction:
+		return fmt.Sprintf("<patch path=\"%s\">\n%s</patch>", a.Path, a.Patch)
+	case *action.BrowseURLAction:
+		return fmt.Sprintf("<browse>%s</browse>", a.URL)
+	case *action.AgentFinishAction:
+		return fmt.Sprintf("<finish>%s</finish>", a.Thought)
+	case *action.AgentRecallAction:
+		return fmt.Sprintf("<recall>%s</recall>", a.Query)
+	case *action.AgentDelegateAction:
+		return fmt.Sprintf("<delegate agent=\"%s\">%v</delegate>", a.Agent, a.Inputs["task"])
+	case *action.AgentThinkAction:
+		return a.Thought
+	default:
+		return act.Message()
+	}
+}
+
+// observationText renders an observation as the model sees it
+func (ca *CodeActAgent) observationText(obs observation.Observation) string {
+	switch o := obs.(type) {
+	case nil, *observation.NullObservation:
+		return ""
+	case *observation.CmdOutputObservation:
+		return fmt.Sprintf("OBSERVATION:\n%s\n[Command finished with exit code %d]", ca.truncate(o.Content), o.ExitCode)
+	case *observation.AgentErrorObservation:
+		return "ERROR:\n" + ca.truncate(o.Content)
+	default:
+		if content := obs.GetContent(); content != "" {
+			return "OBSERVATION:\n" + ca.truncate(content)
+		}
+		return "OBSERVATION:\n" + obs.Message()
+	}
+}
+
+func (ca *CodeActAgent) truncate(text string) string {
+	if ca.MaxOutputChars <= 0 || len(text) <= ca.MaxOutputChars {
+		return text
+	}
+	half := ca.MaxOutputChars / 2
+	return text[:half] + "\n[... output truncated ...]\n" + text[len(text)-half:]
+}
+
+// appendMessage adds text to the conversation, merging it into the last
+// message if that has the same role, since roles must alternate
+func appendMessage(messages []llm.Message, role, text string) []llm.Message {
+	if text == "" {
+		return messages
+	}
+	return appendBlock(messages, role, anthropic.NewTextBlock(text))
+}
+
+// appendBlock adds a content block to the conversation like appendMessage
+func appendBlock(messages []llm.Message, role string, block llm.ContentBlock) []llm.Message {
+	if n := len(messages); n > 0 && messages[n-1].Role == role {
+		last := messages[n-1]
+		last.Content = append(append([]llm.ContentBlock(nil), last.Content...), block)
+		messages[n-1] = last
+		return messages
+	}
+	return append(messages, llm.Message{Role: role, Content: []llm.ContentBlock{block}})
+}
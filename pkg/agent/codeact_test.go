@@ -0,0 +1,338 @@
+package agent
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openagentsinc/autodev/pkg/action"
+	"github.com/openagentsinc/autodev/pkg/llm"
+	"github.com/openagentsinc/autodev/pkg/memory"
+	"github.com/openagentsinc/autodev/pkg/observation"
+	"github.com/openagentsinc/autodev/pkg/plan"
+	"github.com/openagentsinc/autodev/pkg/state"
+)
+
+// stoppedAt returns a response cut off by one of the stop sequences, which
+// the API leaves out of the text
+func stoppedAt(text, sequence string) *llm.Response {
+	response := llm.TextResponse(text)
+	response.StopReason = "stop_sequence"
+	response.StopSequence = sequence
+	return response
+}
+
+func TestParseCodeAct(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  action.Action
+	}{
+		{
+			name:  "command",
+			reply: "Let me look around.\n<execute_bash>\nls -la\n</execute_bash>",
+			want:  action.NewCmdRunAction("ls -la", false),
+		},
+		{
+			name:  "background command",
+			reply: "<execute_bash background=\"true\">\npython -m http.server\n</execute_bash>",
+			want:  action.NewCmdRunAction("python -m http.server", true),
+		},
+		{
+			name:  "foreground command",
+			reply: "<execute_bash background=\"false\">make</execute_bash>",
+			want:  action.NewCmdRunAction("make", false),
+		},
+		{
+			name:  "write",
+			reply: "<write path=\"src/main.go\">\npackage main\n</write>",
+			want:  action.NewFileWriteAction("src/main.go", "package main\n", 0, -1),
+		},
+		{
+			name:  "write keeps indentation",
+			reply: "<write path=\"a.py\">\n    pass\n\n</write>",
+			want:  action.NewFileWriteAction("a.py", "    pass\n\n", 0, -1),
+		},
+		{
+			name:  "edit",
+			reply: "<edit path=\"main.go\">\n<search>\n\treturn nil\n</search>\n<replace>\n\treturn err\n</replace>\n</edit>",
+			want:  action.NewFileEditAction("main.go", "\treturn nil\n", "\treturn err\n"),
+		},
+		{
+			name:  "edit that deletes",
+			reply: "<edit path=\"main.go\"><search>// TODO\n</search><replace></replace></edit>",
+			want:  action.NewFileEditAction("main.go", "// TODO\n", ""),
+		},
+		{
+			name:  "patch",
+			reply: "<patch path=\"main.go\">\n@@ -1 +1 @@\n-package main\n+package server\n</patch>",
+			want:  action.NewFilePatchAction("main.go", "@@ -1 +1 @@\n-package main\n+package server\n"),
+		},
+		{
+			name:  "browse",
+			reply: "Let me read the docs.\n<browse> https://pkg.go.dev/net/http </browse>",
+			want:  action.NewBrowseURLAction("https://pkg.go.dev/net/http"),
+		},
+		{
+			name:  "recall",
+			reply: "<recall>\ndatabase port\n</recall>",
+			want:  action.NewAgentRecallAction("database port"),
+		},
+		{
+			name:  "finish",
+			reply: "All done.\n<finish>\nCreated the server.\n</finish>",
+			want:  action.NewAgentFinishAction(nil, "Created the server."),
+		},
+		{
+			name:  "delegate",
+			reply: "<delegate agent=\"verifier\">Run the tests.</delegate>",
+			want:  action.NewAgentDelegateAction("verifier", map[string]interface{}{"task": "Run the tests."}, ""),
+		},
+		{
+			name:  "finish wins over other tags",
+			reply: "<execute_bash>ls</execute_bash>\n<finish>done</finish>",
+			want:  action.NewAgentFinishAction(nil, "done"),
+		},
+		{
+			name:  "unclosed tag is a thought",
+			reply: "<execute_bash>\nls",
+			want:  action.NewAgentThinkAction("<execute_bash>\nls"),
+		},
+		{
+			name:  "no tag",
+			reply: "  I should check the tests first.  ",
+			want:  action.NewAgentThinkAction("I should check the tests first."),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseCodeAct(tt.reply); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCodeAct(%q) = %#v, want %#v", tt.reply, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodeActStep(t *testing.T) {
+	tests := []struct {
+		name     string
+		response *llm.Response
+		want     action.Action
+		complete bool
+	}{
+		{
+			name:     "stop sequence is put back",
+			response: stoppedAt("<execute_bash>\ngo test ./...\n", "</execute_bash>"),
+			want:     action.NewCmdRunAction("go test ./...", false),
+		},
+		{
+			name:     "write cut at its stop sequence",
+			response: stoppedAt("Writing it.\n<write path=\"hello.txt\">\nhello\n", "</write>"),
+			want:     action.NewFileWriteAction("hello.txt", "hello\n", 0, -1),
+		},
+		{
+			name:     "edit cut at its stop sequence",
+			response: stoppedAt("<edit path=\"a.go\">\n<search>\nold\n</search>\n<replace>\nnew\n</replace>\n", "</edit>"),
+			want:     action.NewFileEditAction("a.go", "old\n", "new\n"),
+		},
+		{
+			name:     "patch cut at its stop sequence",
+			response: stoppedAt("<patch path=\"a.go\">\n@@ -1 +1 @@\n-old\n+new\n", "</patch>"),
+			want:     action.NewFilePatchAction("a.go", "@@ -1 +1 @@\n-old\n+new\n"),
+		},
+		{
+			name:     "browse",
+			response: stoppedAt("<browse>https://example.com", "</browse>"),
+			want:     action.NewBrowseURLAction("https://example.com"),
+		},
+		{
+			name:     "finish completes the agent",
+			response: stoppedAt("<finish>Done.", "</finish>"),
+			want:     action.NewAgentFinishAction(nil, "Done."),
+			complete: true,
+		},
+		{
+			name:     "delegate",
+			response: stoppedAt("<delegate agent=\"verifier\">Check it.", "</delegate>"),
+			want:     action.NewAgentDelegateAction("verifier", map[string]interface{}{"task": "Check it."}, ""),
+		},
+		{
+			name:     "end of turn is a thought",
+			response: llm.TextResponse("I need to think about this."),
+			want:     action.NewAgentThinkAction("I need to think about this."),
+		},
+		{
+			name:     "closed tag without stop sequence",
+			response: llm.TextResponse("<finish>Done.</finish>"),
+			want:     action.NewAgentFinishAction(nil, "Done."),
+			complete: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := llm.NewScriptedProvider(tt.response)
+			ca := NewCodeActAgent(provider, nil, llm.WithModel("test-model"))
+
+			got, err := ca.Step(context.Background(), state.NewState(plan.NewPlan("Test")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Step() = %#v, want %#v", got, tt.want)
+			}
+			if ca.IsComplete() != tt.complete {
+				t.Errorf("IsComplete() = %v, want %v", ca.IsComplete(), tt.complete)
+			}
+		})
+	}
+}
+
+func TestCodeActRequest(t *testing.T) {
+	provider := llm.NewScriptedProvider(llm.TextResponse("Thinking."))
+	ca := NewCodeActAgent(provider, nil, llm.WithModel("test-model"))
+	ca.Delegates = []string{"verifier", "browser"}
+
+	if _, err := ca.Step(context.Background(), state.NewState(plan.NewPlan("Test"))); err != nil {
+		t.Fatal(err)
+	}
+
+	req := provider.Requests()[0]
+	if req.Model != "test-model" {
+		t.Errorf("model = %q, want the agent's option", req.Model)
+	}
+	if !reflect.DeepEqual(req.StopSequences, codeActStopSequences) {
+		t.Errorf("stop sequences = %v, want %v", req.StopSequences, codeActStopSequences)
+	}
+	system := req.System[len(req.System)-1]
+	if !strings.Contains(system.Text, "<execute_bash>") || !strings.Contains(system.Text, `<delegate agent="verifier">`) {
+		t.Errorf("system prompt is missing the reply format or delegates:\n%s", system.Text)
+	}
+	if !strings.Contains(system.Text, "verifier, browser") {
+		t.Errorf("system prompt does not name the delegates:\n%s", system.Text)
+	}
+}
+
+func TestCodeActRecallOnlyWithMemory(t *testing.T) {
+	provider := llm.NewScriptedProvider(llm.TextResponse("Thinking."), llm.TextResponse("Thinking."))
+	ca := NewCodeActAgent(provider, nil)
+	s := state.NewState(plan.NewPlan("Test"))
+
+	if _, err := ca.Step(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	m, err := memory.New("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.SetMemory(m)
+	if _, err := ca.Step(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []bool{false, true} {
+		req := provider.Requests()[i]
+		hasStop := false
+		for _, sequence := range req.StopSequences {
+			hasStop = hasStop || sequence == "</recall>"
+		}
+		hasInstructions := strings.Contains(req.System[len(req.System)-1].Text, "<recall>")
+		if hasStop != want || hasInstructions != want {
+			t.Errorf("request %d: recall stop sequence %v, instructions %v, want %v", i, hasStop, hasInstructions, want)
+		}
+	}
+}
+
+func TestCodeActStepError(t *testing.T) {
+	ca := NewCodeActAgent(llm.NewScriptedProvider(), nil)
+	if _, err := ca.Step(context.Background(), state.NewState(nil)); err == nil {
+		t.Errorf("want the provider's error")
+	}
+	if ca.IsComplete() {
+		t.Errorf("agent completed after an error")
+	}
+}
+
+func TestCodeActMessages(t *testing.T) {
+	p := plan.NewPlan("Build a server")
+	p.AddSubtask("0", "Write main.go", nil)
+	if err := p.SetSubtaskState("0.0", plan.InProgressState); err != nil {
+		t.Fatal(err)
+	}
+	s := state.NewState(p)
+	s.Inputs["repo"] = "example/server"
+	s.History = []state.HistoryEntry{
+		{Action: action.NewAgentThinkAction("First, a plan."), Observation: observation.NewNullObservation()},
+		{Action: action.NewCmdRunAction("ls", false), Observation: observation.NewCmdOutputObservation("go.mod", 1, "ls", 0)},
+		{Action: action.NewFileWriteAction("main.go", "package main\n", 0, -1), Observation: observation.NewAgentErrorObservation("permission denied")},
+		{Action: action.NewAgentThinkAction("Hmm."), Observation: observation.NewNullObservation()},
+	}
+
+	ca := NewCodeActAgent(nil, nil)
+	messages := ca.Messages(s)
+
+	var roles []string
+	for _, message := range messages {
+		roles = append(roles, message.Role)
+	}
+	if want := []string{"user", "assistant", "user", "assistant", "user", "assistant", "user"}; !reflect.DeepEqual(roles, want) {
+		t.Fatalf("roles = %v, want %v", roles, want)
+	}
+
+	task := messages[0].Text()
+	for _, want := range []string{"Your task: Build a server", "You are working on task 0.0: Write main.go", "repo: example/server"} {
+		if !strings.Contains(task, want) {
+			t.Errorf("task message does not contain %q:\n%s", want, task)
+		}
+	}
+	if got := messages[1].Content; len(got) != 2 || got[1].Text != "<execute_bash>\nls\n</execute_bash>" {
+		t.Errorf("a thought and the next action should share a turn, got %+v", got)
+	}
+	if got := messages[2].Text(); got != "OBSERVATION:\ngo.mod\n[Command finished with exit code 0]" {
+		t.Errorf("command observation = %q", got)
+	}
+	if got := messages[4].Text(); got != "ERROR:\npermission denied" {
+		t.Errorf("error observation = %q", got)
+	}
+	if got := messages[6].Text(); got != continuePrompt {
+		t.Errorf("a trailing thought should be followed by the continue prompt, got %q", got)
+	}
+}
+
+// Actions in the history are shown to the model as the replies that
+// produced them
+func TestCodeActText(t *testing.T) {
+	acts := []action.Action{
+		action.NewCmdRunAction("ls -la", false),
+		action.NewCmdRunAction("python -m http.server", true),
+		action.NewFileWriteAction("main.go", "package main\n", 0, -1),
+		action.NewFileEditAction("main.go", "package main\n", "package server\n"),
+		action.NewFilePatchAction("main.go", "@@ -1 +1 @@\n-package main\n+package server\n"),
+		action.NewBrowseURLAction("https://example.com"),
+		action.NewAgentRecallAction("database port"),
+		action.NewAgentDelegateAction("verifier", map[string]interface{}{"task": "Run the tests."}, ""),
+		action.NewAgentFinishAction(nil, "Done."),
+		action.NewAgentThinkAction("Hmm."),
+	}
+	for _, act := range acts {
+		text := codeActText(act)
+		if got := ParseCodeAct(text); !reflect.DeepEqual(got, act) {
+			t.Errorf("ParseCodeAct(%q) = %#v, want %#v", text, got, act)
+		}
+	}
+}
+
+func TestCodeActTruncate(t *testing.T) {
+	ca := NewCodeActAgent(nil, nil)
+	ca.MaxOutputChars = 10
+
+	got := ca.truncate("0123456789abcdefghij")
+	if got != "01234\n[... output truncated ...]\nfghij" {
+		t.Errorf("truncate = %q", got)
+	}
+	if got := ca.truncate("short"); got != "short" {
+		t.Errorf("short output was truncated to %q", got)
+	}
+}
@@ -0,0 +1,507 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	anthropic "github.com/openagentsinc/autodev/llm"
+	"github.com/openagentsinc/autodev/pkg/action"
+	"github.com/openagentsinc/autodev/pkg/llm"
+	"github.com/openagentsinc/autodev/pkg/plan"
+	"github.com/openagentsinc/autodev/pkg/plugin"
+	"github.com/openagentsinc/autodev/pkg/state"
+	"github.com/openagentsinc/autodev/pkg/usage"
+)
+
+// Planner limits
+const (
+	DefaultMaxPlanTasks = 30
+	DefaultMaxPlanDepth = 3
+)
+
+// decomposeInstructions ask the model for a task tree
+const decomposeInstructions = `Break the goal down into a plan of concrete software engineering tasks, in the order they should be done. Each task should be small enough to complete and verify on its own; split larger tasks into subtasks.
+
+Reply with only a JSON object in this format:
+{"tasks": [{"goal": "Set up the project", "subtasks": [{"goal": "Create the Go module", "subtasks": []}]}]}`
+
+// reviseInstructions ask the model for changes to an existing plan
+const reviseInstructions = `Revise the plan given what has happened since it was made. Mark tasks that have started as in_progress, finished tasks as completed, and tasks that are no longer needed or cannot be done as abandoned. Give a short reason for each change. Add tasks for work that turned out to be needed, under the task they belong to, or under task 0 for top-level tasks. When an existing task cannot start until another existing task in a different branch of the plan is done, add a dependency between them. Leave the plan unchanged if nothing affects it.
+
+Reply with only a JSON object in this format:
+{"updates": [{"id": "0.1", "state": "completed", "reason": "The tests pass"}], "add": [{"parent": "0", "goal": "Fix the failing build", "subtasks": [], "reason": "The build broke"}], "depends": [{"id": "0.2.1", "on": "0.1.0", "reason": "The docs describe the parser"}]}`
+
+// TaskSpec is a task proposed by the model, with its subtasks
+type TaskSpec struct {
+	Goal     string     `json:"goal"`
+	Subtasks []TaskSpec `json:"subtasks"`
+}
+
+// StateUpdate changes the state of a task in a Revision
+type StateUpdate struct {
+	ID     string `json:"id"`
+	State  string `json:"state"`
+	Reason string `json:"reason"`
+}
+
+// TaskAddition adds a task under a parent in a Revision
+type TaskAddition struct {
+	Parent string `json:"parent"`
+	Reason string `json:"reason"`
+	TaskSpec
+}
+
+// Dependency makes one task wait for another in a Revision
+type Dependency struct {
+	ID     string `json:"id"`
+	On     string `json:"on"`
+	Reason string `json:"reason"`
+}
+
+// Revision is a set of changes to a plan proposed by the model
+type Revision struct {
+	Updates []StateUpdate  `json:"updates"`
+	Add     []TaskAddition `json:"add"`
+	Depends []Dependency   `json:"depends"`
+}
+
+// Planner asks the model to decompose goals into a task tree and to revise
+// the tree as work progresses
+type Planner struct {
+	// MaxTasks and MaxDepth bound the size of a decomposition
+	MaxTasks int
+	MaxDepth int
+
+	// MaxAttempts is how many times the model is asked again, with the
+	// problem explained, when its reply is not a valid plan
+	MaxAttempts int
+
+	llm     llm.Provider
+	options []llm.Option
+}
+
+// NewPlanner creates a Planner. The options set request defaults such as
+// the model and system prompt.
+func NewPlanner(l llm.Provider, opts ...llm.Option) *Planner {
+	return &Planner{
+		MaxTasks:    DefaultMaxPlanTasks,
+		MaxDepth:    DefaultMaxPlanDepth,
+		MaxAttempts: 2,
+		llm:         l,
+		options:     opts,
+	}
+}
+
+// Decompose asks the model for a task tree for the goal, with notes giving
+// any further context, and returns it validated
+func (pl *Planner) Decompose(ctx context.Context, goal, notes string) ([]TaskSpec, error) {
+	prompt := "Goal: " + goal
+	if notes != "" {
+		prompt += "\n\n" + notes
+	}
+
+	var decomposition struct {
+		Tasks []TaskSpec `json:"tasks"`
+	}
+	err := pl.ask(ctx, decomposeInstructions, prompt, &decomposition, func() error {
+		return pl.validateTasks(decomposition.Tasks)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decomposition.Tasks, nil
+}
+
+// Revise asks the model how the plan should change given notes on what has
+// happened, and returns the revision validated against the plan
+func (pl *Planner) Revise(ctx context.Context, p *plan.Plan, notes string) (*Revision, error) {
+	prompt := fmt.Sprintf("Goal: %s\n\nCurrent plan, with task ids and states:\n%s\nWhat has happened:\n%s", p.MainGoal, planText(p.Task.Subtasks, ""), notes)
+
+	var revision Revision
+	err := pl.ask(ctx, reviseInstructions, prompt, &revision, func() error {
+		return pl.validateRevision(p, &revision)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// ask sends the prompt and decodes the JSON reply into v, asking again with
+// the problem explained while the reply is invalid
+func (pl *Planner) ask(ctx context.Context, instructions, prompt string, v interface{}, validate func() error) error {
+	attempts := pl.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	messages := []llm.Message{anthropic.NewTextMessage("user", prompt)}
+	var problem error
+	for attempt := 0; attempt < attempts; attempt++ {
+		opts := append(append([]llm.Option(nil), pl.options...),
+			llm.WithSystemBlocks(anthropic.NewTextBlock(instructions)),
+		)
+		response, err := pl.llm.Chat(ctx, llm.NewRequest(messages, 2048, opts...))
+		if err != nil {
+			return err
+		}
+		reply := response.Text()
+
+		problem = decodeJSON(reply, v)
+		if problem == nil {
+			problem = validate()
+		}
+		if problem == nil {
+			return nil
+		}
+
+		messages = append(messages,
+			anthropic.NewTextMessage("assistant", reply),
+			anthropic.NewTextMessage("user", fmt.Sprintf("That plan is invalid: %v. Reply with the corrected JSON only.", problem)),
+		)
+	}
+	return fmt.Errorf("the model did not produce a valid plan: %v", problem)
+}
+
+// decodeJSON decodes the JSON object in a reply, which models sometimes
+// wrap in a code block or explanation
+func decodeJSON(reply string, v interface{}) error {
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return fmt.Errorf("no JSON object found")
+	}
+	// Clear what an earlier attempt decoded.
+	target := reflect.ValueOf(v).Elem()
+	target.Set(reflect.Zero(target.Type()))
+	if err := json.Unmarshal([]byte(reply[start:end+1]), v); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	return nil
+}
+
+func (pl *Planner) validateTasks(tasks []TaskSpec) error {
+	if len(tasks) == 0 {
+		return fmt.Errorf("the plan has no tasks")
+	}
+	count, err := pl.checkSpecs(tasks, 1)
+	if err != nil {
+		return err
+	}
+	if pl.MaxTasks > 0 && count > pl.MaxTasks {
+		return fmt.Errorf("the plan has %d tasks, more than the limit of %d", count, pl.MaxTasks)
+	}
+	return nil
+}
+
+// checkSpecs checks goals are set and the tree is not too deep, returning
+// the number of tasks
+func (pl *Planner) checkSpecs(tasks []TaskSpec, depth int) (int, error) {
+	if len(tasks) > 0 && pl.MaxDepth > 0 && depth > pl.MaxDepth {
+		return 0, fmt.Errorf("tasks are nested more than %d levels deep", pl.MaxDepth)
+	}
+	count := 0
+	for _, task := range tasks {
+		if strings.TrimSpace(task.Goal) == "" {
+			return 0, fmt.Errorf("a task has an empty goal")
+		}
+		n, err := pl.checkSpecs(task.Subtasks, depth+1)
+		if err != nil {
+			return 0, err
+		}
+		count += 1 + n
+	}
+	return count, nil
+}
+
+func (pl *Planner) validateRevision(p *plan.Plan, revision *Revision) error {
+	for _, update := range revision.Updates {
+		if _, err := p.GetTaskByID(update.ID); err != nil {
+			return err
+		}
+		if !plan.IsValidState(update.State) {
+			return fmt.Errorf("invalid state %q for task %s", update.State, update.ID)
+		}
+	}
+
+	count := 0
+	for _, addition := range revision.Add {
+		parent, err := p.GetTaskByID(addition.Parent)
+		if err != nil {
+			return err
+		}
+		n, err := pl.checkSpecs([]TaskSpec{addition.TaskSpec}, depth(parent))
+		if err != nil {
+			return err
+		}
+		count += n
+	}
+	if pl.MaxTasks > 0 && count > pl.MaxTasks {
+		return fmt.Errorf("the revision adds %d tasks, more than the limit of %d", count, pl.MaxTasks)
+	}
+
+	for _, dep := range revision.Depends {
+		if _, err := p.GetTaskByID(dep.ID); err != nil {
+			return err
+		}
+		if _, err := p.GetTaskByID(dep.On); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// depth returns how far below the root a task is
+func depth(task *plan.Task) int {
+	d := 0
+	for t := task; t.Parent != nil; t = t.Parent {
+		d++
+	}
+	return d + 1
+}
+
+// AddTasks adds a task tree under the parent task
+func AddTasks(p *plan.Plan, parentID string, tasks []TaskSpec) error {
+	parent, err := p.GetTaskByID(parentID)
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		if err := p.AddSubtask(parentID, strings.TrimSpace(task.Goal), nil); err != nil {
+			return err
+		}
+		child := parent.Subtasks[len(parent.Subtasks)-1]
+		if err := AddTasks(p, child.ID, task.Subtasks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply makes the revision's changes to the plan: dependencies are added,
+// which fails on cycles, then states are updated, which propagates to
+// subtasks and parents, then new tasks are added. Each change is recorded
+// in the plan's history with the model's reason for it.
+func (r *Revision) Apply(p *plan.Plan) error {
+	for _, dep := range r.Depends {
+		err := p.Change(plan.ActorAgent, dep.Reason, func() error {
+			return p.AddDependency(dep.ID, dep.On)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, update := range r.Updates {
+		err := p.Change(plan.ActorAgent, update.Reason, func() error {
+			return p.SetSubtaskState(update.ID, update.State)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, addition := range r.Add {
+		err := p.Change(plan.ActorAgent, addition.Reason, func() error {
+			return AddTasks(p, addition.Parent, []TaskSpec{addition.TaskSpec})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrPlanChanged is returned by Update when the plan changes while the
+// model is asked about it, since the update may no longer fit
+var ErrPlanChanged = errors.New("the plan changed while it was being updated, so the update was dropped")
+
+// SharedPlan is a plan guarded by a lock, such as the one the server's
+// pages show
+type SharedPlan interface {
+	// ReadPlan calls fn with the plan, which fn must not change
+	ReadPlan(fn func(p *plan.Plan))
+
+	// UpdatePlan calls fn to change the plan
+	UpdatePlan(fn func(p *plan.Plan) error) error
+}
+
+// planVersion identifies a state of a shared plan. Every change to a plan
+// is recorded in its history, so a plan whose history still ends with the
+// same event has not changed, unless it was replaced.
+type planVersion struct {
+	plan    *plan.Plan
+	changes int
+	last    *plan.Event
+}
+
+func versionOf(p *plan.Plan) planVersion {
+	v := planVersion{plan: p, changes: len(p.History)}
+	if v.changes > 0 {
+		v.last = p.History[v.changes-1]
+	}
+	return v
+}
+
+// Update breaks the main goal of a shared plan down into tasks if it has
+// none yet, and otherwise revises it given notes on what has happened. The
+// model is asked about a copy of the plan without holding the lock, so the
+// plan can be read and changed meanwhile; the update is only applied if it
+// was not, and ErrPlanChanged is returned otherwise.
+func (pl *Planner) Update(ctx context.Context, shared SharedPlan, notes string) error {
+	var data []byte
+	var version planVersion
+	var err error
+	shared.ReadPlan(func(p *plan.Plan) {
+		data, err = plan.Marshal(p, plan.FormatJSON)
+		version = versionOf(p)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy plan: %v", err)
+	}
+	snapshot, err := plan.Unmarshal(data, plan.FormatJSON)
+	if err != nil {
+		return fmt.Errorf("failed to copy plan: %v", err)
+	}
+
+	var tasks []TaskSpec
+	var revision *Revision
+	ctx = usage.WithTask(ctx, snapshot.CurrentTaskID())
+	if len(snapshot.Task.Subtasks) == 0 {
+		tasks, err = pl.Decompose(ctx, snapshot.MainGoal, notes)
+	} else {
+		revision, err = pl.Revise(ctx, snapshot, notes)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to plan: %v", err)
+	}
+
+	return shared.UpdatePlan(func(p *plan.Plan) error {
+		if versionOf(p) != version {
+			return ErrPlanChanged
+		}
+		if revision != nil {
+			return revision.Apply(p)
+		}
+		return Decomposed(p, tasks)
+	})
+}
+
+// Decomposed adds the tasks of a decomposition to the plan as one change
+func Decomposed(p *plan.Plan, tasks []TaskSpec) error {
+	return p.Change(plan.ActorAgent, "Broke the goal down into tasks", func() error {
+		return AddTasks(p, p.Task.ID, tasks)
+	})
+}
+
+// planText lists tasks with their ids and states, indented by depth
+func planText(tasks []*plan.Task, indent string) string {
+	var b strings.Builder
+	for _, task := range tasks {
+		fmt.Fprintf(&b, "%s%s [%s] %s%s\n", indent, task.ID, task.State, task.Goal, afterText(task))
+		b.WriteString(planText(task.Subtasks, indent+"  "))
+	}
+	return b.String()
+}
+
+// afterText notes the tasks a task depends on
+func afterText(task *plan.Task) string {
+	if len(task.DependsOn) == 0 {
+		return ""
+	}
+	return " (after " + strings.Join(task.DependsOn, ", ") + ")"
+}
+
+// PlannerAgent plans rather than acts: given a task, it decomposes it into
+// a plan, and given a plan and a history, it revises the plan. It finishes
+// after each step with the plan as its "plan" output, so it suits being
+// delegated to.
+type PlannerAgent struct {
+	*BaseAgent
+	Planner *Planner
+
+	// HistoryEntries is how many recent steps are described to the model
+	// when revising
+	HistoryEntries int
+}
+
+// NewPlannerAgent creates a PlannerAgent
+func NewPlannerAgent(l llm.Provider, req []plugin.PluginRequirement, opts ...llm.Option) *PlannerAgent {
+	return &PlannerAgent{
+		BaseAgent:      NewBaseAgent(l, req),
+		Planner:        NewPlanner(l, opts...),
+		HistoryEntries: 20,
+	}
+}
+
+// Step plans the task in the state's "task" input if there is no plan yet,
+// decomposes the plan's goal if it has no tasks, or revises it otherwise
+func (pa *PlannerAgent) Step(ctx context.Context, s *state.State) (action.Action, error) {
+	if s.Plan == nil {
+		goal, _ := s.Inputs["task"].(string)
+		if strings.TrimSpace(goal) == "" {
+			return nil, fmt.Errorf("no plan or task to plan")
+		}
+		s.Plan = plan.NewPlan(goal)
+	}
+
+	var thought string
+	if len(s.Plan.Task.Subtasks) == 0 {
+		tasks, err := pa.Planner.Decompose(ctx, s.Plan.MainGoal, inputsText(s.Inputs))
+		if err != nil {
+			return nil, err
+		}
+		if err := Decomposed(s.Plan, tasks); err != nil {
+			return nil, err
+		}
+		thought = fmt.Sprintf("Planned %d top-level tasks.", len(tasks))
+	} else {
+		revision, err := pa.Planner.Revise(ctx, s.Plan, pa.historyText(s))
+		if err != nil {
+			return nil, err
+		}
+		if err := revision.Apply(s.Plan); err != nil {
+			return nil, err
+		}
+		thought = fmt.Sprintf("Revised the plan: %d state changes and %d new tasks.", len(revision.Updates), len(revision.Add))
+	}
+
+	pa.complete = true
+	return action.NewAgentFinishAction(map[string]interface{}{"plan": s.Plan.Task.ToDict()}, thought), nil
+}
+
+// historyText describes the most recent steps for revising the plan
+func (pa *PlannerAgent) historyText(s *state.State) string {
+	history := s.History
+	if pa.HistoryEntries > 0 && len(history) > pa.HistoryEntries {
+		history = history[len(history)-pa.HistoryEntries:]
+	}
+	var b strings.Builder
+	for _, entry := range history {
+		fmt.Fprintf(&b, "- %s\n", entry.Action.Message())
+		if content := strings.TrimSpace(entry.Observation.GetContent()); content != "" {
+			if len(content) > 500 {
+				content = content[:500] + "..."
+			}
+			fmt.Fprintf(&b, "  Result: %s\n", strings.ReplaceAll(content, "\n", "\n  "))
+		}
+	}
+	if b.Len() == 0 {
+		return "Nothing yet."
+	}
+	return b.String()
+}
+
+// inputsText lists inputs other than the task as context
+func inputsText(inputs map[string]interface{}) string {
+	var lines []string
+	for key, value := range inputs {
+		if key != "task" {
+			lines = append(lines, fmt.Sprintf("%s: %v", key, value))
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,247 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/openagentsinc/autodev/pkg/llm"
+	"github.com/openagentsinc/autodev/pkg/plan"
+)
+
+// sharedPlan is a SharedPlan for tests
+type sharedPlan struct {
+	mu sync.RWMutex
+	p  *plan.Plan
+}
+
+func (sp *sharedPlan) ReadPlan(fn func(p *plan.Plan)) {
+	sp.mu.RLock()
+	defer sp.mu.RUnlock()
+	fn(sp.p)
+}
+
+func (sp *sharedPlan) UpdatePlan(fn func(p *plan.Plan) error) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return fn(sp.p)
+}
+
+// changingProvider calls change before each reply, as if the plan were
+// changed while the model was thinking
+type changingProvider struct {
+	*llm.ScriptedProvider
+	change func()
+}
+
+func (cp *changingProvider) Chat(ctx context.Context, req llm.Request) (*llm.Response, error) {
+	cp.change()
+	return cp.ScriptedProvider.Chat(ctx, req)
+}
+
+// revisablePlan returns a plan with task 0.0 in progress and tasks 0.1 and
+// 0.2 open
+func revisablePlan(t *testing.T) *plan.Plan {
+	t.Helper()
+	p := plan.NewPlan("Build a CLI")
+	err := Decomposed(p, []TaskSpec{{Goal: "Parse flags"}, {Goal: "Write the parser"}, {Goal: "Write the docs"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetSubtaskState("0.0", plan.InProgressState); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// taskGoals lists the ids and goals of tasks and their subtasks
+func taskGoals(tasks []*plan.Task) []string {
+	var goals []string
+	for _, task := range tasks {
+		goals = append(goals, task.ID+" "+task.Goal)
+		goals = append(goals, taskGoals(task.Subtasks)...)
+	}
+	return goals
+}
+
+// lastPrompt returns the text of the last message of a request
+func lastPrompt(req llm.Request) string {
+	return req.Messages[len(req.Messages)-1].Text()
+}
+
+func TestPlannerUpdateDecomposes(t *testing.T) {
+	provider := llm.NewScriptedProvider(llm.TextResponse("Here is the plan:\n```json\n" +
+		`{"tasks": [{"goal": "Parse flags", "subtasks": [{"goal": " Define the flags "}]}, {"goal": "Write the docs"}]}` +
+		"\n```"))
+	shared := &sharedPlan{p: plan.NewPlan("Build a CLI")}
+
+	if err := NewPlanner(provider).Update(context.Background(), shared, "Use the standard library."); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"0.0 Parse flags", "0.0.0 Define the flags", "0.1 Write the docs"}
+	if got := taskGoals(shared.p.Task.Subtasks); !reflect.DeepEqual(got, want) {
+		t.Errorf("tasks = %q, want %q", got, want)
+	}
+	// The decomposition is a single change, undone at once
+	for _, e := range shared.p.History {
+		if e.Batch != shared.p.History[0].Batch || e.Reason != "Broke the goal down into tasks" || e.Actor != plan.ActorAgent {
+			t.Errorf("event %s = batch %d by %s for %q", e, e.Batch, e.Actor, e.Reason)
+		}
+	}
+	if prompt := lastPrompt(provider.Requests()[0]); prompt != "Goal: Build a CLI\n\nUse the standard library." {
+		t.Errorf("prompt = %q", prompt)
+	}
+}
+
+func TestPlannerUpdateRevises(t *testing.T) {
+	provider := llm.NewScriptedProvider(llm.TextResponse(`{
+		"updates": [{"id": "0.0", "state": "completed", "reason": "The flags parse"}],
+		"add": [{"parent": "0", "goal": "Fix the build", "subtasks": [{"goal": "Pin the Go version"}], "reason": "The build broke"}],
+		"depends": [{"id": "0.2", "on": "0.1", "reason": "The docs describe the parser"}]
+	}`))
+	shared := &sharedPlan{p: revisablePlan(t)}
+	before := len(shared.p.History)
+
+	if err := NewPlanner(provider).Update(context.Background(), shared, "- Ran go test"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := shared.p
+	if task, _ := p.GetTaskByID("0.0"); task.State != plan.CompletedState {
+		t.Errorf("task 0.0 is %s, want completed", task.State)
+	}
+	if task, _ := p.GetTaskByID("0.2"); !reflect.DeepEqual(task.DependsOn, []string{"0.1"}) {
+		t.Errorf("task 0.2 depends on %v, want 0.1", task.DependsOn)
+	}
+	want := []string{"0.0 Parse flags", "0.1 Write the parser", "0.2 Write the docs", "0.3 Fix the build", "0.3.0 Pin the Go version"}
+	if got := taskGoals(p.Task.Subtasks); !reflect.DeepEqual(got, want) {
+		t.Errorf("tasks = %q, want %q", got, want)
+	}
+
+	var reasons []string
+	for _, e := range p.History[before:] {
+		if len(reasons) == 0 || reasons[len(reasons)-1] != e.Reason {
+			reasons = append(reasons, e.Reason)
+		}
+	}
+	if want := []string{"The docs describe the parser", "The flags parse", "The build broke"}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("recorded reasons = %q, want %q", reasons, want)
+	}
+
+	prompt := lastPrompt(provider.Requests()[0])
+	for _, want := range []string{"0.0 [in_progress] Parse flags\n", "0.2 [open] Write the docs\n", "What has happened:\n- Ran go test"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt does not contain %q:\n%s", want, prompt)
+		}
+	}
+}
+
+func TestPlannerUpdateDropsStale(t *testing.T) {
+	revision := `{"updates": [{"id": "0.1", "state": "in_progress", "reason": "Started the parser"}]}`
+	tests := []struct {
+		name   string
+		change func(shared *sharedPlan) error
+	}{
+		{
+			name: "task added",
+			change: func(shared *sharedPlan) error {
+				return shared.UpdatePlan(func(p *plan.Plan) error {
+					return p.AddSubtask("0", "Add a logo", nil)
+				})
+			},
+		},
+		{
+			name: "change undone",
+			change: func(shared *sharedPlan) error {
+				return shared.UpdatePlan((*plan.Plan).Undo)
+			},
+		},
+		{
+			name: "plan replaced",
+			change: func(shared *sharedPlan) error {
+				replacement := revisablePlan(t)
+				shared.mu.Lock()
+				defer shared.mu.Unlock()
+				shared.p = replacement
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		shared := &sharedPlan{p: revisablePlan(t)}
+		provider := &changingProvider{ScriptedProvider: llm.NewScriptedProvider(llm.TextResponse(revision))}
+		provider.change = func() {
+			if err := tt.change(shared); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := NewPlanner(provider).Update(context.Background(), shared, "")
+		if !errors.Is(err, ErrPlanChanged) {
+			t.Errorf("%s: Update() error = %v, want %v", tt.name, err, ErrPlanChanged)
+		}
+		if task, err := shared.p.GetTaskByID("0.1"); err != nil || task.State != plan.OpenState {
+			t.Errorf("%s: the stale update was applied", tt.name)
+		}
+	}
+}
+
+func TestPlannerRetriesInvalidReplies(t *testing.T) {
+	provider := llm.NewScriptedProvider(
+		llm.TextResponse("I would mark the flags as done."),
+		llm.TextResponse(`{"updates": [{"id": "0.9", "state": "completed"}]}`),
+		llm.TextResponse(`{"updates": [{"id": "0.0", "state": "finished"}]}`),
+		llm.TextResponse(`{"updates": [{"id": "0.0", "state": "completed"}]}`),
+	)
+	planner := NewPlanner(provider)
+	planner.MaxAttempts = 4
+	shared := &sharedPlan{p: revisablePlan(t)}
+
+	if err := planner.Update(context.Background(), shared, ""); err != nil {
+		t.Fatal(err)
+	}
+	if task, _ := shared.p.GetTaskByID("0.0"); task.State != plan.CompletedState {
+		t.Errorf("task 0.0 is %s, want completed", task.State)
+	}
+
+	requests := provider.Requests()
+	problems := []string{"no JSON object found", "task does not exist: 0.9", `invalid state "finished" for task 0.0`}
+	for i, problem := range problems {
+		if prompt := lastPrompt(requests[i+1]); !strings.Contains(prompt, "That plan is invalid: "+problem) {
+			t.Errorf("request %d ends with %q, want %q explained", i+2, prompt, problem)
+		}
+	}
+}
+
+func TestPlannerRejectsInvalidPlans(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		err   string
+	}{
+		{name: "no tasks", reply: `{"tasks": []}`, err: "the plan has no tasks"},
+		{name: "empty goal", reply: `{"tasks": [{"goal": " "}]}`, err: "a task has an empty goal"},
+		{name: "too deep", reply: `{"tasks": [{"goal": "a", "subtasks": [{"goal": "b", "subtasks": [{"goal": "c"}]}]}]}`, err: "nested more than 2 levels deep"},
+		{name: "too many", reply: `{"tasks": [{"goal": "a"}, {"goal": "b"}, {"goal": "c"}, {"goal": "d"}]}`, err: "the plan has 4 tasks, more than the limit of 3"},
+		{name: "invalid JSON", reply: `{"tasks": [}`, err: "invalid JSON"},
+	}
+	for _, tt := range tests {
+		planner := NewPlanner(llm.NewScriptedProvider(llm.TextResponse(tt.reply)))
+		planner.MaxAttempts = 1
+		planner.MaxDepth = 2
+		planner.MaxTasks = 3
+		shared := &sharedPlan{p: plan.NewPlan("goal")}
+
+		err := planner.Update(context.Background(), shared, "")
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: Update() error = %v, want %q", tt.name, err, tt.err)
+		}
+		if len(shared.p.Task.Subtasks) != 0 || len(shared.p.History) != 0 {
+			t.Errorf("%s: an invalid plan changed the plan", tt.name)
+		}
+	}
+}
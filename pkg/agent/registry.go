@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory creates a fresh agent, so each delegated task starts from a clean
+// slate
+type Factory func() Agent
+
+// Registry holds the agents available for delegation by name, such as
+// "browser", "verifier" or "planner"
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[string]Factory
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{factories: make(map[string]Factory)}
+}
+
+// Register adds an agent, replacing any registered under the same name
+func (r *Registry) Register(name string, factory Factory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[name] = factory
+}
+
+// New creates a new instance of the named agent
+func (r *Registry) New(name string) (Agent, error) {
+	r.mu.RLock()
+	factory, ok := r.factories[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown agent %q, available agents: %v", name, r.Names())
+	}
+	return factory(), nil
+}
+
+// Names returns the registered agent names in order
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
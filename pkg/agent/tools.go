@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	anthropic "github.com/openagentsinc/autodev/llm"
+	"github.com/openagentsinc/autodev/pkg/action"
+	"github.com/openagentsinc/autodev/pkg/llm"
+	"github.com/openagentsinc/autodev/pkg/observation"
+	"github.com/openagentsinc/autodev/pkg/state"
+)
+
+// toolInstructions replace the CodeAct reply format when the model calls
+// tools
+const toolInstructions = `You can interact with a sandboxed workspace by calling the tools you are given. Call one tool at a time, then wait for its result. Prefer edit or patch to rewriting whole files. When the task is done, call finish with a short summary.`
+
+// toolContinuePrompt is sent after a reply that called no tool
+const toolContinuePrompt = "Continue working on the task by calling a tool, or call finish when you are done."
+
+// tools returns the actions offered to the model. Recall needs a memory to
+// search and delegate needs agents to delegate to.
+func (ca *CodeActAgent) tools() []llm.Tool {
+	var tools []llm.Tool
+	for _, tool := range action.Tools() {
+		switch tool.Name {
+		case action.ToolName(action.TypeRecall):
+			if ca.memory == nil {
+				continue
+			}
+		case action.ToolName(action.TypeDelegate):
+			if len(ca.Delegates) == 0 {
+				continue
+			}
+			tool.Description += fmt.Sprintf(" The agents are: %s.", strings.Join(ca.Delegates, ", "))
+		}
+		tools = append(tools, tool)
+	}
+	return tools
+}
+
+// toolStep asks the model for the next action as a tool call. A reply that
+// calls no tool is a thought.
+func (ca *CodeActAgent) toolStep(ctx context.Context, s *state.State) (action.Action, error) {
+	opts := append(append([]llm.Option(nil), ca.options...),
+		llm.WithSystemBlocks(anthropic.NewTextBlock(toolInstructions)),
+		llm.WithTools(ca.tools()...),
+		llm.WithPromptCaching(),
+	)
+	req := llm.NewRequest(ca.Messages(s), 4096, opts...)
+
+	response, err := ca.llm.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, block := range response.Content {
+		if block.Type != anthropic.BlockToolUse {
+			continue
+		}
+		act, err := action.ActionFromToolUse(block)
+		if err != nil {
+			// Let the model see the mistake and try again
+			return action.NewAgentThinkAction(fmt.Sprintf("My call to %s failed: %v", block.Name, err)), nil
+		}
+		if act.Type() == action.TypeFinish {
+			ca.complete = true
+		}
+		return act, nil
+	}
+	return action.NewAgentThinkAction(strings.TrimSpace(response.Text())), nil
+}
+
+// isToolCall returns whether an action is shown to the model as a tool call.
+// Thoughts are shown as text, since they are usually replies without one.
+func isToolCall(act action.Action) bool {
+	if act.Type() == action.TypeThink {
+		return false
+	}
+	for _, tool := range action.Tools() {
+		if tool.Name == action.ToolName(act.Type()) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendToolCall adds the i-th history entry to the conversation as a
+// tool_use block and the tool_result answering it
+func (ca *CodeActAgent) appendToolCall(messages []llm.Message, i int, entry state.HistoryEntry) []llm.Message {
+	id := fmt.Sprintf("toolu_%d", i)
+	input, _ := json.Marshal(entry.Action.ToMemory()["args"])
+	messages = appendBlock(messages, "assistant", anthropic.NewToolUseBlock(id, action.ToolName(entry.Action.Type()), input))
+
+	obs := entry.Observation
+	if obs == nil {
+		obs = observation.NewNullObservation()
+	}
+	result := action.ToolResult(id, obs)
+	result.Content = ca.truncate(result.Content)
+	return appendBlock(messages, "user", result)
+}
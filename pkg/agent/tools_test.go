@@ -0,0 +1,181 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	anthropic "github.com/openagentsinc/autodev/llm"
+	"github.com/openagentsinc/autodev/pkg/action"
+	"github.com/openagentsinc/autodev/pkg/llm"
+	"github.com/openagentsinc/autodev/pkg/memory"
+	"github.com/openagentsinc/autodev/pkg/observation"
+	"github.com/openagentsinc/autodev/pkg/plan"
+	"github.com/openagentsinc/autodev/pkg/state"
+)
+
+// toolUseResponse returns a response calling one tool after some text
+func toolUseResponse(text, tool, input string) *llm.Response {
+	response := llm.TextResponse(text)
+	response.Content = append(response.Content, anthropic.NewToolUseBlock("toolu_abc", tool, json.RawMessage(input)))
+	response.StopReason = "tool_use"
+	return response
+}
+
+func TestToolStep(t *testing.T) {
+	tests := []struct {
+		name     string
+		response *llm.Response
+		want     action.Action
+		complete bool
+	}{
+		{
+			name:     "tool call",
+			response: toolUseResponse("Let me look around.", "run", `{"command": "ls -la"}`),
+			want:     action.NewCmdRunAction("ls -la", false),
+		},
+		{
+			name:     "finish",
+			response: toolUseResponse("", "finish", `{"thought": "Created hello.py."}`),
+			want:     action.NewAgentFinishAction(nil, "Created hello.py."),
+			complete: true,
+		},
+		{
+			name:     "no tool call",
+			response: llm.TextResponse(" I should read the README first. "),
+			want:     action.NewAgentThinkAction("I should read the README first."),
+		},
+		{
+			name:     "unknown tool",
+			response: toolUseResponse("", "fly", `{}`),
+			want:     action.NewAgentThinkAction("My call to fly failed: unknown action type: FLY"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca := NewCodeActAgent(llm.NewScriptedProvider(tt.response), nil)
+			ca.Tools = true
+			got, err := ca.Step(context.Background(), state.NewState(plan.NewPlan("Test")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Step() = %#v, want %#v", got, tt.want)
+			}
+			if ca.IsComplete() != tt.complete {
+				t.Errorf("IsComplete() = %v, want %v", ca.IsComplete(), tt.complete)
+			}
+		})
+	}
+}
+
+func toolNames(tools []llm.Tool) []string {
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	return names
+}
+
+func TestToolRequest(t *testing.T) {
+	provider := llm.NewScriptedProvider(llm.TextResponse("Thinking."), llm.TextResponse("Thinking."))
+	ca := NewCodeActAgent(provider, nil, llm.WithModel("test-model"))
+	ca.Tools = true
+	s := state.NewState(plan.NewPlan("Test"))
+
+	if _, err := ca.Step(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	m, err := memory.New("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.SetMemory(m)
+	ca.Delegates = []string{"verifier", "browser"}
+	if _, err := ca.Step(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := provider.Requests()
+	for i, req := range requests {
+		if req.Model != "test-model" || len(req.StopSequences) != 0 {
+			t.Errorf("request %d: model %q, stop sequences %v, want the agent's model and none", i, req.Model, req.StopSequences)
+		}
+		if system := req.System[len(req.System)-1].Text; system != toolInstructions {
+			t.Errorf("request %d: system prompt = %q, want the tool instructions", i, system)
+		}
+	}
+
+	// Recall and delegate are only offered when they can be used
+	names := toolNames(requests[0].Tools)
+	for _, name := range names {
+		if name == "recall" || name == "delegate" {
+			t.Errorf("request 0 offers %s without a memory or delegates: %v", name, names)
+		}
+	}
+	if got, want := len(requests[1].Tools), len(action.Tools()); got != want {
+		t.Errorf("request 1 offers %d tools, want all %d: %v", got, want, toolNames(requests[1].Tools))
+	}
+	for _, tool := range requests[1].Tools {
+		if tool.Name == "delegate" && !strings.Contains(tool.Description, "verifier, browser") {
+			t.Errorf("delegate tool does not name the agents: %q", tool.Description)
+		}
+	}
+}
+
+func TestToolMessages(t *testing.T) {
+	s := state.NewState(plan.NewPlan("Build a server"))
+	s.History = []state.HistoryEntry{
+		{Action: action.NewAgentThinkAction("First, a plan."), Observation: observation.NewNullObservation()},
+		{Action: action.NewCmdRunAction("ls", false), Observation: observation.NewCmdOutputObservation("go.mod", -1, "ls", 0)},
+		{Action: action.NewFileWriteAction("main.go", "package main\n", 0, -1), Observation: observation.NewAgentErrorObservation("permission denied")},
+		{Action: action.NewAgentThinkAction("Hmm."), Observation: observation.NewNullObservation()},
+	}
+
+	ca := NewCodeActAgent(nil, nil)
+	ca.Tools = true
+	messages := ca.Messages(s)
+
+	var roles []string
+	for _, message := range messages {
+		roles = append(roles, message.Role)
+	}
+	if want := []string{"user", "assistant", "user", "assistant", "user", "assistant", "user"}; !reflect.DeepEqual(roles, want) {
+		t.Fatalf("roles = %v, want %v", roles, want)
+	}
+
+	// A thought and the next tool call share a turn
+	if got := messages[1].Content; len(got) != 2 || got[0].Text != "First, a plan." || got[1].Type != anthropic.BlockToolUse {
+		t.Fatalf("turn 1 = %+v, want the thought and a tool call", got)
+	}
+	calls := []struct {
+		use, result anthropic.ContentBlock
+		name, input string
+		content     string
+		isError     bool
+	}{
+		{use: messages[1].Content[1], result: messages[2].Content[0], name: "run",
+			input: `{"background":false,"command":"ls"}`, content: "go.mod\n[Command finished with exit code 0]"},
+		{use: messages[3].Content[0], result: messages[4].Content[0], name: "write",
+			input: `{"content":"package main\n","end":-1,"path":"main.go","start":0}`, content: "permission denied", isError: true},
+	}
+	for i, call := range calls {
+		if call.use.Name != call.name || string(call.use.Input) != call.input {
+			t.Errorf("call %d = %s %s, want %s %s", i, call.use.Name, call.use.Input, call.name, call.input)
+		}
+		if call.result.Type != anthropic.BlockToolResult || call.result.ToolUseID != call.use.ID {
+			t.Errorf("call %d: result %+v does not answer %s", i, call.result, call.use.ID)
+		}
+		if call.result.Content != call.content || call.result.IsError != call.isError {
+			t.Errorf("call %d: result = %q, error %v, want %q, %v", i, call.result.Content, call.result.IsError, call.content, call.isError)
+		}
+	}
+	if calls[0].use.ID == calls[1].use.ID {
+		t.Errorf("tool calls share the id %s", calls[0].use.ID)
+	}
+	if got := messages[6].Text(); got != toolContinuePrompt {
+		t.Errorf("a trailing thought should be followed by the continue prompt, got %q", got)
+	}
+}
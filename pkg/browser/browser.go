@@ -0,0 +1,165 @@
+// Package browser fetches web pages for agents, converting them to
+// markdown the model can read and optionally capturing screenshots with a
+// headless Chrome.
+package browser
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/openagentsinc/autodev/pkg/observation"
+)
+
+// Defaults for a Browser
+const (
+	DefaultTimeout   = 30 * time.Second
+	DefaultMaxBytes  = 5 << 20
+	DefaultUserAgent = "Mozilla/5.0 (compatible; autodev/1.0)"
+)
+
+// Page is a fetched page
+type Page struct {
+	// URL is the final URL, after redirects
+	URL        string
+	StatusCode int
+	Title      string
+
+	// Content is the page as markdown, or as text for non-HTML pages
+	Content string
+
+	// Screenshot is a base64 encoded PNG, if a Screenshotter is set
+	Screenshot string
+}
+
+// Screenshotter captures a screenshot of a URL as a base64 encoded PNG
+type Screenshotter interface {
+	Screenshot(ctx context.Context, url string) (string, error)
+}
+
+// Browser fetches pages over HTTP
+type Browser struct {
+	Client    *http.Client
+	Timeout   time.Duration
+	MaxBytes  int64
+	UserAgent string
+
+	// Screenshotter captures screenshots of HTML pages when set, such as a
+	// Chrome started with NewChrome
+	Screenshotter Screenshotter
+}
+
+// New creates a Browser with the default settings
+func New() *Browser {
+	return &Browser{
+		Client:    http.DefaultClient,
+		Timeout:   DefaultTimeout,
+		MaxBytes:  DefaultMaxBytes,
+		UserAgent: DefaultUserAgent,
+	}
+}
+
+// Browse fetches a page. Pages with an error status are still returned,
+// along with their content, so only failing to fetch the page at all is an
+// error.
+func (b *Browser) Browse(ctx context.Context, rawURL string) (*Page, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err == nil && u.Scheme == "" {
+		u, err = url.Parse("https://" + strings.TrimSpace(rawURL))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %v", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	if b.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("User-Agent", b.UserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/plain;q=0.9,*/*;q=0.8")
+
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %v", u, err)
+	}
+	defer resp.Body.Close()
+
+	maxBytes := b.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", u, err)
+	}
+
+	page := &Page{
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType = http.DetectContentType(body)
+		mediaType, _, _ = mime.ParseMediaType(mediaType)
+	}
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		page.Content, page.Title, err = Markdown(bytes.NewReader(body), resp.Request.URL)
+		if err != nil {
+			return nil, err
+		}
+	case strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json") || strings.HasSuffix(mediaType, "xml"):
+		page.Content = string(body)
+	case utf8.Valid(body) && !bytes.ContainsRune(body, 0):
+		page.Content = string(body)
+	default:
+		page.Content = fmt.Sprintf("[Binary content of type %s, %d bytes]", mediaType, len(body))
+	}
+
+	if b.Screenshotter != nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml") {
+		// A screenshot is a nice to have, so failing to take one is not an
+		// error.
+		page.Screenshot, _ = b.Screenshotter.Screenshot(ctx, page.URL)
+	}
+
+	return page, nil
+}
+
+// Observe browses a URL and returns the result as an observation, reporting
+// failures to fetch the page in the observation rather than as an error
+func (b *Browser) Observe(ctx context.Context, url string) *observation.BrowserOutputObservation {
+	page, err := b.Browse(ctx, url)
+	if err != nil {
+		return observation.NewBrowserOutputObservation(err.Error(), url, "", 0, true)
+	}
+
+	content := page.Content
+	if page.Title != "" && !strings.HasPrefix(content, "# "+page.Title) {
+		content = "# " + page.Title + "\n\n" + content
+	}
+	if content == "" {
+		content = "[The page is empty]"
+	}
+	return observation.NewBrowserOutputObservation(content, page.URL, page.Screenshot, page.StatusCode, page.StatusCode >= 400)
+}
@@ -0,0 +1,225 @@
+package browser
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testPage = `<html>
+<head><title> Test  Page </title><script>alert("hi")</script><style>p { color: red }</style></head>
+<body>
+<h1>Welcome</h1>
+<p>Some <b>bold</b> text and <a href="/docs">the docs</a>.</p>
+<ul><li>one</li><li>two</li></ul>
+</body>
+</html>`
+
+// site serves a few pages for the browser to fetch
+func site(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(testPage))
+	})
+	mux.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("plain text"))
+	})
+	mux.HandleFunc("/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok": true}`))
+	})
+	mux.HandleFunc("/binary", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte{0, 1, 2, 3})
+	})
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/text", http.StatusFound)
+	})
+	mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("<p>Something broke</p>"))
+	})
+	mux.HandleFunc("/agent", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(r.Header.Get("User-Agent")))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestBrowse(t *testing.T) {
+	server := site(t)
+	b := New()
+
+	tests := []struct {
+		path    string
+		url     string
+		status  int
+		title   string
+		content string
+	}{
+		{
+			path:    "/",
+			url:     server.URL + "/",
+			status:  http.StatusOK,
+			title:   "Test Page",
+			content: "# Welcome\n\nSome **bold** text and [the docs](" + server.URL + "/docs).\n\n- one\n- two",
+		},
+		{path: "/text", url: server.URL + "/text", status: http.StatusOK, content: "plain text"},
+		{path: "/json", url: server.URL + "/json", status: http.StatusOK, content: `{"ok": true}`},
+		{path: "/binary", url: server.URL + "/binary", status: http.StatusOK, content: "[Binary content of type application/octet-stream, 4 bytes]"},
+		{path: "/redirect", url: server.URL + "/text", status: http.StatusOK, content: "plain text"},
+		{path: "/error", url: server.URL + "/error", status: http.StatusInternalServerError, content: "Something broke"},
+		{path: "/agent", url: server.URL + "/agent", status: http.StatusOK, content: DefaultUserAgent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			page, err := b.Browse(context.Background(), server.URL+tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.URL != tt.url || page.StatusCode != tt.status || page.Title != tt.title {
+				t.Errorf("page = %s %d %q, want %s %d %q", page.URL, page.StatusCode, page.Title, tt.url, tt.status, tt.title)
+			}
+			if page.Content != tt.content {
+				t.Errorf("content = %q, want %q", page.Content, tt.content)
+			}
+		})
+	}
+}
+
+func TestBrowseErrors(t *testing.T) {
+	b := New()
+	for _, rawURL := range []string{"ftp://example.com/file", "file:///etc/passwd", "http://%zz"} {
+		if _, err := b.Browse(context.Background(), rawURL); err == nil {
+			t.Errorf("Browse(%q) succeeded, want an error", rawURL)
+		}
+	}
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	if _, err := b.Browse(context.Background(), server.URL); err == nil {
+		t.Errorf("want an error fetching from a closed server")
+	}
+}
+
+func TestBrowseMaxBytes(t *testing.T) {
+	server := site(t)
+	b := New()
+	b.MaxBytes = 5
+
+	page, err := b.Browse(context.Background(), server.URL+"/text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Content != "plain" {
+		t.Errorf("content = %q, want the first 5 bytes", page.Content)
+	}
+}
+
+type fakeScreenshotter struct {
+	urls []string
+}
+
+func (f *fakeScreenshotter) Screenshot(ctx context.Context, url string) (string, error) {
+	f.urls = append(f.urls, url)
+	return "c2NyZWVu", nil
+}
+
+func TestBrowseScreenshots(t *testing.T) {
+	server := site(t)
+	screenshots := &fakeScreenshotter{}
+	b := New()
+	b.Screenshotter = screenshots
+
+	page, err := b.Browse(context.Background(), server.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Screenshot != "c2NyZWVu" {
+		t.Errorf("screenshot = %q", page.Screenshot)
+	}
+	if _, err := b.Browse(context.Background(), server.URL+"/text"); err != nil {
+		t.Fatal(err)
+	}
+	if len(screenshots.urls) != 1 || screenshots.urls[0] != server.URL+"/" {
+		t.Errorf("screenshots taken of %v, want only the HTML page", screenshots.urls)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	server := site(t)
+	b := New()
+
+	obs := b.Observe(context.Background(), server.URL+"/")
+	if obs.Error || obs.StatusCode != http.StatusOK || obs.URL != server.URL+"/" {
+		t.Errorf("observation = %+v", obs)
+	}
+	if !strings.HasPrefix(obs.Content, "# Test Page\n\n# Welcome") {
+		t.Errorf("content should start with the title, got %q", obs.Content)
+	}
+
+	obs = b.Observe(context.Background(), server.URL+"/error")
+	if !obs.Error || obs.StatusCode != http.StatusInternalServerError || obs.Content != "Something broke" {
+		t.Errorf("error page observation = %+v", obs)
+	}
+
+	obs = b.Observe(context.Background(), server.URL+"/missing")
+	if !obs.Error || obs.StatusCode != http.StatusNotFound {
+		t.Errorf("missing page observation = %+v", obs)
+	}
+
+	obs = b.Observe(context.Background(), server.URL+"/empty")
+	if obs.Content != "[The page is empty]" {
+		t.Errorf("empty page content = %q", obs.Content)
+	}
+
+	obs = b.Observe(context.Background(), "ftp://example.com")
+	if !obs.Error || obs.StatusCode != 0 || !strings.Contains(obs.Content, "unsupported URL scheme") {
+		t.Errorf("failed fetch observation = %+v", obs)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	base, _ := url.Parse("https://example.com/docs/")
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"emphasis", `<p>Some <b>bold</b> and <em>em</em> text</p>`, "Some **bold** and _em_ text"},
+		{"relative link", `<a href="guide">the guide</a>`, "[the guide](https://example.com/docs/guide)"},
+		{"image", `<img src="/logo.png" alt="Logo">`, "![Logo](https://example.com/logo.png)"},
+		{"lists", `<ul><li>one</li><li>two</li></ul><ol><li>first</li><li>second</li></ol>`, "- one\n- two\n\n1. first\n2. second"},
+		{"code", "<pre><code>go test ./...\n</code></pre><p>Run <code>make</code></p>", "```\ngo test ./...\n```\n\nRun `make`"},
+		{"table", `<table><tr><th>A</th><th>B</th></tr><tr><td>1</td><td>2</td></tr></table>`, "| A | B |\n| --- | --- |\n| 1 | 2 |"},
+		{"blocks", `<p>a<br>b</p><blockquote>quoted</blockquote><hr><h3>Three</h3>`, "a\nb\n\n> quoted\n\n---\n\n### Three"},
+		{"skipped", `<script>x()</script><style>p{}</style><p>shown</p><button>hidden</button>`, "shown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := Markdown(strings.NewReader(tt.html), base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Markdown() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package browser
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// ErrNoChrome is returned by NewChrome when no Chrome or Chromium is
+// installed
+var ErrNoChrome = errors.New("no Chrome or Chromium installation found")
+
+// chromeNames are the executables tried by NewChrome, in order
+var chromeNames = []string{
+	"chromium",
+	"chromium-browser",
+	"google-chrome",
+	"google-chrome-stable",
+	"chrome",
+	"headless-shell",
+	"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
+	"/Applications/Chromium.app/Contents/MacOS/Chromium",
+}
+
+var (
+	devToolsListening = regexp.MustCompile(`DevTools listening on (ws://\S+)`)
+	browserPath       = regexp.MustCompile(`/devtools/browser/.*$`)
+)
+
+// Chrome is a headless Chrome driven over the Chrome DevTools Protocol, used
+// to take screenshots of pages. It implements Screenshotter.
+type Chrome struct {
+	// Width and Height set the size of the viewport captured
+	Width  int
+	Height int
+
+	cmd     *exec.Cmd
+	dataDir string
+	wsURL   string
+
+	mu   sync.Mutex
+	conn *cdpConn // browser-level connection, for managing tabs
+}
+
+// NewChrome starts a headless Chrome. If path is empty, the first Chrome or
+// Chromium found on the system is used, and ErrNoChrome is returned when
+// there is none.
+func NewChrome(path string) (*Chrome, error) {
+	if path == "" {
+		for _, name := range chromeNames {
+			if found, err := exec.LookPath(name); err == nil {
+				path = found
+				break
+			}
+		}
+		if path == "" {
+			return nil, ErrNoChrome
+		}
+	}
+
+	dataDir, err := os.MkdirTemp("", "autodev-chrome-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create profile directory: %v", err)
+	}
+
+	args := []string{
+		"--headless=new",
+		"--disable-gpu",
+		"--no-first-run",
+		"--no-default-browser-check",
+		"--hide-scrollbars",
+		"--mute-audio",
+		"--remote-debugging-port=0",
+		"--user-data-dir=" + dataDir,
+	}
+	if os.Geteuid() == 0 {
+		// Chrome refuses to run its sandbox as root, as in most containers.
+		args = append(args, "--no-sandbox")
+	}
+	cmd := exec.Command(path, append(args, "about:blank")...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		os.RemoveAll(dataDir)
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dataDir)
+		return nil, fmt.Errorf("failed to start %s: %v", path, err)
+	}
+
+	c := &Chrome{Width: 1280, Height: 800, cmd: cmd, dataDir: dataDir}
+
+	// Chrome prints the address of its DevTools endpoint once it is ready.
+	found := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			if m := devToolsListening.FindStringSubmatch(scanner.Text()); m != nil {
+				found <- m[1]
+				break
+			}
+		}
+		io.Copy(io.Discard, stderr)
+		close(found)
+	}()
+
+	select {
+	case wsURL, ok := <-found:
+		if !ok {
+			c.Close()
+			return nil, fmt.Errorf("%s exited before DevTools started", path)
+		}
+		c.wsURL = wsURL
+	case <-time.After(20 * time.Second):
+		c.Close()
+		return nil, fmt.Errorf("timed out waiting for %s to start", path)
+	}
+
+	c.conn, err = dialCDP(c.wsURL)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Screenshot loads a URL in a new tab and captures the viewport as a base64
+// encoded PNG
+func (c *Chrome) Screenshot(ctx context.Context, url string) (string, error) {
+	c.mu.Lock()
+	browser := c.conn
+	c.mu.Unlock()
+	if browser == nil {
+		return "", fmt.Errorf("chrome is closed")
+	}
+
+	var target struct {
+		TargetID string `json:"targetId"`
+	}
+	if err := browser.call(ctx, "Target.createTarget", map[string]interface{}{"url": "about:blank"}, &target); err != nil {
+		return "", err
+	}
+	defer browser.call(context.Background(), "Target.closeTarget", map[string]interface{}{"targetId": target.TargetID}, nil)
+
+	page, err := dialCDP(pageURL(c.wsURL, target.TargetID))
+	if err != nil {
+		return "", err
+	}
+	defer page.close()
+
+	if err := page.call(ctx, "Emulation.setDeviceMetricsOverride", map[string]interface{}{
+		"width": c.Width, "height": c.Height, "deviceScaleFactor": 1, "mobile": false,
+	}, nil); err != nil {
+		return "", err
+	}
+	if err := page.call(ctx, "Page.enable", nil, nil); err != nil {
+		return "", err
+	}
+
+	loaded := page.subscribe("Page.loadEventFired")
+	var navigate struct {
+		ErrorText string `json:"errorText"`
+	}
+	if err := page.call(ctx, "Page.navigate", map[string]interface{}{"url": url}, &navigate); err != nil {
+		return "", err
+	}
+	if navigate.ErrorText != "" {
+		return "", fmt.Errorf("failed to load %s: %s", url, navigate.ErrorText)
+	}
+	select {
+	case <-loaded:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-page.done:
+		return "", page.err
+	}
+
+	var shot struct {
+		Data string `json:"data"`
+	}
+	if err := page.call(ctx, "Page.captureScreenshot", map[string]interface{}{"format": "png"}, &shot); err != nil {
+		return "", err
+	}
+	return shot.Data, nil
+}
+
+// Close stops Chrome and removes its profile
+func (c *Chrome) Close() error {
+	c.mu.Lock()
+	if c.conn != nil {
+		c.conn.close()
+		c.conn = nil
+	}
+	c.mu.Unlock()
+
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+		c.cmd.Wait()
+	}
+	return os.RemoveAll(c.dataDir)
+}
+
+// pageURL returns the DevTools address of a tab, which lives next to the
+// browser's address
+func pageURL(browserURL, targetID string) string {
+	return browserPath.ReplaceAllString(browserURL, "/devtools/page/"+targetID)
+}
+
+// cdpConn is a DevTools Protocol connection, matching responses to calls by
+// id and delivering events to subscribers
+type cdpConn struct {
+	ws *websocket.Conn
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan cdpMessage
+	events  map[string][]chan struct{}
+
+	done chan struct{}
+	err  error
+}
+
+type cdpMessage struct {
+	ID     int             `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params interface{}     `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func dialCDP(url string) (*cdpConn, error) {
+	ws, err := websocket.Dial(url, "", "http://localhost/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to DevTools: %v", err)
+	}
+	// Screenshots arrive in a single message that can be several megabytes.
+	ws.MaxPayloadBytes = 64 << 20
+
+	c := &cdpConn{
+		ws:      ws,
+		pending: make(map[int]chan cdpMessage),
+		events:  make(map[string][]chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go c.read()
+	return c, nil
+}
+
+func (c *cdpConn) read() {
+	for {
+		var msg cdpMessage
+		if err := websocket.JSON.Receive(c.ws, &msg); err != nil {
+			c.mu.Lock()
+			c.err = fmt.Errorf("DevTools connection lost: %v", err)
+			c.mu.Unlock()
+			close(c.done)
+			return
+		}
+
+		c.mu.Lock()
+		if msg.ID != 0 {
+			if ch, ok := c.pending[msg.ID]; ok {
+				delete(c.pending, msg.ID)
+				ch <- msg
+			}
+		} else {
+			for _, ch := range c.events[msg.Method] {
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// subscribe returns a channel notified when an event arrives
+func (c *cdpConn) subscribe(method string) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	c.mu.Lock()
+	c.events[method] = append(c.events[method], ch)
+	c.mu.Unlock()
+	return ch
+}
+
+// call sends a command and decodes its result into result, if not nil
+func (c *cdpConn) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	ch := make(chan cdpMessage, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	if err := websocket.JSON.Send(c.ws, cdpMessage{ID: id, Method: method, Params: params}); err != nil {
+		return fmt.Errorf("failed to send %s: %v", method, err)
+	}
+
+	select {
+	case msg := <-ch:
+		if msg.Error != nil {
+			return fmt.Errorf("%s failed: %s", method, msg.Error.Message)
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				return fmt.Errorf("invalid %s result: %v", method, err)
+			}
+		}
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return ctx.Err()
+	case <-c.done:
+		return c.err
+	}
+}
+
+func (c *cdpConn) close() {
+	c.ws.Close()
+}
@@ -0,0 +1,336 @@
+package browser
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// skipped elements have no readable content
+var skipped = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Head:     true,
+	atom.Svg:      true,
+	atom.Iframe:   true,
+	atom.Button:   true,
+	atom.Select:   true,
+}
+
+// blocks are elements that start on a new line
+var blocks = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true,
+	atom.Main: true, atom.Header: true, atom.Footer: true, atom.Nav: true,
+	atom.Aside: true, atom.Ul: true, atom.Ol: true, atom.Table: true,
+	atom.Tr: true, atom.Blockquote: true, atom.Pre: true, atom.Hr: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Dl: true, atom.Dt: true, atom.Dd: true, atom.Figure: true, atom.Figcaption: true,
+	atom.Details: true, atom.Summary: true, atom.Form: true, atom.Fieldset: true,
+}
+
+var (
+	spaces     = regexp.MustCompile(`[ \t\r\n]+`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// Markdown converts an HTML document to markdown for the model to read,
+// keeping headings, links, lists, code and tables and dropping scripts,
+// styles and markup. Relative links are resolved against base, if given. It
+// also returns the document title.
+func Markdown(r io.Reader, base *url.URL) (string, string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse HTML: %v", err)
+	}
+
+	c := &converter{base: base}
+	c.title = strings.TrimSpace(spaces.ReplaceAllString(text(find(doc, atom.Title)), " "))
+	c.walk(doc)
+
+	var lines []string
+	for _, line := range strings.Split(c.out.String(), "\n") {
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+	content := blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(content), c.title, nil
+}
+
+type converter struct {
+	base  *url.URL
+	title string
+	out   strings.Builder
+
+	lists []*list // enclosing lists, innermost last
+	pre   int     // depth of enclosing <pre> elements
+	tight bool    // blocks are separated by single newlines, as in list items
+}
+
+type list struct {
+	ordered bool
+	item    int
+}
+
+// newline ends the current line unless it is already empty
+func (c *converter) newline() {
+	s := c.out.String()
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		c.out.WriteString("\n")
+	}
+}
+
+// block converts the children of n on their own, for content that is
+// indented or prefixed as a whole
+func (c *converter) block(n *html.Node, tight bool) string {
+	sub := &converter{base: c.base, tight: tight}
+	sub.children(n)
+	return strings.TrimSpace(blankLines.ReplaceAllString(sub.out.String(), "\n\n"))
+}
+
+// paragraph separates a block from the content around it with a blank line
+func (c *converter) paragraph() {
+	s := c.out.String()
+	if s == "" {
+		return
+	}
+	if !strings.HasSuffix(s, "\n") {
+		c.out.WriteString("\n")
+	}
+	if !c.tight && !strings.HasSuffix(c.out.String(), "\n\n") {
+		c.out.WriteString("\n")
+	}
+}
+
+func (c *converter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		c.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		c.children(n)
+		return
+	}
+
+	if skipped[n.DataAtom] || hasAttr(n, "hidden") || attr(n, "aria-hidden") == "true" {
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		c.paragraph()
+		level := int(n.Data[1] - '0')
+		c.out.WriteString(strings.Repeat("#", level) + " " + inline(n))
+		c.paragraph()
+	case atom.Br:
+		c.newline()
+	case atom.Hr:
+		c.paragraph()
+		c.out.WriteString("---")
+		c.paragraph()
+	case atom.A:
+		label := inline(n)
+		href := c.resolve(attr(n, "href"))
+		switch {
+		case label == "":
+		case href == "" || strings.HasPrefix(href, "javascript:") || strings.HasPrefix(href, "#"):
+			c.text(label)
+		default:
+			c.inline(fmt.Sprintf("[%s](%s)", label, href))
+		}
+	case atom.Img:
+		if alt := strings.TrimSpace(attr(n, "alt")); alt != "" {
+			c.inline(fmt.Sprintf("![%s](%s)", alt, c.resolve(attr(n, "src"))))
+		}
+	case atom.Strong, atom.B:
+		c.wrap(n, "**")
+	case atom.Em, atom.I:
+		c.wrap(n, "_")
+	case atom.Code:
+		if c.pre > 0 {
+			c.children(n)
+		} else {
+			c.wrap(n, "`")
+		}
+	case atom.Pre:
+		c.paragraph()
+		c.out.WriteString("```\n")
+		c.pre++
+		c.children(n)
+		c.pre--
+		c.newline()
+		c.out.WriteString("```")
+		c.paragraph()
+	case atom.Blockquote:
+		c.paragraph()
+		lines := strings.Split(c.block(n, false), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		c.out.WriteString(strings.Join(lines, "\n"))
+		c.paragraph()
+	case atom.Ul, atom.Ol:
+		c.paragraph()
+		c.lists = append(c.lists, &list{ordered: n.DataAtom == atom.Ol})
+		c.children(n)
+		c.lists = c.lists[:len(c.lists)-1]
+		c.paragraph()
+	case atom.Li:
+		// Items are converted on their own so that paragraphs and nested
+		// lists inside them are indented under the marker, and kept tight.
+		c.newline()
+		marker := "- "
+		if len(c.lists) > 0 {
+			l := c.lists[len(c.lists)-1]
+			l.item++
+			if l.ordered {
+				marker = fmt.Sprintf("%d. ", l.item)
+			}
+		}
+		item := strings.ReplaceAll(c.block(n, true), "\n", "\n"+strings.Repeat(" ", len(marker)))
+		c.out.WriteString(marker + item)
+	case atom.Tr:
+		c.newline()
+		c.out.WriteString("|")
+		header := false
+		for cell := n.FirstChild; cell != nil; cell = cell.NextSibling {
+			if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+				c.out.WriteString(" " + strings.ReplaceAll(inline(cell), "|", "\\|") + " |")
+				header = header || cell.DataAtom == atom.Th
+			}
+		}
+		if header {
+			c.newline()
+			c.out.WriteString("|")
+			for cell := n.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+					c.out.WriteString(" --- |")
+				}
+			}
+		}
+	case atom.Input:
+		if value := attr(n, "value"); value != "" && attr(n, "type") != "hidden" {
+			c.inline("[" + value + "]")
+		}
+	default:
+		if blocks[n.DataAtom] {
+			c.paragraph()
+			c.children(n)
+			c.paragraph()
+		} else {
+			c.children(n)
+		}
+	}
+}
+
+func (c *converter) children(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.walk(child)
+	}
+}
+
+// text writes text, collapsing whitespace outside <pre>
+func (c *converter) text(s string) {
+	if c.pre > 0 {
+		c.out.WriteString(s)
+		return
+	}
+	s = spaces.ReplaceAllString(s, " ")
+	out := c.out.String()
+	if out == "" || strings.HasSuffix(out, "\n") || strings.HasSuffix(out, " ") {
+		s = strings.TrimLeft(s, " ")
+	}
+	c.out.WriteString(s)
+}
+
+// inline writes markup as a word of text
+func (c *converter) inline(s string) {
+	out := c.out.String()
+	if out != "" && !strings.HasSuffix(out, "\n") && !strings.HasSuffix(out, " ") && !strings.HasSuffix(out, "(") {
+		c.out.WriteString(" ")
+	}
+	c.out.WriteString(s)
+}
+
+// wrap writes the text of n between delimiters
+func (c *converter) wrap(n *html.Node, delim string) {
+	if s := inline(n); s != "" {
+		c.inline(delim + s + delim)
+	}
+}
+
+// resolve makes a link absolute
+func (c *converter) resolve(href string) string {
+	href = strings.TrimSpace(href)
+	if c.base == nil || href == "" || strings.HasPrefix(href, "#") {
+		return href
+	}
+	u, err := c.base.Parse(href)
+	if err != nil {
+		return href
+	}
+	return u.String()
+}
+
+// inline returns the text of a node on a single line
+func inline(n *html.Node) string {
+	return strings.TrimSpace(spaces.ReplaceAllString(text(n), " "))
+}
+
+// text returns the text content of a node, skipping unreadable elements
+func text(n *html.Node) string {
+	if n == nil {
+		return ""
+	}
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	if n.Type == html.ElementNode && skipped[n.DataAtom] {
+		return ""
+	}
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.DataAtom == atom.Br {
+			b.WriteString(" ")
+		}
+		b.WriteString(text(child))
+	}
+	return b.String()
+}
+
+// find returns the first element of the given type
+func find(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := find(child, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
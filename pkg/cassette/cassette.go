@@ -0,0 +1,296 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/openagentsinc/autodev/pkg/diff"
+)
+
+// Modes a Recorder can run in
+const (
+	// ModeReplay serves responses from the cassette file and never touches
+	// the network
+	ModeReplay = "replay"
+
+	// ModeRecord sends requests to the real transport and saves every
+	// exchange, replacing the cassette file on Save
+	ModeRecord = "record"
+)
+
+// ModeEnv is the environment variable ModeFromEnv reads
+const ModeEnv = "CASSETTE_MODE"
+
+// redactedHeaders are never written to a cassette, since they hold secrets
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"X-Api-Key":           true,
+	"Api-Key":             true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"Proxy-Authorization": true,
+}
+
+// Request is a recorded HTTP request
+type Request struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+// Response is a recorded HTTP response
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// Interaction is one recorded request and the response it received
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Cassette is the contents of a fixture file
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Recorder is an http.RoundTripper that records exchanges to a cassette file
+// or replays them from one. Replayed requests must arrive in the recorded
+// order and match the recording; any difference fails the request with a
+// diff of what changed.
+type Recorder struct {
+	// Transport sends requests in record mode, http.DefaultTransport if nil
+	Transport http.RoundTripper
+
+	path string
+	mode string
+
+	mu       sync.Mutex
+	cassette Cassette
+	next     int
+}
+
+// ModeFromEnv returns the mode set in CASSETTE_MODE, defaulting to replay so
+// tests run offline unless recording is asked for
+func ModeFromEnv() string {
+	if mode := strings.ToLower(os.Getenv(ModeEnv)); mode != "" {
+		return mode
+	}
+	return ModeReplay
+}
+
+// New creates a Recorder for the cassette at path. In replay mode the
+// cassette must already exist.
+func New(path, mode string) (*Recorder, error) {
+	r := &Recorder{path: path, mode: mode}
+
+	switch mode {
+	case ModeRecord:
+	case ModeReplay:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cassette: %v", err)
+		}
+		if err := json.Unmarshal(data, &r.cassette); err != nil {
+			return nil, fmt.Errorf("failed to parse cassette %s: %v", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown cassette mode: %s", mode)
+	}
+
+	return r, nil
+}
+
+// Mode returns the mode the recorder runs in
+func (r *Recorder) Mode() string {
+	return r.mode
+}
+
+// Client returns an HTTP client using the recorder as its transport
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Remaining returns the number of recorded interactions not yet replayed
+func (r *Recorder) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mode != ModeReplay {
+		return 0
+	}
+	return len(r.cassette.Interactions) - r.next
+}
+
+// Save writes the recorded interactions to the cassette file. It does
+// nothing in replay mode.
+func (r *Recorder) Save() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %v", err)
+	}
+	if err := os.WriteFile(r.path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write cassette: %v", err)
+	}
+	return nil
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorded, err := recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.mode == ModeRecord {
+		return r.record(req, recorded)
+	}
+	return r.replay(req, recorded)
+}
+
+func (r *Recorder) record(req *http.Request, recorded Request) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the whole body, including streams, so it can be saved and then
+	// handed back to the caller unchanged.
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: recorded,
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Headers:    redact(resp.Header),
+			Body:       string(body),
+		},
+	})
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request, recorded Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.next
+	if n >= len(r.cassette.Interactions) {
+		return nil, fmt.Errorf("cassette %s: unexpected request %d, only %d recorded:\n%s %s\n%s",
+			r.path, n+1, len(r.cassette.Interactions), recorded.Method, recorded.URL, recorded.Body)
+	}
+
+	interaction := r.cassette.Interactions[n]
+	if d := Compare(interaction.Request, recorded); d != "" {
+		return nil, fmt.Errorf("cassette %s: request %d does not match the recording:\n%s", r.path, n+1, d)
+	}
+	r.next++
+
+	header := interaction.Response.Headers.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+		StatusCode:    interaction.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(interaction.Response.Body)),
+		ContentLength: int64(len(interaction.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+// Compare returns a diff between a recorded and an actual request, or "" if
+// they match. Methods, URLs and bodies are compared; headers are not, as
+// they carry user agents and other incidental values. JSON bodies are
+// compared after normalizing their formatting and key order.
+func Compare(recorded, actual Request) string {
+	return diff.Unified("recorded", "actual", describe(recorded), describe(actual))
+}
+
+func describe(req Request) string {
+	return fmt.Sprintf("%s %s\n\n%s\n", req.Method, req.URL, normalizeBody(req.Body))
+}
+
+// normalizeBody indents JSON bodies so diffs show one field per line
+func normalizeBody(body string) string {
+	var value interface{}
+	if err := json.Unmarshal([]byte(body), &value); err != nil {
+		return body
+	}
+	normalized, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return body
+	}
+	return string(normalized)
+}
+
+// recordRequest captures a request, restoring its body so it can still be
+// sent
+func recordRequest(req *http.Request) (Request, error) {
+	recorded := Request{
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Headers: redact(req.Header),
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return recorded, fmt.Errorf("failed to read request body: %v", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		recorded.Body = string(body)
+	}
+
+	return recorded, nil
+}
+
+// redact returns a copy of the headers without any that hold secrets
+func redact(header http.Header) http.Header {
+	if len(header) == 0 {
+		return nil
+	}
+	clean := make(http.Header, len(header))
+	for name, values := range header {
+		if redactedHeaders[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		clean[name] = append([]string(nil), values...)
+	}
+	return clean
+}
@@ -0,0 +1,184 @@
+package cassette
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	anthropic "github.com/openagentsinc/autodev/llm"
+)
+
+func TestRecordThenReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("echo: " + string(body)))
+	}))
+	path := filepath.Join(t.TempDir(), "fixtures", "echo.json")
+
+	recorder, err := New(path, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(client *http.Client, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/echo", strings.NewReader(body))
+		req.Header.Set("X-Api-Key", "sk-secret")
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Anthropic-Version", "2023-06-01")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	status, body := send(recorder.Client(), `{"n": 1}`)
+	if status != http.StatusCreated || body != `echo: {"n": 1}` {
+		t.Fatalf("recording changed the response: %d %q", status, body)
+	}
+	send(recorder.Client(), `{"n": 2}`)
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"sk-secret", "Bearer secret", "session=secret"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("cassette contains the secret %q", secret)
+		}
+	}
+	if !strings.Contains(string(data), "2023-06-01") {
+		t.Errorf("cassette lost a header that is not secret")
+	}
+
+	// The server is gone, so these can only come from the cassette
+	replayer, err := New(path, ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayer.Remaining() != 2 {
+		t.Errorf("remaining = %d, want 2", replayer.Remaining())
+	}
+	status, body = send(replayer.Client(), `{"n": 1}`)
+	if status != http.StatusCreated || body != `echo: {"n": 1}` {
+		t.Errorf("replayed %d %q, want the recorded response", status, body)
+	}
+	// JSON bodies match regardless of formatting
+	status, body = send(replayer.Client(), "{\n  \"n\":2\n}")
+	if status != http.StatusCreated || body != `echo: {"n": 2}` {
+		t.Errorf("replayed %d %q, want the recorded response", status, body)
+	}
+	if replayer.Remaining() != 0 {
+		t.Errorf("remaining = %d, want 0", replayer.Remaining())
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	replayer, err := New(filepath.Join("testdata", "messages.json"), ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &anthropic.LLM{APIKey: "test", HTTPClient: replayer.Client(), MaxRetries: 0}
+
+	_, err = l.CreateMessage(context.Background(), anthropic.NewRequest(
+		[]anthropic.Message{anthropic.NewTextMessage("user", "Say goodbye")}, 64,
+		anthropic.WithModel("claude-3-5-sonnet-20240620"),
+	))
+	if err == nil {
+		t.Fatal("want an error for a request that differs from the recording")
+	}
+	for _, want := range []string{"does not match the recording", `-          "text": "Say hello"`, `+          "text": "Say goodbye"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not contain %q:\n%v", want, err)
+		}
+	}
+	if replayer.Remaining() != 1 {
+		t.Errorf("a mismatched request used up the recording")
+	}
+}
+
+func TestReplayLLM(t *testing.T) {
+	replayer, err := New(filepath.Join("testdata", "messages.json"), ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &anthropic.LLM{APIKey: "test", HTTPClient: replayer.Client()}
+	request := anthropic.NewRequest(
+		[]anthropic.Message{anthropic.NewTextMessage("user", "Say hello")}, 64,
+		anthropic.WithModel("claude-3-5-sonnet-20240620"),
+	)
+
+	resp, err := l.CreateMessage(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text() != "Hello!" || resp.Usage.OutputTokens != 5 {
+		t.Errorf("response = %+v, want the recorded one", resp)
+	}
+
+	_, err = l.CreateMessage(context.Background(), request)
+	if err == nil || !strings.Contains(err.Error(), "unexpected request 2, only 1 recorded") {
+		t.Errorf("error = %v, want an unexpected request error", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(filepath.Join(t.TempDir(), "missing.json"), ModeReplay); err == nil {
+		t.Errorf("want an error replaying a missing cassette")
+	}
+	if _, err := New("testdata/messages.json", "rewind"); err == nil {
+		t.Errorf("want an error for an unknown mode")
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.json")
+	os.WriteFile(bad, []byte("not json"), 0644)
+	if _, err := New(bad, ModeReplay); err == nil {
+		t.Errorf("want an error for an invalid cassette")
+	}
+}
+
+func TestModeFromEnv(t *testing.T) {
+	t.Setenv(ModeEnv, "")
+	if got := ModeFromEnv(); got != ModeReplay {
+		t.Errorf("default mode = %s, want replay", got)
+	}
+	t.Setenv(ModeEnv, "RECORD")
+	if got := ModeFromEnv(); got != ModeRecord {
+		t.Errorf("mode = %s, want record", got)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	header := http.Header{
+		"X-Api-Key":     {"secret"},
+		"Authorization": {"secret"},
+		"Cookie":        {"secret"},
+		"Content-Type":  {"application/json"},
+	}
+	clean := redact(header)
+	data, _ := json.Marshal(clean)
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("redacted headers still contain secrets: %s", data)
+	}
+	if clean.Get("Content-Type") != "application/json" {
+		t.Errorf("redact dropped Content-Type")
+	}
+	if header.Get("X-Api-Key") != "secret" {
+		t.Errorf("redact changed the original headers")
+	}
+}
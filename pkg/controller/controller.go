@@ -0,0 +1,253 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/openagentsinc/autodev/pkg/action"
+	"github.com/openagentsinc/autodev/pkg/agent"
+	"github.com/openagentsinc/autodev/pkg/browser"
+	"github.com/openagentsinc/autodev/pkg/observation"
+	"github.com/openagentsinc/autodev/pkg/plan"
+	"github.com/openagentsinc/autodev/pkg/state"
+	"github.com/openagentsinc/autodev/pkg/usage"
+	"github.com/openagentsinc/autodev/pkg/workspace"
+)
+
+// Defaults for new controllers
+const (
+	DefaultMaxIterations    = 100
+	DefaultMaxDelegateDepth = 3
+)
+
+// Errors returned by Run when it stops before the agent completes
+var (
+	ErrMaxIterations  = errors.New("maximum iterations reached")
+	ErrBudgetExceeded = errors.New("budget exceeded")
+)
+
+// Controller runs an agent: it asks the agent for an action, executes it and
+// records the resulting observation in the state, until the agent completes
+// or a limit is reached. It implements action.AgentController.
+type Controller struct {
+	// MaxIterations caps the number of steps in the state, including steps
+	// taken before Run was called
+	MaxIterations int
+
+	// MaxBudget caps the cost in USD of LLM calls made during Run. It
+	// requires Tracker and is ignored when zero.
+	MaxBudget float64
+	Tracker   *usage.Tracker
+
+	// Browser fetches pages for browse actions, which fail when it is nil
+	Browser *browser.Browser
+
+	// Registry holds the agents tasks can be delegated to. Delegates run
+	// in their own state, nested at most MaxDelegateDepth deep.
+	Registry         *agent.Registry
+	MaxDelegateDepth int
+
+	// OnStep, if set, is called after each step with the action taken and
+	// the observation it produced, e.g. to show the agent's progress
+	OnStep func(entry state.HistoryEntry)
+
+	agent     agent.Agent
+	manager   action.ActionManager
+	workspace workspace.FS
+	state     *state.State
+	finished  bool
+
+	ctx      context.Context // of the current step, for actions
+	baseline float64         // session cost when Run started
+	depth    int             // number of delegations above this controller
+}
+
+var _ action.AgentController = (*Controller)(nil)
+
+// BackgroundPoller is implemented by action managers that run background
+// commands, to collect their output between steps
+type BackgroundPoller interface {
+	PollBackground() []observation.CmdOutputObservation
+}
+
+// Rememberer is implemented by agents with a memory, to remember each step
+// for later recall
+type Rememberer interface {
+	Remember(item map[string]interface{}) error
+}
+
+// New creates a Controller running agent a on state s, executing commands
+// through manager and file actions in ws
+func New(a agent.Agent, manager action.ActionManager, ws workspace.FS, s *state.State) *Controller {
+	return &Controller{
+		MaxIterations:    DefaultMaxIterations,
+		MaxDelegateDepth: DefaultMaxDelegateDepth,
+		Browser:          browser.New(),
+		ctx:              context.Background(),
+		agent:            a,
+		manager:          manager,
+		workspace:        ws,
+		state:            s,
+	}
+}
+
+func (c *Controller) ActionManager() action.ActionManager {
+	return c.manager
+}
+
+func (c *Controller) Agent() action.Agent {
+	return c.agent
+}
+
+func (c *Controller) Workspace() workspace.FS {
+	return c.workspace
+}
+
+// Plan returns the plan in the state
+func (c *Controller) Plan() *plan.Plan {
+	return c.state.Plan
+}
+
+// Finish merges the agent's outputs into the state and stops Run after the
+// current step
+func (c *Controller) Finish(outputs map[string]interface{}) {
+	if c.state.Outputs == nil {
+		c.state.Outputs = make(map[string]interface{})
+	}
+	for key, value := range outputs {
+		c.state.Outputs[key] = value
+	}
+	c.finished = true
+}
+
+// Finished reports whether the agent has finished
+func (c *Controller) Finished() bool {
+	return c.finished || c.agent.IsComplete()
+}
+
+// Browse fetches a page for a browse action
+func (c *Controller) Browse(url string) (observation.Observation, error) {
+	if c.Browser == nil {
+		return nil, fmt.Errorf("browsing is disabled")
+	}
+	return c.Browser.Observe(c.ctx, url), nil
+}
+
+// State returns the state the agent runs on
+func (c *Controller) State() *state.State {
+	return c.state
+}
+
+// Run steps the agent until it completes, returning nil, or until the
+// iteration cap, the budget or ctx stops it, returning the reason
+func (c *Controller) Run(ctx context.Context) error {
+	if c.Tracker != nil {
+		c.baseline = c.Tracker.Session().Cost
+	}
+
+	for !c.Finished() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if c.MaxIterations > 0 && c.state.Iteration >= c.MaxIterations {
+			return fmt.Errorf("%w (%d)", ErrMaxIterations, c.MaxIterations)
+		}
+		if c.MaxBudget > 0 && c.Tracker != nil {
+			if spent := c.spent(); spent >= c.MaxBudget {
+				return fmt.Errorf("%w ($%.4f of $%.4f)", ErrBudgetExceeded, spent, c.MaxBudget)
+			}
+		}
+
+		if _, err := c.Step(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// spent returns the cost of LLM calls since Run started
+func (c *Controller) spent() float64 {
+	return c.Tracker.Session().Cost - c.baseline
+}
+
+// Step runs a single iteration: the agent chooses an action, the action is
+// executed and the pair is appended to the state's history. LLM usage
+// during the step is recorded against the current plan task.
+func (c *Controller) Step(ctx context.Context) (state.HistoryEntry, error) {
+	ctx = c.taskContext(ctx)
+	c.ctx = ctx
+	defer func() { c.ctx = context.Background() }()
+
+	act, err := c.agent.Step(ctx, c.state)
+	if err != nil {
+		return state.HistoryEntry{}, fmt.Errorf("agent step failed: %v", err)
+	}
+	if act == nil {
+		act = action.NewNullAction()
+	}
+
+	entry := state.HistoryEntry{Action: act, Observation: c.execute(act)}
+
+	c.state.History = append(c.state.History, entry)
+	c.state.UpdatedInfo = append(c.state.UpdatedInfo, entry)
+	c.state.NumOfChars += len(entry.Observation.GetContent())
+	c.state.Iteration++
+
+	if rememberer, ok := c.agent.(Rememberer); ok {
+		c.remember(rememberer, entry)
+	}
+
+	if poller, ok := c.manager.(BackgroundPoller); ok {
+		c.state.BackgroundCommandsObs = poller.PollBackground()
+	}
+
+	if c.OnStep != nil {
+		c.OnStep(entry)
+	}
+
+	return entry, nil
+}
+
+// taskContext attributes the LLM usage of a step to the plan task being
+// worked on, or to the whole plan when no task is in progress
+func (c *Controller) taskContext(ctx context.Context) context.Context {
+	if c.state.Plan == nil {
+		return ctx
+	}
+	return usage.WithTask(ctx, c.state.Plan.CurrentTaskID())
+}
+
+// remember adds a step to the agent's memory, leaving out empty steps and
+// recalled memories, which are already remembered. Memory is an aid, so
+// failing to remember does not stop the agent.
+func (c *Controller) remember(r Rememberer, entry state.HistoryEntry) {
+	switch entry.Action.Type() {
+	case action.TypeNull, action.TypeRecall:
+	default:
+		r.Remember(entry.Action.ToMemory())
+	}
+	switch entry.Observation.GetType() {
+	case observation.TypeNull, observation.TypeRecall:
+	default:
+		r.Remember(entry.Observation.ToMemory())
+	}
+}
+
+// execute runs an action, turning failures into error observations the
+// agent can see and react to
+func (c *Controller) execute(act action.Action) observation.Observation {
+	if !act.IsExecutable() {
+		return observation.NewNullObservation()
+	}
+
+	obs, err := act.Run(c)
+	if err != nil {
+		return observation.NewAgentErrorObservation(err.Error())
+	}
+	if obs == nil {
+		return observation.NewNullObservation()
+	}
+	return obs
+}
@@ -0,0 +1,203 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openagentsinc/autodev/pkg/action"
+	"github.com/openagentsinc/autodev/pkg/agent"
+	"github.com/openagentsinc/autodev/pkg/llm"
+	"github.com/openagentsinc/autodev/pkg/memory"
+	"github.com/openagentsinc/autodev/pkg/observation"
+	"github.com/openagentsinc/autodev/pkg/plan"
+	"github.com/openagentsinc/autodev/pkg/sandbox"
+	"github.com/openagentsinc/autodev/pkg/state"
+	"github.com/openagentsinc/autodev/pkg/usage"
+	"github.com/openagentsinc/autodev/pkg/workspace"
+)
+
+func TestUsagePerTask(t *testing.T) {
+	p := plan.NewPlan("Write a parser")
+	p.AddSubtask("0", "Tokenize", nil)
+	p.AddSubtask("0", "Parse", nil)
+	if err := p.SetSubtaskState("0.0", plan.InProgressState); err != nil {
+		t.Fatal(err)
+	}
+
+	response := llm.TextResponse(`{"updates": [{"id": "0.0", "state": "completed", "reason": "Tokens work"}]}`)
+	response.Usage = llm.Usage{InputTokens: 100, OutputTokens: 20}
+	tracker := usage.NewTracker(usage.DefaultPrices())
+	provider := llm.NewTrackedProvider(llm.NewScriptedProvider(response), tracker)
+
+	c := New(agent.NewPlannerAgent(provider, nil), nil, nil, state.NewState(p))
+	c.Tracker = tracker
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := tracker.Task("0.0"); got.InputTokens != 100 || got.OutputTokens != 20 {
+		t.Errorf("usage of task 0.0 = %+v, want 100 input and 20 output tokens", got)
+	}
+	if got := tracker.Report().Tasks; len(got) != 1 {
+		t.Errorf("report has usage for %d tasks, want 1: %+v", len(got), got)
+	}
+	if task, _ := p.GetTaskByID("0.0"); task.State != plan.CompletedState {
+		t.Errorf("task 0.0 is %s, want completed", task.State)
+	}
+}
+
+func TestCodeActRun(t *testing.T) {
+	stopped := func(text, sequence string) *llm.Response {
+		response := llm.TextResponse(text)
+		response.StopReason = "stop_sequence"
+		response.StopSequence = sequence
+		return response
+	}
+	provider := llm.NewScriptedProvider(
+		stopped("<execute_bash>\necho draft > notes.txt && cat notes.txt\n", "</execute_bash>"),
+		llm.TextResponse("The draft is there, now the real file."),
+		stopped("<write path=\"src/hello.txt\">\nhello\n", "</write>"),
+		stopped("<delegate agent=\"verifier\">Check src/hello.txt.", "</delegate>"),
+		stopped("<finish>Wrote src/hello.txt.", "</finish>"),
+	)
+	verifier := llm.NewScriptedProvider(
+		stopped("<execute_bash>\ncat src/hello.txt\n", "</execute_bash>"),
+		stopped("<finish>It says hello.", "</finish>"),
+	)
+
+	dir := t.TempDir()
+	commands := sandbox.NewLocal(dir)
+	defer commands.Close()
+
+	coder := agent.NewCodeActAgent(provider, nil)
+	coder.Delegates = []string{"verifier"}
+	registry := agent.NewRegistry()
+	registry.Register("verifier", func() agent.Agent { return agent.NewCodeActAgent(verifier, nil) })
+
+	s := state.NewState(plan.NewPlan("Write hello.txt"))
+	c := New(coder, commands, workspace.Dir(dir), s)
+	c.Registry = registry
+	var steps []action.ActionType
+	c.OnStep = func(entry state.HistoryEntry) {
+		steps = append(steps, entry.Action.Type())
+	}
+
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "src", "hello.txt"))
+	if err != nil || string(data) != "hello\n" {
+		t.Errorf("src/hello.txt = %q, %v; want the written content", data, err)
+	}
+	if obs, ok := s.History[0].Observation.(*observation.CmdOutputObservation); !ok || strings.TrimSpace(obs.Content) != "draft" {
+		t.Errorf("command observation = %#v", s.History[0].Observation)
+	}
+	delegated, ok := s.History[3].Observation.(*observation.AgentDelegateObservation)
+	if !ok || !strings.Contains(delegated.Content, "It says hello.") {
+		t.Errorf("delegate observation = %#v", s.History[3].Observation)
+	}
+
+	// The verifier's steps come before the delegate step that ran them
+	want := []action.ActionType{action.TypeRun, action.TypeThink, action.TypeWrite,
+		action.TypeRun, action.TypeFinish, action.TypeDelegate, action.TypeFinish}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("steps = %v, want %v", steps, want)
+	}
+	if provider.Remaining() != 0 || verifier.Remaining() != 0 {
+		t.Errorf("scripted responses left over: %d and %d", provider.Remaining(), verifier.Remaining())
+	}
+
+	// The model sees the output of each action in the next request
+	requests := provider.Requests()
+	last := requests[len(requests)-1].Messages
+	if got := last[len(last)-1].Text(); !strings.Contains(got, "verifier finished. It says hello.") {
+		t.Errorf("last request ends with %q, want the delegate's summary", got)
+	}
+	if got := requests[1].Messages[2].Text(); !strings.Contains(got, "draft\n\n[Command finished with exit code 0]") {
+		t.Errorf("second request does not show the command output: %q", got)
+	}
+}
+
+func TestCodeActBrowse(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<title>Docs</title><p>Use <code>go test</code>.</p>"))
+	}))
+	defer site.Close()
+
+	stopped := func(text, sequence string) *llm.Response {
+		response := llm.TextResponse(text)
+		response.StopReason = "stop_sequence"
+		response.StopSequence = sequence
+		return response
+	}
+	provider := llm.NewScriptedProvider(
+		stopped("<browse>"+site.URL+"/docs", "</browse>"),
+		stopped("<finish>Read the docs.", "</finish>"),
+	)
+
+	var visited []*observation.BrowserOutputObservation
+	c := New(agent.NewCodeActAgent(provider, nil), nil, nil, state.NewState(plan.NewPlan("Read the docs")))
+	c.OnStep = func(entry state.HistoryEntry) {
+		if obs, ok := entry.Observation.(*observation.BrowserOutputObservation); ok {
+			visited = append(visited, obs)
+		}
+	}
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(visited) != 1 || visited[0].URL != site.URL+"/docs" || visited[0].Content != "# Docs\n\nUse `go test`." {
+		t.Fatalf("visited %+v, want the docs page", visited)
+	}
+	second := provider.Requests()[1].Messages
+	if got := second[len(second)-1].Text(); got != "OBSERVATION:\n# Docs\n\nUse `go test`." {
+		t.Errorf("the model saw %q, want the page as markdown", got)
+	}
+}
+
+func TestCodeActRecall(t *testing.T) {
+	stopped := func(text, sequence string) *llm.Response {
+		response := llm.TextResponse(text)
+		response.StopReason = "stop_sequence"
+		response.StopSequence = sequence
+		return response
+	}
+	provider := llm.NewScriptedProvider(
+		stopped("<execute_bash>\necho postgres listens on port 5433\n", "</execute_bash>"),
+		stopped("<execute_bash>\necho unrelated\n", "</execute_bash>"),
+		stopped("<recall>postgres port", "</recall>"),
+		stopped("<finish>Found it.", "</finish>"),
+	)
+	m, err := memory.New("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coder := agent.NewCodeActAgent(provider, nil)
+	coder.SetMemory(m)
+
+	dir := t.TempDir()
+	commands := sandbox.NewLocal(dir)
+	defer commands.Close()
+	s := state.NewState(plan.NewPlan("Find the database port"))
+	if err := New(coder, commands, workspace.Dir(dir), s).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recalled, ok := s.History[2].Observation.(*observation.AgentRecallObservation)
+	if !ok || len(recalled.Memories) == 0 || !strings.Contains(recalled.Memories[0], "port 5433") {
+		t.Fatalf("recall observation = %#v, want the command output first", s.History[2].Observation)
+	}
+	// Both commands with their output and the finish are remembered as they
+	// happen, but not the recall, which would only repeat what is there
+	if m.Len() != 5 {
+		t.Errorf("memory has %d entries, want 5", m.Len())
+	}
+}
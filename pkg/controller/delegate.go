@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/openagentsinc/autodev/pkg/action"
+	"github.com/openagentsinc/autodev/pkg/observation"
+	"github.com/openagentsinc/autodev/pkg/state"
+)
+
+// ErrMaxDelegateDepth is returned when delegates are nested too deeply
+var ErrMaxDelegateDepth = errors.New("maximum delegation depth reached")
+
+var _ action.Delegator = (*Controller)(nil)
+
+// Delegate runs the named agent from the registry on a task described by
+// inputs, in a new state, until it finishes. It shares this controller's
+// action manager, workspace, tracker and OnStep, gets what is left of the
+// budget and the same iteration cap, and returns the outputs it finished
+// with.
+func (c *Controller) Delegate(name string, inputs map[string]interface{}) (observation.Observation, error) {
+	if c.Registry == nil {
+		return nil, fmt.Errorf("no agents are registered to delegate to")
+	}
+	if c.MaxDelegateDepth > 0 && c.depth >= c.MaxDelegateDepth {
+		return nil, fmt.Errorf("%w (%d)", ErrMaxDelegateDepth, c.MaxDelegateDepth)
+	}
+
+	var budget float64
+	if c.MaxBudget > 0 && c.Tracker != nil {
+		budget = c.MaxBudget - c.spent()
+		if budget <= 0 {
+			return nil, fmt.Errorf("%w before delegating to %s", ErrBudgetExceeded, name)
+		}
+	}
+
+	a, err := c.Registry.New(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s := state.NewState(nil)
+	for key, value := range inputs {
+		s.Inputs[key] = value
+	}
+
+	sub := New(a, c.manager, c.workspace, s)
+	sub.MaxIterations = c.MaxIterations
+	sub.Tracker = c.Tracker
+	sub.Browser = c.Browser
+	sub.OnStep = c.OnStep
+	sub.Registry = c.Registry
+	sub.MaxDelegateDepth = c.MaxDelegateDepth
+	sub.depth = c.depth + 1
+	sub.MaxBudget = budget
+
+	if err := sub.Run(c.ctx); err != nil {
+		return nil, fmt.Errorf("delegate %s stopped after %d steps: %w", name, s.Iteration, err)
+	}
+
+	return observation.NewAgentDelegateObservation(delegateSummary(name, s), s.Outputs), nil
+}
+
+// delegateSummary describes what a delegate finished with, for the parent
+// agent to read
+func delegateSummary(name string, s *state.State) string {
+	summary := fmt.Sprintf("%s finished.", name)
+	if n := len(s.History); n > 0 {
+		if finish, ok := s.History[n-1].Action.(*action.AgentFinishAction); ok && finish.Thought != "" {
+			summary += " " + finish.Thought
+		}
+	}
+	if len(s.Outputs) > 0 {
+		outputs, err := json.MarshalIndent(s.Outputs, "", "  ")
+		if err == nil {
+			summary += "\nOutputs:\n" + string(outputs)
+		}
+	}
+	return summary
+}
@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openagentsinc/autodev/pkg/action"
+	"github.com/openagentsinc/autodev/pkg/agent"
+	"github.com/openagentsinc/autodev/pkg/llm"
+	"github.com/openagentsinc/autodev/pkg/observation"
+	"github.com/openagentsinc/autodev/pkg/plan"
+	"github.com/openagentsinc/autodev/pkg/state"
+	"github.com/openagentsinc/autodev/pkg/usage"
+)
+
+// scriptedAgent takes the given actions in turn. Each step records
+// tokens input tokens of the "test" model with tracker, if set.
+type scriptedAgent struct {
+	actions  []action.Action
+	tracker  *usage.Tracker
+	tokens   int
+	inputs   map[string]interface{}
+	complete bool
+}
+
+func (sa *scriptedAgent) Step(ctx context.Context, s *state.State) (action.Action, error) {
+	sa.inputs = s.Inputs
+	if sa.tracker != nil {
+		sa.tracker.Record(ctx, "test", llm.Usage{InputTokens: sa.tokens})
+	}
+	if len(sa.actions) == 0 {
+		return nil, fmt.Errorf("no more actions")
+	}
+	act := sa.actions[0]
+	sa.actions = sa.actions[1:]
+	if act.Type() == action.TypeFinish {
+		sa.complete = true
+	}
+	return act, nil
+}
+
+func (sa *scriptedAgent) SearchMemory(query string) []string { return nil }
+func (sa *scriptedAgent) Reset()                             { sa.complete = false }
+func (sa *scriptedAgent) IsComplete() bool                   { return sa.complete }
+
+// testPrices charge $1 per million input tokens of the "test" model
+var testPrices = usage.PriceTable{"test": {Input: 1}}
+
+func TestDelegate(t *testing.T) {
+	helper := &scriptedAgent{actions: []action.Action{
+		action.NewAgentThinkAction("Looking into it."),
+		action.NewAgentFinishAction(map[string]interface{}{"answer": "42"}, "Found the answer."),
+	}}
+	registry := agent.NewRegistry()
+	registry.Register("helper", func() agent.Agent { return helper })
+
+	parent := &scriptedAgent{actions: []action.Action{
+		action.NewAgentDelegateAction("helper", map[string]interface{}{"task": "Find the answer."}, ""),
+		action.NewAgentDelegateAction("missing", nil, ""),
+		action.NewAgentFinishAction(nil, "Done."),
+	}}
+	s := state.NewState(plan.NewPlan("goal"))
+	c := New(parent, nil, nil, s)
+	c.Registry = registry
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string]interface{}{"task": "Find the answer."}; !reflect.DeepEqual(helper.inputs, want) {
+		t.Errorf("delegate inputs = %v, want %v", helper.inputs, want)
+	}
+	if len(s.History) != 3 {
+		t.Fatalf("parent took %d steps, want 3", len(s.History))
+	}
+	delegated, ok := s.History[0].Observation.(*observation.AgentDelegateObservation)
+	if !ok {
+		t.Fatalf("delegate observation = %#v", s.History[0].Observation)
+	}
+	if want := map[string]interface{}{"answer": "42"}; !reflect.DeepEqual(delegated.Outputs, want) {
+		t.Errorf("delegate outputs = %v, want %v", delegated.Outputs, want)
+	}
+	if !strings.Contains(delegated.Content, "helper finished. Found the answer.") || !strings.Contains(delegated.Content, `"answer": "42"`) {
+		t.Errorf("delegate summary = %q", delegated.Content)
+	}
+	if len(s.Outputs) != 0 {
+		t.Errorf("parent outputs = %v, want the delegate's kept apart", s.Outputs)
+	}
+
+	missing, ok := s.History[1].Observation.(*observation.AgentErrorObservation)
+	if !ok || !strings.Contains(missing.Content, `unknown agent "missing"`) {
+		t.Errorf("delegating to an unknown agent = %#v", s.History[1].Observation)
+	}
+}
+
+func TestDelegateWithoutRegistry(t *testing.T) {
+	c := New(&scriptedAgent{}, nil, nil, state.NewState(nil))
+	if _, err := c.Delegate("helper", nil); err == nil || !strings.Contains(err.Error(), "no agents are registered") {
+		t.Errorf("Delegate() error = %v, want no registry", err)
+	}
+}
+
+func TestDelegateDepth(t *testing.T) {
+	// Every agent delegates to another, until the depth limit stops them
+	created := 0
+	registry := agent.NewRegistry()
+	registry.Register("nested", func() agent.Agent {
+		created++
+		return &scriptedAgent{actions: []action.Action{
+			action.NewAgentDelegateAction("nested", nil, ""),
+			action.NewAgentFinishAction(nil, ""),
+		}}
+	})
+	root, err := registry.New("nested")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var steps []state.HistoryEntry
+	c := New(root, nil, nil, state.NewState(nil))
+	c.Registry = registry
+	c.MaxDelegateDepth = 2
+	c.OnStep = func(entry state.HistoryEntry) {
+		steps = append(steps, entry)
+	}
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if created != 3 {
+		t.Errorf("created %d agents, want the root and 2 delegates", created)
+	}
+	// The innermost delegate's step comes first
+	stopped, ok := steps[0].Observation.(*observation.AgentErrorObservation)
+	if !ok || !strings.Contains(stopped.Content, ErrMaxDelegateDepth.Error()+" (2)") {
+		t.Errorf("first step = %#v, want the depth limit", steps[0].Observation)
+	}
+	for _, entry := range steps[1:] {
+		if _, ok := entry.Observation.(*observation.AgentErrorObservation); ok {
+			t.Errorf("step %s failed: %s", entry.Action.Type(), entry.Observation.GetContent())
+		}
+	}
+}
+
+func TestDelegateBudget(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxBudget float64
+		created   int
+		err       string
+	}{
+		// The delegate gets $0.20 and stops after spending $0.30
+		{name: "delegate runs out", maxBudget: 0.5, created: 1, err: "delegate helper stopped after 1 steps: budget exceeded"},
+		// The parent spends the whole budget in the step that delegates
+		{name: "nothing left to delegate", maxBudget: 0.3, created: 0, err: "budget exceeded before delegating to helper"},
+	}
+	for _, tt := range tests {
+		tracker := usage.NewTracker(testPrices)
+		created := 0
+		registry := agent.NewRegistry()
+		registry.Register("helper", func() agent.Agent {
+			created++
+			return &scriptedAgent{tracker: tracker, tokens: 300000, actions: []action.Action{
+				action.NewAgentThinkAction("Thinking."),
+				action.NewAgentFinishAction(nil, ""),
+			}}
+		})
+
+		parent := &scriptedAgent{tracker: tracker, tokens: 300000, actions: []action.Action{
+			action.NewAgentDelegateAction("helper", nil, ""),
+			action.NewAgentFinishAction(nil, ""),
+		}}
+		s := state.NewState(nil)
+		c := New(parent, nil, nil, s)
+		c.Registry = registry
+		c.Tracker = tracker
+		c.MaxBudget = tt.maxBudget
+
+		err := c.Run(context.Background())
+		if !errors.Is(err, ErrBudgetExceeded) {
+			t.Errorf("%s: Run() error = %v, want the parent over budget", tt.name, err)
+		}
+		if created != tt.created {
+			t.Errorf("%s: created %d delegates, want %d", tt.name, created, tt.created)
+		}
+		if len(s.History) != 1 {
+			t.Fatalf("%s: parent took %d steps, want 1", tt.name, len(s.History))
+		}
+		obs, ok := s.History[0].Observation.(*observation.AgentErrorObservation)
+		if !ok || !strings.Contains(obs.Content, tt.err) {
+			t.Errorf("%s: delegate observation = %#v, want %q", tt.name, s.History[0].Observation, tt.err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openagentsinc/autodev/pkg/agent"
+	"github.com/openagentsinc/autodev/pkg/cassette"
+	"github.com/openagentsinc/autodev/pkg/llm"
+	"github.com/openagentsinc/autodev/pkg/plan"
+	"github.com/openagentsinc/autodev/pkg/state"
+	"github.com/openagentsinc/autodev/pkg/workspace"
+)
+
+// TestReplayCodeAct runs a CodeAct agent against recorded Anthropic API
+// responses. Set CASSETTE_MODE=record and ANTHROPIC_API_KEY to record it
+// again.
+func TestReplayCodeAct(t *testing.T) {
+	recorder, err := cassette.New(filepath.Join("testdata", "codeact_write_file.json"), cassette.ModeFromEnv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey := "test"
+	if recorder.Mode() == cassette.ModeRecord {
+		apiKey = os.Getenv("ANTHROPIC_API_KEY")
+	}
+
+	provider, err := llm.NewAnthropicProvider(apiKey, "claude-3-5-sonnet-20240620")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.Client.HTTPClient = recorder.Client()
+
+	dir := t.TempDir()
+	p := plan.NewPlan("Create hello.txt containing the line hello world, then finish")
+	c := New(agent.NewCodeActAgent(provider, nil), nil, workspace.Dir(dir), state.NewState(p))
+	c.MaxIterations = 5
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "hello.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello world\n" {
+		t.Errorf("hello.txt = %q, want %q", data, "hello world\n")
+	}
+	if n := len(c.State().History); n != 2 {
+		t.Errorf("agent took %d steps, want 2", n)
+	}
+	if n := recorder.Remaining(); n != 0 {
+		t.Errorf("%d recorded requests were not made", n)
+	}
+}
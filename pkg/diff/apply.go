@@ -0,0 +1,192 @@
+package diff
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// hunk is one change in a unified diff
+type hunk struct {
+	oldStart int
+	old      []string
+	new      []string
+}
+
+// Replace replaces the single occurrence of search in text with replace.
+// If search is missing or ambiguous the error says why, and when it is
+// missing, which part of the text is closest.
+func Replace(text, search, replace string) (string, error) {
+	if search == "" {
+		return "", fmt.Errorf("the search text is empty")
+	}
+
+	switch count := strings.Count(text, search); count {
+	case 1:
+		return strings.Replace(text, search, replace, 1), nil
+	case 0:
+		return "", fmt.Errorf("the search text was not found in the file. %s", Describe(text, search))
+	default:
+		return "", fmt.Errorf("the search text was found %d times in the file; include more surrounding lines so it matches exactly once", count)
+	}
+}
+
+// Apply applies a unified diff to text. Hunks are matched by their content,
+// so line numbers that are off are tolerated. A hunk that does not match is
+// reported with the closest part of the text.
+func Apply(text, patch string) (string, error) {
+	hunks, err := parseHunks(patch)
+	if err != nil {
+		return "", err
+	}
+	if len(hunks) == 0 {
+		return "", fmt.Errorf("the patch contains no hunks")
+	}
+
+	lines := Lines(text)
+	var out []string
+	pos := 0
+	for i, h := range hunks {
+		at := findHunk(lines, h, pos)
+		if at < 0 {
+			return "", fmt.Errorf("hunk %d (@@ -%d @@) does not match the file. %s",
+				i+1, h.oldStart, Describe(text, strings.Join(h.old, "")))
+		}
+
+		out = append(out, lines[pos:at]...)
+		out = append(out, h.new...)
+		pos = at + len(h.old)
+	}
+	out = append(out, lines[pos:]...)
+
+	return strings.Join(out, ""), nil
+}
+
+// findHunk returns the line at which a hunk's old lines appear, at or after
+// from, preferring the position closest to the line number in its header.
+// It returns -1 if they do not appear.
+func findHunk(lines []string, h hunk, from int) int {
+	if len(h.old) == 0 {
+		// A pure insertion applies at its stated position.
+		return max(from, min(h.oldStart, len(lines)))
+	}
+
+	best := -1
+	for at := from; at+len(h.old) <= len(lines); at++ {
+		if !linesEqual(lines[at:at+len(h.old)], h.old) {
+			continue
+		}
+		if best < 0 || abs(at-(h.oldStart-1)) < abs(best-(h.oldStart-1)) {
+			best = at
+		}
+	}
+	return best
+}
+
+// linesEqual compares lines ignoring line endings
+func linesEqual(a, b []string) bool {
+	for i := range a {
+		if strings.TrimRight(a[i], "\r\n") != strings.TrimRight(b[i], "\r\n") {
+			return false
+		}
+	}
+	return true
+}
+
+// parseHunks reads the hunks of a unified diff to a single file, ignoring
+// its file headers. Each hunk is read up to the line counts in its header.
+func parseHunks(patch string) ([]hunk, error) {
+	var hunks []hunk
+	var current *hunk
+	var oldLeft, newLeft int
+	var last byte
+	files := 0
+
+	// Every line of the patch ends in a newline unless marked otherwise.
+	patch = ensureNewline(patch)
+
+	for n, line := range Lines(patch) {
+		if strings.HasPrefix(line, `\`) && current != nil {
+			// "\ No newline at end of file" refers to the previous line
+			if last != '+' && len(current.old) > 0 {
+				current.old[len(current.old)-1] = strings.TrimSuffix(current.old[len(current.old)-1], "\n")
+			}
+			if last != '-' && len(current.new) > 0 {
+				current.new[len(current.new)-1] = strings.TrimSuffix(current.new[len(current.new)-1], "\n")
+			}
+			continue
+		}
+
+		if oldLeft > 0 || newLeft > 0 {
+			kind := line[0]
+			if strings.TrimRight(line, "\r\n") == "" {
+				// Editors often strip the space from empty context lines.
+				kind, line = ' ', " "+line
+			}
+			switch {
+			case kind == ' ' && oldLeft > 0 && newLeft > 0:
+				current.old = append(current.old, line[1:])
+				current.new = append(current.new, line[1:])
+				oldLeft--
+				newLeft--
+			case kind == '-' && oldLeft > 0:
+				current.old = append(current.old, line[1:])
+				oldLeft--
+			case kind == '+' && newLeft > 0:
+				current.new = append(current.new, line[1:])
+				newLeft--
+			default:
+				return nil, fmt.Errorf("line %d: unexpected line in hunk %d, whose header says %d more old and %d more new lines follow: %s",
+					n+1, len(hunks), oldLeft, newLeft, strings.TrimSpace(line))
+			}
+			last = kind
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			m := hunkHeader.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("line %d: invalid hunk header: %s", n+1, strings.TrimSpace(line))
+			}
+			start, _ := strconv.Atoi(m[1])
+			oldLeft, newLeft = hunkCount(m[2]), hunkCount(m[4])
+			hunks = append(hunks, hunk{oldStart: start})
+			current = &hunks[len(hunks)-1]
+		case strings.HasPrefix(line, "--- "):
+			files++
+			if files > 1 || len(hunks) > 0 {
+				return nil, fmt.Errorf("line %d: the patch changes more than one file; patch one file at a time", n+1)
+			}
+		case strings.HasPrefix(line, "+++ "):
+		case len(hunks) > 0 && strings.ContainsAny(line[:1], " +-"):
+			return nil, fmt.Errorf("line %d: hunk %d has more lines than its header says: %s", n+1, len(hunks), strings.TrimSpace(line))
+		default:
+			// Other file headers and blank lines between hunks
+		}
+	}
+	if oldLeft > 0 || newLeft > 0 {
+		return nil, fmt.Errorf("hunk %d ends early: its header says %d more old and %d more new lines follow", len(hunks), oldLeft, newLeft)
+	}
+
+	return hunks, nil
+}
+
+// hunkCount returns a line count from a hunk header, which is 1 if omitted
+func hunkCount(s string) int {
+	if s == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
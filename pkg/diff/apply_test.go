@@ -0,0 +1,171 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+const testFile = "package main\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n\nfunc other() {\n\treturn\n}\n"
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		patch string
+		want  string
+	}{
+		{
+			name:  "exact",
+			text:  testFile,
+			patch: "--- a/main.go\n+++ b/main.go\n@@ -3,3 +3,3 @@\n func main() {\n-\tfmt.Println(\"hello\")\n+\tfmt.Println(\"goodbye\")\n }\n",
+			want:  strings.Replace(testFile, "hello", "goodbye", 1),
+		},
+		{
+			name:  "wrong line numbers",
+			text:  testFile,
+			patch: "@@ -30,3 +30,3 @@\n func main() {\n-\tfmt.Println(\"hello\")\n+\tfmt.Println(\"goodbye\")\n }\n",
+			want:  strings.Replace(testFile, "hello", "goodbye", 1),
+		},
+		{
+			name:  "blank context line without a space",
+			text:  testFile,
+			patch: "@@ -5,3 +5,4 @@\n }\n\n func other() {\n+\t// other does nothing\n",
+			want:  strings.Replace(testFile, "func other() {\n", "func other() {\n\t// other does nothing\n", 1),
+		},
+		{
+			name:  "trailing blank lines after the hunk",
+			text:  testFile,
+			patch: "@@ -8 +8 @@\n-\treturn\n+\tpanic(\"unreachable\")\n\n\n",
+			want:  strings.Replace(testFile, "\treturn\n", "\tpanic(\"unreachable\")\n", 1),
+		},
+		{
+			name: "multiple hunks offset by earlier ones",
+			text: testFile,
+			patch: "@@ -1,2 +1,4 @@\n package main\n+\n+import \"fmt\"\n \n" +
+				"@@ -7,3 +9,3 @@\n func other() {\n-\treturn\n+\tfmt.Println(\"other\")\n }\n",
+			want: "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n\nfunc other() {\n\tfmt.Println(\"other\")\n}\n",
+		},
+		{
+			name:  "pure insertion",
+			text:  "a\nb\n",
+			patch: "@@ -1,0 +2 @@\n+inserted\n",
+			want:  "a\ninserted\nb\n",
+		},
+		{
+			name:  "removed and added lines that look like file headers",
+			text:  "x\n-- comment\ny\n",
+			patch: "@@ -1,3 +1,3 @@\n x\n--- comment\n+++ comment\n y\n",
+			want:  "x\n++ comment\ny\n",
+		},
+		{
+			name:  "no newline at end of the old file",
+			text:  "a\nb",
+			patch: "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n",
+			want:  "a\nc\n",
+		},
+		{
+			name:  "no newline at end of the new file",
+			text:  "a\nb\n",
+			patch: "@@ -1,2 +1,2 @@\n a\n-b\n+c\n\\ No newline at end of file\n",
+			want:  "a\nc",
+		},
+	}
+	for _, tt := range tests {
+		got, err := Apply(tt.text, tt.patch)
+		if err != nil {
+			t.Errorf("%s: Apply() error = %v", tt.name, err)
+		} else if got != tt.want {
+			t.Errorf("%s: Apply() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		err   []string
+	}{
+		{
+			name:  "no hunks",
+			patch: "--- a/main.go\n+++ b/main.go\n",
+			err:   []string{"no hunks"},
+		},
+		{
+			name:  "invalid header",
+			patch: "@@ -1,x @@\n a\n",
+			err:   []string{"line 1: invalid hunk header"},
+		},
+		{
+			name:  "more lines than the header says",
+			patch: "@@ -8 +8 @@\n-\treturn\n+\tpanic(\"unreachable\")\n+\t// unreachable\n",
+			err:   []string{"line 4: hunk 1 has more lines than its header says"},
+		},
+		{
+			name:  "fewer lines than the header says",
+			patch: "@@ -7,3 +7,3 @@\n func other() {\n-\treturn\n+\tpanic(\"unreachable\")\n",
+			err:   []string{"hunk 1 ends early", "1 more old and 1 more new"},
+		},
+		{
+			name: "more than one file",
+			patch: "--- a/main.go\n+++ b/main.go\n@@ -8 +8 @@\n-\treturn\n+\tpanic(\"unreachable\")\n" +
+				"--- a/other.go\n+++ b/other.go\n@@ -1 +1 @@\n-package main\n+package other\n",
+			err: []string{"line 6: the patch changes more than one file"},
+		},
+		{
+			// The closest lines are reported so the hunk can be fixed
+			name:  "context that does not match",
+			patch: "@@ -3,3 +3,3 @@\n func main() {\n-\tfmt.Println(\"hallo\")\n+\tfmt.Println(\"goodbye\")\n }\n",
+			err: []string{
+				"hunk 1 (@@ -3 @@) does not match the file",
+				"The most similar lines are 3-5",
+				"-\tfmt.Println(\"hallo\")\n+\tfmt.Println(\"hello\")\n",
+			},
+		},
+		{
+			name:  "indentation that does not match",
+			patch: "@@ -7,3 +7,3 @@\n func other() {\n-    return\n+    panic(\"unreachable\")\n }\n",
+			err:   []string{"Lines 7-9 match apart from indentation or trailing whitespace"},
+		},
+	}
+	for _, tt := range tests {
+		_, err := Apply(testFile, tt.patch)
+		if err == nil {
+			t.Errorf("%s: Apply() succeeded, want an error", tt.name)
+			continue
+		}
+		for _, want := range tt.err {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: Apply() error = %q, want %q", tt.name, err, want)
+			}
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	tests := []struct {
+		search, replace string
+		want            string
+		err             string
+	}{
+		{search: "\"hello\"", replace: "\"goodbye\"", want: strings.Replace(testFile, "hello", "goodbye", 1)},
+		{search: "", err: "the search text is empty"},
+		{search: "}\n", err: "found 2 times"},
+		{search: "func main() {\n    fmt.Println(\"hello\")\n", err: "Lines 3-4 match apart from indentation"},
+		{search: "nothing like it", err: "No similar lines were found"},
+	}
+	for _, tt := range tests {
+		got, err := Replace(testFile, tt.search, tt.replace)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Replace(%q) error = %v, want %q", tt.search, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Replace(%q) error = %v", tt.search, err)
+		} else if got != tt.want {
+			t.Errorf("Replace(%q) = %q, want %q", tt.search, got, tt.want)
+		}
+	}
+}
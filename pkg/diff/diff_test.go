@@ -0,0 +1,59 @@
+package diff
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{text: "", want: nil},
+		{text: "a", want: []string{"a"}},
+		{text: "a\nb\n", want: []string{"a\n", "b\n"}},
+		{text: "a\n\nb", want: []string{"a\n", "\n", "b"}},
+	}
+	for _, tt := range tests {
+		if got := Lines(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Lines(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestUnified(t *testing.T) {
+	if got := Unified("a", "b", testFile, testFile); got != "" {
+		t.Errorf("Unified() of equal texts = %q, want none", got)
+	}
+
+	a := "one\ntwo\nthree\n"
+	b := "one\n2\nthree\nfour"
+	want := "--- a\n+++ b\n@@ -1,3 +1,4 @@\n one\n-two\n+2\n three\n+four\n\\ No newline at end of file\n"
+	if got := Unified("a", "b", a, b); got != want {
+		t.Errorf("Unified() = %q, want %q", got, want)
+	}
+}
+
+// Applying the diff between two texts to the first gives the second
+func TestUnifiedApply(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{a: testFile, b: "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n"},
+		{a: "", b: "new\nfile\n"},
+		{a: "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n", b: "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nK\nl\n"},
+		{a: "a\n\n\nb\n", b: "a\n\nb\n\n"},
+		{a: "no newline", b: "no newline\n"},
+		{a: "x\n-- comment\n", b: "x\n++ comment\n"},
+	}
+	for _, tt := range tests {
+		patch := Unified("a", "b", tt.a, tt.b)
+		got, err := Apply(tt.a, patch)
+		if err != nil {
+			t.Errorf("Apply(%q, %q) error = %v", tt.a, patch, err)
+		} else if got != tt.b {
+			t.Errorf("Apply(%q, %q) = %q, want %q", tt.a, patch, got, tt.b)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Match is the region of a text most similar to a searched-for block
+type Match struct {
+	// Start and End are the matched lines, counting from 0, End exclusive
+	Start, End int
+
+	// Score is the similarity from 0 to 1, where 1 is identical apart from
+	// leading and trailing whitespace
+	Score float64
+
+	// Text is the matched text
+	Text string
+}
+
+// Closest finds the block of lines in text most similar to want, which is
+// compared line by line. It returns false if text has no lines.
+func Closest(text, want string) (Match, bool) {
+	lines := Lines(text)
+	wantLines := Lines(want)
+	if len(lines) == 0 || len(wantLines) == 0 {
+		return Match{}, false
+	}
+
+	size := min(len(wantLines), len(lines))
+	best := Match{Score: -1}
+	for start := 0; start+size <= len(lines); start++ {
+		score := 0.0
+		for i := 0; i < size; i++ {
+			score += lineSimilarity(lines[start+i], wantLines[i])
+		}
+		score /= float64(len(wantLines))
+		if score > best.Score {
+			best = Match{Start: start, End: start + size, Score: score}
+		}
+	}
+
+	best.Text = strings.Join(lines[best.Start:best.End], "")
+	return best, true
+}
+
+// Describe explains how the closest match to want in text differs from it,
+// for error messages that help fix a search block or patch
+func Describe(text, want string) string {
+	match, ok := Closest(text, want)
+	if !ok || match.Score < 0.5 {
+		return "No similar lines were found in the file."
+	}
+	if match.Score == 1 {
+		return fmt.Sprintf("Lines %d-%d match apart from indentation or trailing whitespace:\n%s", match.Start+1, match.End, match.Text)
+	}
+	return fmt.Sprintf("The most similar lines are %d-%d (%.0f%% similar). They differ as follows:\n%s",
+		match.Start+1, match.End, match.Score*100, Unified("expected", "actual", ensureNewline(want), ensureNewline(match.Text)))
+}
+
+// lineSimilarity compares two lines, ignoring surrounding whitespace, as the
+// Dice coefficient of their character bigrams
+func lineSimilarity(a, b string) float64 {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	if a == b {
+		return 1
+	}
+	if len(a) < 2 || len(b) < 2 {
+		return 0
+	}
+
+	bigrams := make(map[string]int)
+	for i := 0; i < len(a)-1; i++ {
+		bigrams[a[i:i+2]]++
+	}
+	shared := 0
+	for i := 0; i < len(b)-1; i++ {
+		if bigrams[b[i:i+2]] > 0 {
+			bigrams[b[i:i+2]]--
+			shared++
+		}
+	}
+	return 2 * float64(shared) / float64(len(a)+len(b)-2)
+}
+
+func ensureNewline(s string) string {
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		return s + "\n"
+	}
+	return s
+}
@@ -0,0 +1,46 @@
+package diff
+
+import "testing"
+
+func TestClosest(t *testing.T) {
+	tests := []struct {
+		want       string
+		start, end int
+		score      float64
+	}{
+		{want: "func other() {\n\treturn\n", start: 6, end: 8, score: 1},
+		{want: "func other() {\n    return\n", start: 6, end: 8, score: 1},
+		{want: "}\n", start: 4, end: 5, score: 1},
+	}
+	for _, tt := range tests {
+		got, ok := Closest(testFile, tt.want)
+		if !ok || got.Start != tt.start || got.End != tt.end || got.Score != tt.score {
+			t.Errorf("Closest(%q) = %d-%d %.2f, want %d-%d %.2f", tt.want, got.Start, got.End, got.Score, tt.start, tt.end, tt.score)
+		}
+	}
+
+	got, _ := Closest(testFile, "func mian() {\n")
+	if got.Start != 2 || got.Score <= 0.5 || got.Score >= 1 {
+		t.Errorf("Closest() of a misspelled line = line %d, %.2f", got.Start, got.Score)
+	}
+	if _, ok := Closest("", "a\n"); ok {
+		t.Errorf("Closest() of an empty text = true, want false")
+	}
+}
+
+func TestLineSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{a: "  same\n", b: "same", want: 1},
+		{a: "abcd", b: "wxyz", want: 0},
+		{a: "a", b: "b", want: 0},
+		{a: "abc", b: "abd", want: 0.5},
+	}
+	for _, tt := range tests {
+		if got := lineSimilarity(tt.a, tt.b); got != tt.want {
+			t.Errorf("lineSimilarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package history
+
+import (
+	"context"
+	"fmt"
+
+	anthropic "github.com/openagentsinc/autodev/llm"
+	"github.com/openagentsinc/autodev/pkg/llm"
+)
+
+// Compaction modes
+const (
+	// ModeSummarize condenses older turns into a summary, like the
+	// SUMMARIZE action
+	ModeSummarize = "summarize"
+	// ModeTruncate drops older turns
+	ModeTruncate = "truncate"
+)
+
+// SummaryHeader introduces the summary of condensed turns in the history
+const SummaryHeader = "Summary of the earlier conversation:\n"
+
+// Policy controls when and how a conversation is compacted to fit the
+// context window
+type Policy struct {
+	// Mode is ModeSummarize or ModeTruncate
+	Mode string
+	// MaxTokens is the size the system prompt and history may reach before
+	// they are compacted
+	MaxTokens int
+	// KeepTokens is the budget for recent turns kept verbatim when the
+	// history is compacted. Defaults to half of MaxTokens.
+	KeepTokens int
+	// KeepRecent is the minimum number of recent messages kept verbatim
+	KeepRecent int
+}
+
+// DefaultPolicy returns the default compaction policy
+func DefaultPolicy() Policy {
+	return Policy{
+		Mode:       ModeSummarize,
+		MaxTokens:  100000,
+		KeepTokens: 50000,
+		KeepRecent: 4,
+	}
+}
+
+// Summarizer condenses a list of messages into a short summary
+type Summarizer interface {
+	Summarize(ctx context.Context, messages []llm.Message) (string, error)
+}
+
+// Manager keeps a conversation within its token budget
+type Manager struct {
+	Policy     Policy
+	Summarizer Summarizer
+
+	// CountTokens estimates the tokens used by a request.
+	// Defaults to llm.EstimateTokens.
+	CountTokens func(req llm.Request) int
+}
+
+// NewManager creates a new Manager
+func NewManager(policy Policy, summarizer Summarizer) *Manager {
+	return &Manager{
+		Policy:      policy,
+		Summarizer:  summarizer,
+		CountTokens: llm.EstimateTokens,
+	}
+}
+
+// Compact returns the conversation unchanged if it fits the policy's
+// MaxTokens together with the system prompt. Otherwise the oldest turns are
+// summarized or dropped, and the result is folded into the first kept user
+// message so roles still alternate and no tool_result loses its tool_use.
+// The compacted history should replace the original, so later calls build
+// on the summary instead of condensing the same turns again.
+func (m *Manager) Compact(ctx context.Context, messages []llm.Message, system []llm.ContentBlock) ([]llm.Message, error) {
+	if m.Policy.MaxTokens <= 0 {
+		return messages, nil
+	}
+
+	count := m.CountTokens
+	if count == nil {
+		count = llm.EstimateTokens
+	}
+
+	if count(llm.Request{System: system, Messages: messages}) <= m.Policy.MaxTokens {
+		return messages, nil
+	}
+
+	split := m.splitPoint(messages, count)
+	if split <= 0 {
+		// No turn boundary we can cut at safely.
+		return messages, nil
+	}
+
+	var note string
+	switch m.Policy.Mode {
+	case ModeTruncate:
+		note = fmt.Sprintf("[%d earlier messages were omitted to fit the context window.]\n\n", split)
+	default:
+		if m.Summarizer == nil {
+			return nil, fmt.Errorf("history policy %q requires a summarizer", m.Policy.Mode)
+		}
+		summary, err := m.Summarizer.Summarize(ctx, messages[:split])
+		if err != nil {
+			return nil, fmt.Errorf("failed to summarize history: %v", err)
+		}
+		note = SummaryHeader + summary + "\n\n"
+	}
+
+	first := messages[split]
+	first.Content = append([]llm.ContentBlock{anthropic.NewTextBlock(note)}, first.Content...)
+
+	compacted := make([]llm.Message, 0, len(messages)-split)
+	compacted = append(compacted, first)
+	compacted = append(compacted, messages[split+1:]...)
+	return compacted, nil
+}
+
+// splitPoint returns the index of the first message to keep verbatim, or 0
+// if the history cannot be split. Only user messages that do not answer a
+// tool call start a new turn, so only those are valid split points.
+func (m *Manager) splitPoint(messages []llm.Message, count func(llm.Request) int) int {
+	keepTokens := m.Policy.KeepTokens
+	if keepTokens <= 0 {
+		keepTokens = m.Policy.MaxTokens / 2
+	}
+	latest := len(messages) - m.Policy.KeepRecent
+
+	split := 0
+	suffixTokens := 0
+	for i := len(messages) - 1; i > 0; i-- {
+		suffixTokens += count(llm.Request{Messages: messages[i : i+1]})
+		if suffixTokens > keepTokens && split > 0 {
+			break
+		}
+		if i <= latest && startsTurn(messages[i]) {
+			split = i
+		}
+	}
+	return split
+}
+
+// startsTurn reports whether a message is a user message that does not
+// carry tool results
+func startsTurn(message llm.Message) bool {
+	if message.Role != "user" {
+		return false
+	}
+	for _, block := range message.Content {
+		if block.Type == anthropic.BlockToolResult {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,208 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	anthropic "github.com/openagentsinc/autodev/llm"
+	"github.com/openagentsinc/autodev/pkg/llm"
+)
+
+// Test conversations are written as one letter per message: u is a user
+// message, a an assistant message, t an assistant tool call and r the
+// user message with its result. Every message counts as 10 tokens.
+func conversation(spec string) []llm.Message {
+	var messages []llm.Message
+	calls := 0
+	for i, kind := range spec {
+		text := string(kind) + string(rune('0'+i))
+		switch kind {
+		case 'u':
+			messages = append(messages, anthropic.NewTextMessage("user", text))
+		case 'a':
+			messages = append(messages, anthropic.NewTextMessage("assistant", text))
+		case 't':
+			calls++
+			id := "call" + string(rune('0'+calls))
+			messages = append(messages, llm.Message{Role: "assistant", Content: []llm.ContentBlock{
+				anthropic.NewToolUseBlock(id, "run", []byte(`{}`)),
+			}})
+		case 'r':
+			id := "call" + string(rune('0'+calls))
+			messages = append(messages, llm.Message{Role: "user", Content: []llm.ContentBlock{
+				anthropic.NewToolResultBlock(id, text, false),
+			}})
+		}
+	}
+	return messages
+}
+
+func countMessages(req llm.Request) int {
+	tokens := 10 * len(req.Messages)
+	for _, block := range req.System {
+		tokens += len(block.Text)
+	}
+	return tokens
+}
+
+// fakeSummarizer records what it was asked to summarize
+type fakeSummarizer struct {
+	got []llm.Message
+	err error
+}
+
+func (fs *fakeSummarizer) Summarize(ctx context.Context, messages []llm.Message) (string, error) {
+	fs.got = messages
+	return "they talked", fs.err
+}
+
+func TestSplitPoint(t *testing.T) {
+	tests := []struct {
+		name         string
+		conversation string
+		keepTokens   int
+		keepRecent   int
+		want         int
+	}{
+		{"keeps recent turns within the budget", "uauaua", 20, 2, 4},
+		{"budget of one turn", "uauaua", 20, 0, 4},
+		{"larger budget keeps more", "uauaua", 40, 2, 2},
+		{"never splits at the first message", "uauaua", 1000, 0, 2},
+		{"keep recent wins over the budget", "uauaua", 10, 4, 2},
+		{"keep recent longer than the history", "uaua", 10, 10, 0},
+		{"single message", "u", 10, 0, 0},
+		{"skips tool results", "uauatrtr", 30, 2, 2},
+		{"no turn to split at", "utrtrtr", 20, 0, 0},
+		{"defaults the budget to half the maximum", "uauaua", 0, 0, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Manager{Policy: Policy{MaxTokens: 40, KeepTokens: tt.keepTokens, KeepRecent: tt.keepRecent}}
+			if got := m.splitPoint(conversation(tt.conversation), countMessages); got != tt.want {
+				t.Errorf("splitPoint(%s) = %d, want %d", tt.conversation, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompact(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         string
+		conversation string
+		system       string
+		maxTokens    int
+		// want is the conversation kept, with the note folded into its
+		// first message, or "" if nothing is compacted
+		want       string
+		wantNote   string
+		summarized int
+	}{
+		{name: "fits", mode: ModeTruncate, conversation: "uauaua", maxTokens: 60},
+		{name: "no limit", mode: ModeTruncate, conversation: "uauaua", maxTokens: 0},
+		{name: "system prompt counts", mode: ModeTruncate, conversation: "uauaua", system: strings.Repeat("s", 10), maxTokens: 60, want: "ua", wantNote: "[4 earlier messages were omitted"},
+		{name: "truncate", mode: ModeTruncate, conversation: "uauaua", maxTokens: 40, want: "ua", wantNote: "[4 earlier messages were omitted"},
+		{name: "summarize", mode: ModeSummarize, conversation: "uauaua", maxTokens: 40, want: "ua", wantNote: SummaryHeader + "they talked", summarized: 4},
+		{name: "truncate keeps tool pairs", mode: ModeTruncate, conversation: "uautrtra", maxTokens: 40, want: "utrtra", wantNote: "[2 earlier messages were omitted"},
+		{name: "summarize keeps tool pairs", mode: ModeSummarize, conversation: "uautrtra", maxTokens: 40, want: "utrtra", wantNote: SummaryHeader, summarized: 2},
+		{name: "nothing to split", mode: ModeSummarize, conversation: "utrtrtr", maxTokens: 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summarizer := &fakeSummarizer{}
+			m := NewManager(Policy{Mode: tt.mode, MaxTokens: tt.maxTokens, KeepRecent: 2}, summarizer)
+			m.CountTokens = countMessages
+			messages := conversation(tt.conversation)
+			var system []llm.ContentBlock
+			if tt.system != "" {
+				system = []llm.ContentBlock{anthropic.NewTextBlock(tt.system)}
+			}
+
+			got, err := m.Compact(context.Background(), messages, system)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.want == "" {
+				if len(got) != len(messages) {
+					t.Fatalf("got %d messages, want the %d unchanged", len(got), len(messages))
+				}
+				return
+			}
+			want := messages[len(messages)-len(tt.want):]
+			if len(got) != len(want) {
+				t.Fatalf("got %d messages, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i].Role != want[i].Role {
+					t.Errorf("message %d is from %s, want %s", i, got[i].Role, want[i].Role)
+				}
+			}
+			if note := got[0].Content[0].Text; !strings.HasPrefix(note, tt.wantNote) {
+				t.Errorf("note = %q, want it to start with %q", note, tt.wantNote)
+			}
+			if got[0].Content[1].Text != want[0].Content[0].Text {
+				t.Errorf("first kept message lost its content: %+v", got[0].Content)
+			}
+			if len(summarizer.got) != tt.summarized {
+				t.Errorf("summarized %d messages, want %d", len(summarizer.got), tt.summarized)
+			}
+			checkToolPairs(t, got)
+		})
+	}
+}
+
+// checkToolPairs fails if a tool result is not answering a tool call in the
+// message before it
+func checkToolPairs(t *testing.T, messages []llm.Message) {
+	t.Helper()
+	for i, message := range messages {
+		for _, block := range message.Content {
+			if block.Type != anthropic.BlockToolResult {
+				continue
+			}
+			found := false
+			if i > 0 {
+				for _, prev := range messages[i-1].Content {
+					if prev.Type == anthropic.BlockToolUse && prev.ID == block.ToolUseID {
+						found = true
+					}
+				}
+			}
+			if !found {
+				t.Errorf("tool result for %s in message %d has no tool call", block.ToolUseID, i)
+			}
+		}
+	}
+}
+
+func TestCompactDoesNotModifyInput(t *testing.T) {
+	m := NewManager(Policy{Mode: ModeTruncate, MaxTokens: 40, KeepRecent: 2}, nil)
+	m.CountTokens = countMessages
+	messages := conversation("uauaua")
+
+	if _, err := m.Compact(context.Background(), messages, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(messages[4].Content) != 1 {
+		t.Errorf("Compact changed the original messages")
+	}
+}
+
+func TestCompactSummarizerErrors(t *testing.T) {
+	m := NewManager(Policy{Mode: ModeSummarize, MaxTokens: 40, KeepRecent: 2}, nil)
+	m.CountTokens = countMessages
+	if _, err := m.Compact(context.Background(), conversation("uauaua"), nil); err == nil {
+		t.Errorf("want an error without a summarizer")
+	}
+
+	failure := errors.New("model unavailable")
+	m.Summarizer = &fakeSummarizer{err: failure}
+	if _, err := m.Compact(context.Background(), conversation("uauaua"), nil); err == nil || !strings.Contains(err.Error(), failure.Error()) {
+		t.Errorf("error = %v, want the summarizer's error", err)
+	}
+}
@@ -0,0 +1,70 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	anthropic "github.com/openagentsinc/autodev/llm"
+	"github.com/openagentsinc/autodev/pkg/llm"
+)
+
+const summarizePrompt = `Summarize the conversation below so it can replace the original messages in an AI coding agent's context.
+Keep the goals, decisions made, files and commands involved, results of actions and any open questions.
+Be concise and reply with the summary only.`
+
+// maxToolResultChars limits how much of each tool result is shown to the summarizer
+const maxToolResultChars = 2000
+
+// LLMSummarizer summarizes messages with a model
+type LLMSummarizer struct {
+	Provider  llm.Provider
+	MaxTokens int
+	Options   []llm.Option
+}
+
+// NewLLMSummarizer creates a new LLMSummarizer
+func NewLLMSummarizer(provider llm.Provider, opts ...llm.Option) *LLMSummarizer {
+	return &LLMSummarizer{
+		Provider:  provider,
+		MaxTokens: 1024,
+		Options:   opts,
+	}
+}
+
+func (s *LLMSummarizer) Summarize(ctx context.Context, messages []llm.Message) (string, error) {
+	prompt := summarizePrompt + "\n\n" + Transcript(messages)
+	req := llm.NewRequest([]llm.Message{anthropic.NewTextMessage("user", prompt)}, s.MaxTokens, s.Options...)
+
+	response, err := s.Provider.Chat(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(response.Text()), nil
+}
+
+// Transcript renders messages as plain text, one turn per paragraph
+func Transcript(messages []llm.Message) string {
+	var b strings.Builder
+	for _, message := range messages {
+		fmt.Fprintf(&b, "%s:\n", message.Role)
+		for _, block := range message.Content {
+			switch block.Type {
+			case anthropic.BlockText:
+				b.WriteString(block.Text + "\n")
+			case anthropic.BlockImage:
+				b.WriteString("[image]\n")
+			case anthropic.BlockToolUse:
+				fmt.Fprintf(&b, "[called %s with %s]\n", block.Name, string(block.Input))
+			case anthropic.BlockToolResult:
+				content := block.Content
+				if len(content) > maxToolResultChars {
+					content = content[:maxToolResultChars] + "..."
+				}
+				fmt.Fprintf(&b, "[tool result]\n%s\n", content)
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package llm
+
+import (
+	"context"
+
+	anthropic "github.com/openagentsinc/autodev/llm"
+)
+
+// AnthropicProvider is a Provider backed by the Anthropic Messages API
+type AnthropicProvider struct {
+	Client *anthropic.LLM
+}
+
+// NewAnthropicProvider creates a new AnthropicProvider
+func NewAnthropicProvider(apiKey, model string) (*AnthropicProvider, error) {
+	client, err := anthropic.NewLLM(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if model != "" {
+		client.Model = model
+	}
+	return &AnthropicProvider{Client: client}, nil
+}
+
+func (ap *AnthropicProvider) Chat(ctx context.Context, req Request) (*Response, error) {
+	return ap.Client.CreateMessage(ctx, req)
+}
+
+func (ap *AnthropicProvider) Stream(ctx context.Context, req Request) (<-chan StreamEvent, error) {
+	return ap.Client.StreamMessage(ctx, req)
+}
+
+func (ap *AnthropicProvider) CountTokens(ctx context.Context, req Request) (int, error) {
+	return ap.Client.CountTokens(ctx, req)
+}
@@ -0,0 +1,458 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	anthropic "github.com/openagentsinc/autodev/llm"
+)
+
+const (
+	DefaultOpenAIBaseURL = "https://api.openai.com/v1"
+	DefaultOpenAIModel   = "gpt-4o"
+)
+
+// OpenAIProvider is a Provider for OpenAI-compatible chat completion APIs,
+// which includes llama.cpp, Ollama and vLLM servers. The API key is optional
+// for local servers.
+type OpenAIProvider struct {
+	APIKey  string
+	BaseURL string
+	Model   string
+
+	// HTTPClient is used to send requests. A new http.Client is used if nil.
+	HTTPClient *http.Client
+}
+
+// NewOpenAIProvider creates a new OpenAIProvider, using the OpenAI API and
+// default model when baseURL or model are empty
+func NewOpenAIProvider(apiKey, baseURL, model string) *OpenAIProvider {
+	if baseURL == "" {
+		baseURL = DefaultOpenAIBaseURL
+	}
+	if model == "" {
+		model = DefaultOpenAIModel
+	}
+	return &OpenAIProvider{
+		APIKey:  apiKey,
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Model:   model,
+	}
+}
+
+type openAIRequest struct {
+	Model         string               `json:"model"`
+	Messages      []openAIMessage      `json:"messages"`
+	MaxTokens     int                  `json:"max_tokens,omitempty"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	Stop          []string             `json:"stop,omitempty"`
+	User          string               `json:"user,omitempty"`
+	Tools         []openAITool         `json:"tools,omitempty"`
+	ToolChoice    interface{}          `json:"tool_choice,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    interface{}      `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+type openAITool struct {
+	Type     string             `json:"type"`
+	Function openAIToolFunction `json:"function"`
+}
+
+type openAIToolFunction struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+type openAIToolCall struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+type openAIResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      openAIResponseMessage `json:"message"`
+		Delta        openAIResponseMessage `json:"delta"`
+		FinishReason string                `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+}
+
+type openAIResponseMessage struct {
+	Content   string           `json:"content"`
+	ToolCalls []openAIToolCall `json:"tool_calls"`
+}
+
+func (op *OpenAIProvider) Chat(ctx context.Context, req Request) (*Response, error) {
+	resp, err := op.send(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	var openAIResp openAIResponse
+	if err := json.Unmarshal(body, &openAIResp); err != nil {
+		return nil, fmt.Errorf("error unmarshalling response: %v", err)
+	}
+
+	if len(openAIResp.Choices) == 0 {
+		return nil, fmt.Errorf("no choices in response")
+	}
+
+	choice := openAIResp.Choices[0]
+	response := &Response{
+		ID:         openAIResp.ID,
+		Model:      openAIResp.Model,
+		Role:       "assistant",
+		StopReason: stopReason(choice.FinishReason),
+	}
+	if choice.Message.Content != "" {
+		response.Content = append(response.Content, anthropic.NewTextBlock(choice.Message.Content))
+	}
+	for _, call := range choice.Message.ToolCalls {
+		response.Content = append(response.Content, anthropic.NewToolUseBlock(call.ID, call.Function.Name, json.RawMessage(call.Function.Arguments)))
+	}
+	if openAIResp.Usage != nil {
+		response.Usage = Usage{
+			InputTokens:  openAIResp.Usage.PromptTokens,
+			OutputTokens: openAIResp.Usage.CompletionTokens,
+		}
+	}
+
+	return response, nil
+}
+
+func (op *OpenAIProvider) Stream(ctx context.Context, req Request) (<-chan StreamEvent, error) {
+	resp, err := op.send(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan StreamEvent)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+
+		emit := func(event StreamEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if err := readOpenAIStream(resp.Body, op.model(req), emit); err != nil {
+			emit(StreamEvent{Type: anthropic.EventError, Err: err})
+		}
+	}()
+
+	return events, nil
+}
+
+// CountTokens estimates the token count, as chat completion APIs have no
+// endpoint for counting tokens
+func (op *OpenAIProvider) CountTokens(ctx context.Context, req Request) (int, error) {
+	return EstimateTokens(req), nil
+}
+
+func (op *OpenAIProvider) model(req Request) string {
+	if req.Model != "" {
+		return req.Model
+	}
+	return op.Model
+}
+
+func (op *OpenAIProvider) send(ctx context.Context, req Request, stream bool) (*http.Response, error) {
+	model := op.model(req)
+
+	messages := toOpenAIMessages(req.Messages)
+	if system := systemText(req.System); system != "" {
+		messages = append([]openAIMessage{{Role: "system", Content: system}}, messages...)
+	}
+
+	requestBody := openAIRequest{
+		Model:       model,
+		Messages:    messages,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stop:        req.StopSequences,
+		Stream:      stream,
+	}
+	if req.Metadata != nil {
+		requestBody.User = req.Metadata.UserID
+	}
+	if stream {
+		requestBody.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+	for _, tool := range req.Tools {
+		requestBody.Tools = append(requestBody.Tools, openAITool{
+			Type: "function",
+			Function: openAIToolFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+	if req.ToolChoice != nil {
+		switch req.ToolChoice.Type {
+		case "any":
+			requestBody.ToolChoice = "required"
+		case "tool":
+			requestBody.ToolChoice = map[string]interface{}{
+				"type":     "function",
+				"function": map[string]string{"name": req.ToolChoice.Name},
+			}
+		default:
+			requestBody.ToolChoice = req.ToolChoice.Type
+		}
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling request: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", op.BaseURL+"/chat/completions", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	httpReq.Header.Set("content-type", "application/json")
+	if op.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+op.APIKey)
+	}
+
+	client := op.HTTPClient
+	if client == nil {
+		client = &http.Client{}
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, anthropic.NewAPIError(resp, body)
+	}
+
+	return resp, nil
+}
+
+// systemText joins the text of system prompt blocks
+func systemText(blocks []ContentBlock) string {
+	return anthropic.Message{Content: blocks}.Text()
+}
+
+// toOpenAIMessages converts messages made of content blocks to chat
+// completion messages. Tool results become separate "tool" role messages.
+func toOpenAIMessages(messages []Message) []openAIMessage {
+	var result []openAIMessage
+	for _, message := range messages {
+		var parts []openAIContentPart
+		var toolCalls []openAIToolCall
+		hasImage := false
+
+		for _, block := range message.Content {
+			switch block.Type {
+			case anthropic.BlockText:
+				parts = append(parts, openAIContentPart{Type: "text", Text: block.Text})
+			case anthropic.BlockImage:
+				hasImage = true
+				parts = append(parts, openAIContentPart{
+					Type: "image_url",
+					ImageURL: &openAIImageURL{
+						URL: fmt.Sprintf("data:%s;base64,%s", block.Source.MediaType, block.Source.Data),
+					},
+				})
+			case anthropic.BlockToolUse:
+				call := openAIToolCall{ID: block.ID, Type: "function"}
+				call.Function.Name = block.Name
+				call.Function.Arguments = string(block.Input)
+				toolCalls = append(toolCalls, call)
+			case anthropic.BlockToolResult:
+				result = append(result, openAIMessage{
+					Role:       "tool",
+					Content:    block.Content,
+					ToolCallID: block.ToolUseID,
+				})
+			}
+		}
+
+		if len(parts) == 0 && len(toolCalls) == 0 {
+			continue
+		}
+
+		converted := openAIMessage{Role: message.Role, ToolCalls: toolCalls}
+		if hasImage {
+			converted.Content = parts
+		} else if len(parts) > 0 {
+			var text strings.Builder
+			for _, part := range parts {
+				text.WriteString(part.Text)
+			}
+			converted.Content = text.String()
+		}
+		result = append(result, converted)
+	}
+	return result
+}
+
+// readOpenAIStream decodes a chat completion stream and re-emits it as the
+// Anthropic event sequence: message_start, text deltas, one
+// content_block_stop per completed block, message_delta and message_stop.
+func readOpenAIStream(r io.Reader, model string, emit func(StreamEvent) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	if !emit(StreamEvent{Type: anthropic.EventMessageStart, Model: model}) {
+		return nil
+	}
+
+	var text strings.Builder
+	var calls []*openAIToolCall
+	var finishReason string
+	var usage *Usage
+	done := false
+
+	for !done && scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+
+		var chunk openAIResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("error unmarshalling stream chunk: %v", err)
+		}
+
+		if chunk.Usage != nil {
+			usage = &Usage{
+				InputTokens:  chunk.Usage.PromptTokens,
+				OutputTokens: chunk.Usage.CompletionTokens,
+			}
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		choice := chunk.Choices[0]
+		if choice.FinishReason != "" {
+			finishReason = choice.FinishReason
+		}
+		if choice.Delta.Content != "" {
+			text.WriteString(choice.Delta.Content)
+			if !emit(StreamEvent{Type: anthropic.EventContentBlockDelta, Index: 0, Text: choice.Delta.Content}) {
+				return nil
+			}
+		}
+		for _, delta := range choice.Delta.ToolCalls {
+			for len(calls) <= delta.Index {
+				calls = append(calls, &openAIToolCall{})
+			}
+			call := calls[delta.Index]
+			if delta.ID != "" {
+				call.ID = delta.ID
+			}
+			if delta.Function.Name != "" {
+				call.Function.Name = delta.Function.Name
+			}
+			call.Function.Arguments += delta.Function.Arguments
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading stream: %v", err)
+	}
+	if !done && finishReason == "" {
+		return fmt.Errorf("stream ended before completion")
+	}
+
+	var blocks []ContentBlock
+	if text.Len() > 0 {
+		blocks = append(blocks, anthropic.NewTextBlock(text.String()))
+	}
+	for _, call := range calls {
+		blocks = append(blocks, anthropic.NewToolUseBlock(call.ID, call.Function.Name, json.RawMessage(call.Function.Arguments)))
+	}
+	for i := range blocks {
+		if !emit(StreamEvent{Type: anthropic.EventContentBlockStop, Index: i, Block: &blocks[i]}) {
+			return nil
+		}
+	}
+
+	if !emit(StreamEvent{Type: anthropic.EventMessageDelta, StopReason: stopReason(finishReason), Usage: usage}) {
+		return nil
+	}
+	emit(StreamEvent{Type: anthropic.EventMessageStop})
+	return nil
+}
+
+// stopReason maps a chat completion finish reason to an Anthropic stop reason
+func stopReason(finishReason string) string {
+	switch finishReason {
+	case "stop":
+		return "end_turn"
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	default:
+		return finishReason
+	}
+}
@@ -0,0 +1,273 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	anthropic "github.com/openagentsinc/autodev/llm"
+)
+
+// openAIServer answers every request with status and body, and records the
+// last request
+type openAIServer struct {
+	*httptest.Server
+	path   string
+	header http.Header
+	body   map[string]interface{}
+}
+
+func newOpenAIServer(t *testing.T, status int, body string) *openAIServer {
+	t.Helper()
+	s := &openAIServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.path = r.URL.Path
+		s.header = r.Header.Clone()
+		data, _ := io.ReadAll(r.Body)
+		s.body = nil
+		if err := json.Unmarshal(data, &s.body); err != nil {
+			t.Errorf("request body is not JSON: %s", data)
+		}
+		if strings.HasPrefix(body, "data:") {
+			w.Header().Set("Content-Type", "text/event-stream")
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// toolRequest asks for a tool call after an earlier one was answered
+func toolRequest() Request {
+	messages := []Message{
+		anthropic.NewTextMessage("user", "What is in the workspace?"),
+		{Role: "assistant", Content: []ContentBlock{
+			anthropic.NewTextBlock("Let me look."),
+			anthropic.NewToolUseBlock("call_1", "run", json.RawMessage(`{"command":"ls"}`)),
+		}},
+		{Role: "user", Content: []ContentBlock{anthropic.NewToolResultBlock("call_1", "main.go", false)}},
+	}
+	return NewRequest(messages, 100,
+		WithSystem("Be brief."),
+		WithStopSequences("</finish>"),
+		WithTools(Tool{Name: "run", Description: "Run a command", InputSchema: map[string]interface{}{"type": "object"}}),
+	)
+}
+
+func TestOpenAIChat(t *testing.T) {
+	server := newOpenAIServer(t, http.StatusOK, `{
+		"id": "chatcmpl-1",
+		"model": "llama3",
+		"choices": [{
+			"message": {
+				"content": "Reading it.",
+				"tool_calls": [{"id": "call_2", "type": "function", "function": {"name": "read", "arguments": "{\"path\":\"main.go\"}"}}]
+			},
+			"finish_reason": "tool_calls"
+		}],
+		"usage": {"prompt_tokens": 12, "completion_tokens": 5}
+	}`)
+	provider := NewOpenAIProvider("key", server.URL+"/", "llama3")
+
+	response, err := provider.Chat(context.Background(), toolRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if server.path != "/chat/completions" || server.header.Get("Authorization") != "Bearer key" {
+		t.Errorf("request to %s with authorization %q", server.path, server.header.Get("Authorization"))
+	}
+	want := map[string]interface{}{
+		"model":      "llama3",
+		"max_tokens": 100.0,
+		"stop":       []interface{}{"</finish>"},
+		"messages": []interface{}{
+			map[string]interface{}{"role": "system", "content": "Be brief."},
+			map[string]interface{}{"role": "user", "content": "What is in the workspace?"},
+			map[string]interface{}{"role": "assistant", "content": "Let me look.", "tool_calls": []interface{}{
+				map[string]interface{}{"index": 0.0, "id": "call_1", "type": "function",
+					"function": map[string]interface{}{"name": "run", "arguments": `{"command":"ls"}`}},
+			}},
+			map[string]interface{}{"role": "tool", "content": "main.go", "tool_call_id": "call_1"},
+		},
+		"tools": []interface{}{
+			map[string]interface{}{"type": "function", "function": map[string]interface{}{
+				"name": "run", "description": "Run a command", "parameters": map[string]interface{}{"type": "object"},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(server.body, want) {
+		got, _ := json.MarshalIndent(server.body, "", "  ")
+		t.Errorf("request body = %s", got)
+	}
+
+	wantContent := []ContentBlock{
+		anthropic.NewTextBlock("Reading it."),
+		anthropic.NewToolUseBlock("call_2", "read", json.RawMessage(`{"path":"main.go"}`)),
+	}
+	if !reflect.DeepEqual(response.Content, wantContent) {
+		t.Errorf("content = %+v, want %+v", response.Content, wantContent)
+	}
+	if response.ID != "chatcmpl-1" || response.Role != "assistant" || response.StopReason != "tool_use" {
+		t.Errorf("response = %q %q %q, want the id, assistant and tool_use", response.ID, response.Role, response.StopReason)
+	}
+	if response.Usage.InputTokens != 12 || response.Usage.OutputTokens != 5 {
+		t.Errorf("usage = %+v, want 12 input and 5 output tokens", response.Usage)
+	}
+}
+
+func TestOpenAIChatDefaults(t *testing.T) {
+	server := newOpenAIServer(t, http.StatusOK, `{"choices": [{"message": {"content": "Hi"}, "finish_reason": "length"}]}`)
+	provider := NewOpenAIProvider("", server.URL, "")
+
+	req := NewRequest([]Message{anthropic.NewTextMessage("user", "Hello")}, 0)
+	req.ToolChoice = &ToolChoice{Type: "tool", Name: "run"}
+	response, err := provider.Chat(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if auth := server.header.Get("Authorization"); auth != "" {
+		t.Errorf("authorization = %q without an API key", auth)
+	}
+	if server.body["model"] != DefaultOpenAIModel {
+		t.Errorf("model = %v, want %s", server.body["model"], DefaultOpenAIModel)
+	}
+	wantChoice := map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "run"}}
+	if !reflect.DeepEqual(server.body["tool_choice"], wantChoice) {
+		t.Errorf("tool_choice = %v, want %v", server.body["tool_choice"], wantChoice)
+	}
+	if response.Text() != "Hi" || response.StopReason != "max_tokens" {
+		t.Errorf("response = %q, %q", response.Text(), response.StopReason)
+	}
+}
+
+// collect reads a stream to the end
+func collect(t *testing.T, events <-chan StreamEvent) []StreamEvent {
+	t.Helper()
+	var collected []StreamEvent
+	for event := range events {
+		collected = append(collected, event)
+	}
+	return collected
+}
+
+func TestOpenAIStream(t *testing.T) {
+	chunks := []string{
+		`{"choices": [{"delta": {"content": "Read"}}]}`,
+		`{"choices": [{"delta": {"content": "ing."}}]}`,
+		`{"choices": [{"delta": {"tool_calls": [{"index": 0, "id": "call_1", "type": "function", "function": {"name": "read", "arguments": ""}}]}}]}`,
+		`{"choices": [{"delta": {"tool_calls": [{"index": 0, "function": {"arguments": "{\"path\":"}}]}}]}`,
+		`{"choices": [{"delta": {"tool_calls": [{"index": 0, "function": {"arguments": "\"main.go\"}"}}]}}]}`,
+		`{"choices": [{"delta": {}, "finish_reason": "tool_calls"}]}`,
+		`{"choices": [], "usage": {"prompt_tokens": 12, "completion_tokens": 5}}`,
+		`[DONE]`,
+	}
+	server := newOpenAIServer(t, http.StatusOK, "data: "+strings.Join(chunks, "\n\ndata: ")+"\n\n")
+	provider := NewOpenAIProvider("key", server.URL, "llama3")
+
+	events, err := provider.Stream(context.Background(), toolRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := collect(t, events)
+
+	if server.body["stream"] != true || !reflect.DeepEqual(server.body["stream_options"], map[string]interface{}{"include_usage": true}) {
+		t.Errorf("request does not ask for a stream with usage: %v, %v", server.body["stream"], server.body["stream_options"])
+	}
+
+	var types []string
+	var text strings.Builder
+	for _, event := range got {
+		types = append(types, event.Type)
+		text.WriteString(event.Text)
+	}
+	wantTypes := []string{
+		anthropic.EventMessageStart,
+		anthropic.EventContentBlockDelta, anthropic.EventContentBlockDelta,
+		anthropic.EventContentBlockStop, anthropic.EventContentBlockStop,
+		anthropic.EventMessageDelta, anthropic.EventMessageStop,
+	}
+	if !reflect.DeepEqual(types, wantTypes) {
+		t.Fatalf("events = %v, want %v", types, wantTypes)
+	}
+	if got[0].Model != "llama3" || text.String() != "Reading." {
+		t.Errorf("model %q and text %q", got[0].Model, text.String())
+	}
+	if block := got[3].Block; block == nil || block.Type != anthropic.BlockText || block.Text != "Reading." {
+		t.Errorf("first block = %+v, want the text", got[3].Block)
+	}
+	wantCall := anthropic.NewToolUseBlock("call_1", "read", json.RawMessage(`{"path":"main.go"}`))
+	if block := got[4].Block; block == nil || !reflect.DeepEqual(*block, wantCall) || got[4].Index != 1 {
+		t.Errorf("second block = %d %+v, want %+v", got[4].Index, got[4].Block, wantCall)
+	}
+	delta := got[5]
+	if delta.StopReason != "tool_use" || delta.Usage == nil || delta.Usage.InputTokens != 12 || delta.Usage.OutputTokens != 5 {
+		t.Errorf("message delta = %q %+v, want tool_use with the usage", delta.StopReason, delta.Usage)
+	}
+}
+
+func TestOpenAIStreamErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		err  string
+	}{
+		{name: "cut off", body: "data: {\"choices\": [{\"delta\": {\"content\": \"Hi\"}}]}\n\n", err: "stream ended before completion"},
+		{name: "invalid chunk", body: "data: {\"choices\": \n\n", err: "error unmarshalling stream chunk"},
+	}
+	for _, tt := range tests {
+		server := newOpenAIServer(t, http.StatusOK, tt.body)
+		events, err := NewOpenAIProvider("", server.URL, "").Stream(context.Background(), toolRequest())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := collect(t, events)
+		last := got[len(got)-1]
+		if last.Type != anthropic.EventError || last.Err == nil || !strings.Contains(last.Err.Error(), tt.err) {
+			t.Errorf("%s: last event = %s %v, want an error %q", tt.name, last.Type, last.Err, tt.err)
+		}
+	}
+}
+
+func TestOpenAIErrorStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		kind   error
+		text   string
+	}{
+		{status: http.StatusUnauthorized, body: `{"error": {"message": "Incorrect API key", "type": "invalid_request_error"}}`, kind: anthropic.ErrInvalidRequest, text: "Incorrect API key"},
+		{status: http.StatusTooManyRequests, body: `{"error": {"message": "Slow down"}}`, kind: anthropic.ErrRateLimited, text: "Slow down"},
+		{status: http.StatusInternalServerError, body: "upstream failed", kind: anthropic.ErrServer, text: "upstream failed"},
+		{status: http.StatusBadRequest, body: `{"error": {"message": "bad model"}}`, kind: anthropic.ErrInvalidRequest, text: "status code 400"},
+	}
+	for _, tt := range tests {
+		server := newOpenAIServer(t, tt.status, tt.body)
+		provider := NewOpenAIProvider("key", server.URL, "")
+
+		_, chatErr := provider.Chat(context.Background(), toolRequest())
+		_, streamErr := provider.Stream(context.Background(), toolRequest())
+		for _, err := range []error{chatErr, streamErr} {
+			var apiErr *anthropic.APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status || !errors.Is(err, tt.kind) || !strings.Contains(err.Error(), tt.text) {
+				t.Errorf("status %d: error = %v, want %v mentioning %q", tt.status, err, tt.kind, tt.text)
+			}
+		}
+	}
+}
+
+func TestOpenAIChatNoChoices(t *testing.T) {
+	server := newOpenAIServer(t, http.StatusOK, `{"choices": []}`)
+	if _, err := NewOpenAIProvider("", server.URL, "").Chat(context.Background(), toolRequest()); err == nil || !strings.Contains(err.Error(), "no choices") {
+		t.Errorf("Chat() error = %v, want no choices", err)
+	}
+}
@@ -0,0 +1,72 @@
+package llm
+
+import (
+	"context"
+
+	anthropic "github.com/openagentsinc/autodev/llm"
+)
+
+// The request, response and content types are shared with the Anthropic
+// client, whose message format every provider converts to and from.
+type (
+	Message      = anthropic.Message
+	ContentBlock = anthropic.ContentBlock
+	Tool         = anthropic.Tool
+	ToolChoice   = anthropic.ToolChoice
+	Request      = anthropic.AnthropicRequest
+	Response     = anthropic.AnthropicResponse
+	StreamEvent  = anthropic.StreamEvent
+	Usage        = anthropic.Usage
+	Option       = anthropic.Option
+)
+
+// Request options, see the Anthropic client for details
+var (
+	NewRequest        = anthropic.NewRequest
+	WithModel         = anthropic.WithModel
+	WithMaxTokens     = anthropic.WithMaxTokens
+	WithSystem        = anthropic.WithSystem
+	WithSystemBlocks  = anthropic.WithSystemBlocks
+	WithTemperature   = anthropic.WithTemperature
+	WithTopP          = anthropic.WithTopP
+	WithStopSequences = anthropic.WithStopSequences
+	WithMetadata      = anthropic.WithMetadata
+	WithTools         = anthropic.WithTools
+	WithPromptCaching = anthropic.WithPromptCaching
+)
+
+// Provider is a chat model backend
+type Provider interface {
+	// Chat sends a request and waits for the complete response
+	Chat(ctx context.Context, req Request) (*Response, error)
+
+	// Stream sends a request and returns a channel of stream events,
+	// using the same event sequence as the Anthropic streaming API
+	Stream(ctx context.Context, req Request) (<-chan StreamEvent, error)
+
+	// CountTokens returns the number of input tokens the request would use
+	CountTokens(ctx context.Context, req Request) (int, error)
+}
+
+// EstimateTokens approximates the number of input tokens in a request for
+// backends that cannot count them, at roughly four characters per token.
+func EstimateTokens(req Request) int {
+	chars := 0
+	for _, block := range req.System {
+		chars += len(block.Text)
+	}
+	for _, message := range req.Messages {
+		chars += len(message.Role)
+		for _, block := range message.Content {
+			chars += len(block.Text) + len(block.Name) + len(block.Input) + len(block.Content)
+			if block.Source != nil {
+				// Images are billed by size, not by their encoded length.
+				chars += 1600 * 4
+			}
+		}
+	}
+	for _, tool := range req.Tools {
+		chars += len(tool.Name) + len(tool.Description) + 200
+	}
+	return (chars + 3) / 4
+}
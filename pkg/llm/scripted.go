@@ -0,0 +1,125 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	anthropic "github.com/openagentsinc/autodev/llm"
+)
+
+// ScriptedProvider is a deterministic Provider for tests. It answers each
+// request with the next response in its script and records the requests it
+// received.
+type ScriptedProvider struct {
+	mu        sync.Mutex
+	responses []*Response
+	requests  []Request
+}
+
+// NewScriptedProvider creates a ScriptedProvider that replies with the given
+// responses in order
+func NewScriptedProvider(responses ...*Response) *ScriptedProvider {
+	return &ScriptedProvider{responses: responses}
+}
+
+// TextResponse creates an end_turn response containing a single text block
+func TextResponse(text string) *Response {
+	return &Response{
+		Role:       "assistant",
+		Content:    []ContentBlock{anthropic.NewTextBlock(text)},
+		StopReason: "end_turn",
+	}
+}
+
+// Requests returns the requests received so far
+func (sp *ScriptedProvider) Requests() []Request {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return append([]Request(nil), sp.requests...)
+}
+
+// Remaining returns the number of responses not yet used
+func (sp *ScriptedProvider) Remaining() int {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return len(sp.responses)
+}
+
+func (sp *ScriptedProvider) next(req Request) (*Response, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	sp.requests = append(sp.requests, req)
+	if len(sp.responses) == 0 {
+		return nil, fmt.Errorf("scripted provider has no responses left (request %d)", len(sp.requests))
+	}
+
+	response := sp.responses[0]
+	sp.responses = sp.responses[1:]
+	return response, nil
+}
+
+func (sp *ScriptedProvider) Chat(ctx context.Context, req Request) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return sp.next(req)
+}
+
+// Stream replays the next response as a stream, sending text blocks one word
+// at a time
+func (sp *ScriptedProvider) Stream(ctx context.Context, req Request) (<-chan StreamEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	response, err := sp.next(req)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan StreamEvent)
+	go func() {
+		defer close(events)
+
+		emit := func(event StreamEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		usage := response.Usage
+		startUsage := usage
+		startUsage.OutputTokens = 0
+		if !emit(StreamEvent{Type: anthropic.EventMessageStart, Model: response.Model, Usage: &startUsage}) {
+			return
+		}
+		for i := range response.Content {
+			block := response.Content[i]
+			if block.Type == anthropic.BlockText {
+				for _, word := range strings.SplitAfter(block.Text, " ") {
+					if !emit(StreamEvent{Type: anthropic.EventContentBlockDelta, Index: i, Text: word}) {
+						return
+					}
+				}
+			}
+			if !emit(StreamEvent{Type: anthropic.EventContentBlockStop, Index: i, Block: &block}) {
+				return
+			}
+		}
+		if !emit(StreamEvent{Type: anthropic.EventMessageDelta, StopReason: response.StopReason, Usage: &Usage{OutputTokens: usage.OutputTokens}}) {
+			return
+		}
+		emit(StreamEvent{Type: anthropic.EventMessageStop})
+	}()
+
+	return events, nil
+}
+
+func (sp *ScriptedProvider) CountTokens(ctx context.Context, req Request) (int, error) {
+	return EstimateTokens(req), nil
+}
@@ -0,0 +1,114 @@
+package llm
+
+import (
+	"context"
+
+	anthropic "github.com/openagentsinc/autodev/llm"
+	"github.com/openagentsinc/autodev/pkg/usage"
+)
+
+// TrackedProvider wraps a Provider and records the usage of every call
+type TrackedProvider struct {
+	Provider
+	Tracker *usage.Tracker
+}
+
+// NewTrackedProvider creates a TrackedProvider recording into tracker
+func NewTrackedProvider(p Provider, tracker *usage.Tracker) *TrackedProvider {
+	return &TrackedProvider{Provider: p, Tracker: tracker}
+}
+
+func (tp *TrackedProvider) Chat(ctx context.Context, req Request) (*Response, error) {
+	response, err := tp.Provider.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	model := response.Model
+	if model == "" {
+		model = req.Model
+	}
+	tp.Tracker.Record(ctx, model, response.Usage)
+	return response, nil
+}
+
+// Stream passes events through unchanged and records the usage once the
+// message is complete. A stream that fails or is cancelled after usage was
+// reported still records the tokens it used.
+func (tp *TrackedProvider) Stream(ctx context.Context, req Request) (<-chan StreamEvent, error) {
+	events, err := tp.Provider.Stream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := make(chan StreamEvent)
+	go func() {
+		defer close(tracked)
+
+		model := req.Model
+		var total Usage
+		seen, recorded := false, false
+		record := func() {
+			if seen && !recorded {
+				recorded = true
+				tp.Tracker.Record(ctx, model, total)
+			}
+		}
+		defer record()
+
+		observe := func(event StreamEvent) {
+			switch event.Type {
+			case anthropic.EventMessageStart:
+				if event.Model != "" {
+					model = event.Model
+				}
+				if event.Usage != nil {
+					total = *event.Usage
+					seen = true
+				}
+			case anthropic.EventMessageDelta:
+				if event.Usage != nil {
+					total = mergeUsage(total, *event.Usage)
+					seen = true
+				}
+			case anthropic.EventMessageStop:
+				record()
+			}
+		}
+
+		for event := range events {
+			observe(event)
+
+			select {
+			case tracked <- event:
+			case <-ctx.Done():
+				// Drain so the wrapped provider can finish, counting any
+				// usage it still reports.
+				for event := range events {
+					observe(event)
+				}
+				return
+			}
+		}
+	}()
+
+	return tracked, nil
+}
+
+// mergeUsage combines the usage sent with message_start with the usage sent
+// with message_delta. Output tokens in the delta are cumulative, and any
+// other counts it carries supersede those sent at the start.
+func mergeUsage(start, delta Usage) Usage {
+	merged := start
+	merged.OutputTokens = delta.OutputTokens
+	if delta.InputTokens > 0 {
+		merged.InputTokens = delta.InputTokens
+	}
+	if delta.CacheCreationInputTokens > 0 {
+		merged.CacheCreationInputTokens = delta.CacheCreationInputTokens
+	}
+	if delta.CacheReadInputTokens > 0 {
+		merged.CacheReadInputTokens = delta.CacheReadInputTokens
+	}
+	return merged
+}
@@ -0,0 +1,144 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	anthropic "github.com/openagentsinc/autodev/llm"
+	"github.com/openagentsinc/autodev/pkg/usage"
+)
+
+var trackedPrices = usage.PriceTable{"test": {Input: 1, Output: 2}}
+
+// channelProvider streams whatever events are sent on its channel
+type channelProvider struct {
+	Provider
+	events chan StreamEvent
+}
+
+func (cp *channelProvider) Stream(ctx context.Context, req Request) (<-chan StreamEvent, error) {
+	return cp.events, nil
+}
+
+func TestTrackedChat(t *testing.T) {
+	response := TextResponse("done")
+	response.Usage = Usage{InputTokens: 10, OutputTokens: 5}
+	tracker := usage.NewTracker(trackedPrices)
+	provider := NewTrackedProvider(NewScriptedProvider(response), tracker)
+
+	ctx := usage.WithTask(usage.WithConversation(context.Background(), "conversation"), "0.1")
+	if _, err := provider.Chat(ctx, Request{Model: "test-model"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The response has no model, so the call is priced by the requested one
+	want := usage.Totals{Requests: 1, InputTokens: 10, OutputTokens: 5, Cost: 20e-6}
+	report := tracker.Report()
+	if report.Session != want || report.Models["test-model"] != want ||
+		report.Conversations["conversation"] != want || report.Tasks["0.1"] != want {
+		t.Errorf("Report() = %+v, want every bucket to be %+v", report, want)
+	}
+
+	if _, err := provider.Chat(ctx, Request{Model: "test-model"}); err == nil {
+		t.Fatal("want an error once the script is exhausted")
+	}
+	if got := tracker.Session(); got != want {
+		t.Errorf("Session() = %+v after a failed call, want %+v", got, want)
+	}
+}
+
+func TestTrackedStream(t *testing.T) {
+	response := TextResponse("one two three")
+	response.Model = "test-model"
+	response.Usage = Usage{InputTokens: 10, OutputTokens: 5, CacheReadInputTokens: 3}
+	tracker := usage.NewTracker(trackedPrices)
+	provider := NewTrackedProvider(NewScriptedProvider(response), tracker)
+
+	events, err := provider.Stream(context.Background(), Request{Model: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(t, events); len(got) != 7 {
+		t.Errorf("Stream() sent %d events, want all 7 passed through", len(got))
+	}
+
+	// The usage of message_start and message_delta is recorded once
+	want := usage.Totals{Requests: 1, InputTokens: 10, OutputTokens: 5, CacheReadInputTokens: 3, Cost: 20e-6}
+	if got := tracker.Report().Models["test-model"]; got != want {
+		t.Errorf("Models[test-model] = %+v, want %+v", got, want)
+	}
+}
+
+func TestTrackedStreamEndsEarly(t *testing.T) {
+	start := StreamEvent{Type: anthropic.EventMessageStart, Model: "test-model", Usage: &Usage{InputTokens: 10}}
+	tests := []struct {
+		name   string
+		events []StreamEvent
+		want   usage.Totals
+	}{
+		{
+			name:   "error after message_start",
+			events: []StreamEvent{start, {Type: anthropic.EventError, Err: errors.New("overloaded")}},
+			want:   usage.Totals{Requests: 1, InputTokens: 10, Cost: 10e-6},
+		},
+		{
+			name: "error after message_delta",
+			events: []StreamEvent{
+				start,
+				{Type: anthropic.EventMessageDelta, Usage: &Usage{OutputTokens: 4}},
+				{Type: anthropic.EventError, Err: errors.New("connection reset")},
+			},
+			want: usage.Totals{Requests: 1, InputTokens: 10, OutputTokens: 4, Cost: 18e-6},
+		},
+		{
+			name:   "error before any usage",
+			events: []StreamEvent{{Type: anthropic.EventError, Err: errors.New("overloaded")}},
+		},
+	}
+	for _, tt := range tests {
+		tracker := usage.NewTracker(trackedPrices)
+		upstream := make(chan StreamEvent, len(tt.events))
+		for _, event := range tt.events {
+			upstream <- event
+		}
+		close(upstream)
+
+		provider := NewTrackedProvider(&channelProvider{events: upstream}, tracker)
+		events, err := provider.Stream(context.Background(), Request{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		collect(t, events)
+		if got := tracker.Session(); got != tt.want {
+			t.Errorf("%s: Session() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTrackedStreamCancelled(t *testing.T) {
+	tracker := usage.NewTracker(trackedPrices)
+	upstream := make(chan StreamEvent)
+	provider := NewTrackedProvider(&channelProvider{events: upstream}, tracker)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := provider.Stream(ctx, Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream <- StreamEvent{Type: anthropic.EventMessageStart, Model: "test-model", Usage: &Usage{InputTokens: 10}}
+	<-events
+
+	// The consumer stops reading, and the usage reported while draining
+	// is counted too
+	cancel()
+	upstream <- StreamEvent{Type: anthropic.EventMessageDelta, Usage: &Usage{OutputTokens: 2}}
+	close(upstream)
+	for range events {
+	}
+
+	want := usage.Totals{Requests: 1, InputTokens: 10, OutputTokens: 2, Cost: 14e-6}
+	if got := tracker.Session(); got != want {
+		t.Errorf("Session() = %+v, want %+v", got, want)
+	}
+}
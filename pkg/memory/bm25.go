@@ -0,0 +1,118 @@
+package memory
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// bm25 is an in-memory BM25 index, the local fallback when no embedder is
+// configured
+type bm25 struct {
+	docs     []map[string]int // term frequencies of each document
+	lengths  []int
+	total    int            // sum of lengths
+	docFreqs map[string]int // number of documents containing each term
+}
+
+func newBM25() *bm25 {
+	return &bm25{docFreqs: make(map[string]int)}
+}
+
+// add indexes a document, which gets the next document number
+func (b *bm25) add(text string) {
+	terms := tokenize(text)
+	freqs := make(map[string]int)
+	for _, term := range terms {
+		freqs[term]++
+	}
+	for term := range freqs {
+		b.docFreqs[term]++
+	}
+	b.docs = append(b.docs, freqs)
+	b.lengths = append(b.lengths, len(terms))
+	b.total += len(terms)
+}
+
+// scores returns the score of each document for the query
+func (b *bm25) scores(query string) []float64 {
+	scores := make([]float64, len(b.docs))
+	if len(b.docs) == 0 {
+		return scores
+	}
+	avgLength := float64(b.total) / float64(len(b.docs))
+	n := float64(len(b.docs))
+
+	seen := make(map[string]bool)
+	for _, term := range tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		df := float64(b.docFreqs[term])
+		if df == 0 {
+			continue
+		}
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for i, freqs := range b.docs {
+			tf := float64(freqs[term])
+			if tf == 0 {
+				continue
+			}
+			norm := 1 - bm25B + bm25B*float64(b.lengths[i])/avgLength
+			scores[i] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+	return scores
+}
+
+// tokenize splits text into lowercase terms. Identifiers are also split at
+// underscores and case changes, so "readFile" matches "read file".
+func tokenize(text string) []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		parts := splitIdentifier(word)
+		if len(parts) > 1 {
+			terms = append(terms, strings.ToLower(strings.Trim(word, "_")))
+		}
+		for _, part := range parts {
+			terms = append(terms, strings.ToLower(part))
+		}
+	}
+	return terms
+}
+
+// splitIdentifier splits a word at underscores and lower-to-upper case
+// changes
+func splitIdentifier(word string) []string {
+	var parts []string
+	var current []rune
+	runes := []rune(word)
+	for i, r := range runes {
+		switch {
+		case r == '_':
+			if len(current) > 0 {
+				parts = append(parts, string(current))
+				current = nil
+			}
+			continue
+		case i > 0 && unicode.IsUpper(r) && unicode.IsLower(runes[i-1]):
+			parts = append(parts, string(current))
+			current = nil
+		}
+		current = append(current, r)
+	}
+	if len(current) > 0 {
+		parts = append(parts, string(current))
+	}
+	return parts
+}
@@ -0,0 +1,312 @@
+// Package memory stores what an agent has done and seen, and finds the
+// entries most relevant to a query, for AgentRecallAction.
+package memory
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultSnippetChars caps the length of the snippets Search returns
+const DefaultSnippetChars = 1000
+
+// rrfK dampens the weight of top ranks when combining rankings, the usual
+// value for reciprocal rank fusion
+const rrfK = 60
+
+// Embedder turns texts into vectors whose cosine similarity reflects how
+// related the texts are
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// Entry is a remembered action or observation
+type Entry struct {
+	Text   string    `json:"text"`
+	Vector []float32 `json:"vector,omitempty"`
+}
+
+// Result is an entry found by Search, with its relevance
+type Result struct {
+	Text  string
+	Score float64
+}
+
+// Memory indexes entries for search. Entries are always ranked with BM25
+// and, when an Embedder is set, also by embedding similarity, combining the
+// two rankings. Entries are appended to a file as they are added, if a path
+// is given, and loaded from it again by New.
+type Memory struct {
+	// SnippetChars caps the length of returned snippets
+	SnippetChars int
+
+	embedder Embedder
+	path     string
+
+	mu      sync.Mutex
+	entries []Entry
+	index   *bm25
+}
+
+// New creates a memory, loading the entries previously saved at path. An
+// empty path keeps the memory in-process only, and a nil embedder uses BM25
+// alone.
+func New(path string, embedder Embedder) (*Memory, error) {
+	m := &Memory{
+		SnippetChars: DefaultSnippetChars,
+		embedder:     embedder,
+		path:         path,
+		index:        newBM25(),
+	}
+	if path == "" {
+		return m, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open memory: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid memory entry on line %d of %s: %v", line, path, err)
+		}
+		m.entries = append(m.entries, entry)
+		m.index.add(entry.Text)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read memory: %v", err)
+	}
+	return m, nil
+}
+
+// Add remembers an action or observation in the form returned by its
+// ToMemory method
+func (m *Memory) Add(ctx context.Context, item map[string]interface{}) error {
+	return m.AddText(ctx, Text(item))
+}
+
+// AddText remembers a piece of text. If embedding it fails, it is still
+// added and found by BM25 only.
+func (m *Memory) AddText(ctx context.Context, text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+
+	entry := Entry{Text: text}
+	var embedErr error
+	if m.embedder != nil {
+		var vectors [][]float32
+		vectors, embedErr = m.embedder.Embed(ctx, []string{text})
+		if embedErr == nil && len(vectors) == 1 {
+			entry.Vector = vectors[0]
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, entry)
+	m.index.add(entry.Text)
+
+	if err := m.append(entry); err != nil {
+		return err
+	}
+	if embedErr != nil {
+		return fmt.Errorf("failed to embed memory: %v", embedErr)
+	}
+	return nil
+}
+
+// append saves an entry to the end of the memory file
+func (m *Memory) append(entry Entry) error {
+	if m.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return fmt.Errorf("failed to save memory: %v", err)
+	}
+	f, err := os.OpenFile(m.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to save memory: %v", err)
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to save memory: %v", err)
+	}
+	return nil
+}
+
+// Len returns the number of entries
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// Search returns up to limit entries relevant to the query, best first. If
+// the query cannot be embedded, entries are ranked with BM25 alone.
+func (m *Memory) Search(ctx context.Context, query string, limit int) ([]Result, error) {
+	var queryVector []float32
+	if m.embedder != nil {
+		vectors, err := m.embedder.Embed(ctx, []string{query})
+		if err != nil {
+			log.Printf("memory: failed to embed query, searching with BM25 alone: %v", err)
+		} else if len(vectors) == 1 {
+			queryVector = vectors[0]
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rankings := [][]float64{m.index.scores(query)}
+	if queryVector != nil {
+		similarities := make([]float64, len(m.entries))
+		for i, entry := range m.entries {
+			similarities[i] = cosine(queryVector, entry.Vector)
+		}
+		rankings = append(rankings, similarities)
+	}
+
+	// Combine the rankings by reciprocal rank fusion, which needs no
+	// calibration between BM25 scores and similarities. Entries that no
+	// ranking matches at all are left out.
+	fused := make([]float64, len(m.entries))
+	for _, scores := range rankings {
+		order := make([]int, 0, len(scores))
+		for i, score := range scores {
+			if score > 0 {
+				order = append(order, i)
+			}
+		}
+		sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+		for rank, i := range order {
+			fused[i] += 1 / float64(rrfK+rank+1)
+		}
+	}
+
+	order := make([]int, 0, len(fused))
+	for i, score := range fused {
+		if score > 0 {
+			order = append(order, i)
+		}
+	}
+	// Prefer recent entries among equals.
+	sort.SliceStable(order, func(a, b int) bool {
+		if fused[order[a]] != fused[order[b]] {
+			return fused[order[a]] > fused[order[b]]
+		}
+		return order[a] > order[b]
+	})
+	if limit > 0 && len(order) > limit {
+		order = order[:limit]
+	}
+
+	results := make([]Result, len(order))
+	for i, index := range order {
+		results[i] = Result{Text: m.snippet(m.entries[index].Text), Score: fused[index]}
+	}
+	return results, nil
+}
+
+// snippet truncates long entries
+func (m *Memory) snippet(text string) string {
+	if m.SnippetChars <= 0 || len(text) <= m.SnippetChars {
+		return text
+	}
+	cut := m.SnippetChars
+	for cut > 0 && !utf8Start(text[cut]) {
+		cut--
+	}
+	return text[:cut] + "..."
+}
+
+func utf8Start(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// Text renders an action or observation in its ToMemory form as text to
+// index: its type, then its fields in key order
+func Text(item map[string]interface{}) string {
+	var b strings.Builder
+	for _, kind := range []string{"action", "observation"} {
+		if value, ok := item[kind]; ok {
+			fmt.Fprintf(&b, "%s: %v\n", kind, value)
+		}
+	}
+
+	fields := make(map[string]interface{})
+	for key, value := range item {
+		if key != "action" && key != "observation" {
+			fields[key] = value
+		}
+	}
+	if args, ok := fields["args"].(map[string]interface{}); ok {
+		delete(fields, "args")
+		for key, value := range args {
+			fields[key] = value
+		}
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch value := fields[key].(type) {
+		case nil:
+		case string:
+			if value != "" {
+				fmt.Fprintf(&b, "%s: %s\n", key, value)
+			}
+		default:
+			data, err := json.Marshal(value)
+			if err == nil && string(data) != "{}" && string(data) != "[]" {
+				fmt.Fprintf(&b, "%s: %s\n", key, data)
+			}
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,212 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeEmbedder embeds the texts in vectors, and every other text as a zero
+// vector, which is similar to nothing. It fails while err is set.
+type fakeEmbedder struct {
+	vectors map[string][]float32
+	err     error
+}
+
+func (fe *fakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if fe.err != nil {
+		return nil, fe.err
+	}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = fe.vectors[text]
+		if vectors[i] == nil {
+			vectors[i] = []float32{0, 0}
+		}
+	}
+	return vectors, nil
+}
+
+func searchTexts(t *testing.T, m *Memory, query string, limit int) []string {
+	t.Helper()
+	results, err := m.Search(context.Background(), query, limit)
+	if err != nil {
+		t.Fatalf("Search(%q) error = %v", query, err)
+	}
+	texts := []string{}
+	for _, result := range results {
+		texts = append(texts, result.Text)
+	}
+	return texts
+}
+
+func addTexts(t *testing.T, m *Memory, texts ...string) {
+	t.Helper()
+	for _, text := range texts {
+		if err := m.AddText(context.Background(), text); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSearchBM25(t *testing.T) {
+	m, err := New("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTexts(t, m,
+		"postgres listens on port 5433",
+		"the web server listens on port 8080",
+		"func readFile(path string) error",
+		"nothing relevant here",
+		"postgres, postgres and more postgres",
+	)
+
+	tests := []struct {
+		query string
+		limit int
+		want  []string
+	}{
+		{query: "postgres port", limit: 2, want: []string{"postgres listens on port 5433", "postgres, postgres and more postgres"}},
+		// Rarer terms weigh more
+		{query: "server port", limit: 1, want: []string{"the web server listens on port 8080"}},
+		// Identifiers match their parts
+		{query: "read file", limit: 0, want: []string{"func readFile(path string) error"}},
+		{query: "readfile", limit: 0, want: []string{"func readFile(path string) error"}},
+		{query: "kubernetes", limit: 0, want: []string{}},
+	}
+	for _, tt := range tests {
+		if got := searchTexts(t, m, tt.query, tt.limit); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Search(%q, %d) = %q, want %q", tt.query, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestSearchFusion(t *testing.T) {
+	embedder := &fakeEmbedder{vectors: map[string][]float32{
+		"database settings":             {1, 0},
+		"postgres listens on port 5433": {0.9, 0.1},
+		"DATABASE_URL is unset":         {0.6, 0.4},
+		"the web server starts":         {0, 1},
+	}}
+	m, err := New("", embedder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTexts(t, m,
+		"the web server starts",
+		"postgres listens on port 5433",
+		"DATABASE_URL is unset",
+		"database settings are in config.yaml",
+	)
+
+	// The entry both rankings match comes first, then the best match of
+	// each ranking alone, the more recent first as they tie, while entries
+	// neither matches are left out
+	want := []string{"DATABASE_URL is unset", "database settings are in config.yaml", "postgres listens on port 5433"}
+	if got := searchTexts(t, m, "database settings", 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("Search() = %q, want %q", got, want)
+	}
+}
+
+func TestSearchEmbedderFailure(t *testing.T) {
+	embedder := &fakeEmbedder{vectors: map[string][]float32{
+		"database":                      {1, 0},
+		"postgres listens on port 5433": {1, 0},
+	}}
+	m, err := New("", embedder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTexts(t, m, "postgres listens on port 5433", "the database is empty")
+
+	embedder.err = fmt.Errorf("service unavailable")
+	want := []string{"the database is empty"}
+	if got := searchTexts(t, m, "database", 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("Search() with a failing embedder = %q, want the BM25 results %q", got, want)
+	}
+
+	// Entries that cannot be embedded are still added and found by BM25
+	if err := m.AddText(context.Background(), "the database moved"); err == nil || !strings.Contains(err.Error(), "service unavailable") {
+		t.Errorf("AddText() error = %v, want the embedder's error", err)
+	}
+	if got := searchTexts(t, m, "moved", 0); !reflect.DeepEqual(got, []string{"the database moved"}) {
+		t.Errorf("Search() = %q, want the entry added without a vector", got)
+	}
+}
+
+func TestPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory", "memory.jsonl")
+	embedder := &fakeEmbedder{vectors: map[string][]float32{"first entry": {1, 0}}}
+	m, err := New(path, embedder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTexts(t, m, "first entry", "  second entry\n", "")
+
+	// The vectors are saved with the entries
+	reloaded, err := New(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{{Text: "first entry", Vector: []float32{1, 0}}, {Text: "second entry", Vector: []float32{0, 0}}}
+	if !reflect.DeepEqual(reloaded.entries, want) {
+		t.Errorf("reloaded entries = %+v, want %+v", reloaded.entries, want)
+	}
+	if got := searchTexts(t, reloaded, "second", 0); !reflect.DeepEqual(got, []string{"second entry"}) {
+		t.Errorf("Search() after reloading = %q", got)
+	}
+
+	addTexts(t, reloaded, "third entry")
+	if again, err := New(path, nil); err != nil || again.Len() != 3 {
+		t.Errorf("New() after adding to a reloaded memory = %v entries, %v; want 3", again.Len(), err)
+	}
+}
+
+func TestNewInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory.jsonl")
+	if err := os.WriteFile(path, []byte("{\"text\": \"ok\"}\n\nnot json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(path, nil); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("New() error = %v, want the invalid line", err)
+	}
+
+	m, err := New(filepath.Join(t.TempDir(), "missing.jsonl"), nil)
+	if err != nil || m.Len() != 0 {
+		t.Errorf("New() of a missing file = %v, %v; want an empty memory", m, err)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	m, err := New("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SnippetChars = 5
+	addTexts(t, m, "héllo world", "hi")
+	if got := searchTexts(t, m, "héllo", 0); !reflect.DeepEqual(got, []string{"héll..."}) {
+		t.Errorf("Search() = %q, want a snippet cut at a character boundary", got)
+	}
+	if got := searchTexts(t, m, "hi", 0); !reflect.DeepEqual(got, []string{"hi"}) {
+		t.Errorf("Search() = %q, want a short entry whole", got)
+	}
+}
+
+func TestText(t *testing.T) {
+	item := map[string]interface{}{
+		"action": "run",
+		"args": map[string]interface{}{
+			"command":    "ls",
+			"background": false,
+			"thought":    "",
+		},
+	}
+	if got, want := Text(item), "action: run\nbackground: false\ncommand: ls"; got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+}
@@ -53,8 +53,8 @@ func (bo BaseObservation) ToMemory() map[string]interface{} {
 	var extras map[string]interface{}
 	data, _ := json.Marshal(bo)
 	json.Unmarshal(data, &extras)
-	delete(extras, "Content")
-	delete(extras, "Type")
+	delete(extras, "content")
+	delete(extras, "observation")
 	return map[string]interface{}{
 		"observation": bo.Type,
 		"content":     bo.Content,
@@ -0,0 +1,253 @@
+package plan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FormatVersion is the version of the plan file format. Files written with
+// a newer version are rejected rather than read incompletely.
+const FormatVersion = 1
+
+// Plan file formats
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// planFile is the serialized form of a Plan
+type planFile struct {
+	Version  int       `json:"version" yaml:"version"`
+	MainGoal string    `json:"main_goal" yaml:"main_goal"`
+	Task     *taskFile `json:"task" yaml:"task"`
+	History  []*Event  `json:"history,omitempty" yaml:"history,omitempty"`
+}
+
+// taskFile is the serialized form of a Task. Parent links are implied by
+// nesting and rebuilt on load. IDs and states may be left out of
+// hand-written files.
+type taskFile struct {
+	ID        string      `json:"id,omitempty" yaml:"id,omitempty"`
+	Goal      string      `json:"goal" yaml:"goal"`
+	State     string      `json:"state,omitempty" yaml:"state,omitempty"`
+	DependsOn []string    `json:"depends_on,omitempty" yaml:"depends_on,omitempty"`
+	Subtasks  []*taskFile `json:"subtasks,omitempty" yaml:"subtasks,omitempty"`
+}
+
+func newTaskFile(t *Task) *taskFile {
+	f := &taskFile{
+		ID:        t.ID,
+		Goal:      t.Goal,
+		State:     t.State,
+		DependsOn: t.DependsOn,
+	}
+	for _, subtask := range t.Subtasks {
+		f.Subtasks = append(f.Subtasks, newTaskFile(subtask))
+	}
+	return f
+}
+
+// build creates the task under parent, which it is appended to, and its
+// subtasks
+func (f *taskFile) build(parent *Task) (*Task, error) {
+	if f.State != "" && !IsValidState(f.State) {
+		return nil, fmt.Errorf("invalid state for task %q: %s", f.Goal, f.State)
+	}
+	t := NewTask(parent, f.Goal, f.State, nil)
+	if parent == nil && f.ID != "" {
+		t.ID = f.ID
+	} else if f.ID != "" && f.ID != t.ID {
+		return nil, fmt.Errorf("task %q has id %s but is at %s; ids must match task positions", f.Goal, f.ID, t.ID)
+	}
+	if parent != nil {
+		parent.Subtasks = append(parent.Subtasks, t)
+	}
+	t.DependsOn = append(t.DependsOn, f.DependsOn...)
+
+	for _, subtask := range f.Subtasks {
+		if _, err := subtask.build(t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (p *Plan) file() *planFile {
+	return &planFile{
+		Version:  FormatVersion,
+		MainGoal: p.MainGoal,
+		Task:     newTaskFile(p.Task),
+		History:  p.History,
+	}
+}
+
+// load replaces the plan with the one in f
+func (p *Plan) load(f *planFile) error {
+	switch {
+	case f.Version == 0:
+		return fmt.Errorf("plan has no version")
+	case f.Version > FormatVersion:
+		return fmt.Errorf("plan version %d is newer than the supported version %d", f.Version, FormatVersion)
+	}
+	if f.Task == nil {
+		return fmt.Errorf("plan has no task")
+	}
+
+	if f.Task.ID != "" && f.Task.ID != "0" {
+		return fmt.Errorf("plan task has id %s but must be 0", f.Task.ID)
+	}
+	root, err := f.Task.build(nil)
+	if err != nil {
+		return err
+	}
+	loaded := &Plan{MainGoal: f.MainGoal, Task: root, History: f.History}
+	if loaded.MainGoal == "" {
+		loaded.MainGoal = root.Goal
+	}
+	if err := loaded.CheckDependencies(); err != nil {
+		return err
+	}
+
+	*p = *loaded
+	return nil
+}
+
+// MarshalJSON encodes the plan with its format version
+func (p *Plan) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.file())
+}
+
+// UnmarshalJSON decodes a plan encoded with MarshalJSON or written by hand
+func (p *Plan) UnmarshalJSON(data []byte) error {
+	var f planFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	return p.load(&f)
+}
+
+// MarshalYAML encodes the plan with its format version
+func (p *Plan) MarshalYAML() (interface{}, error) {
+	return p.file(), nil
+}
+
+// UnmarshalYAML decodes a plan encoded with MarshalYAML or written by hand
+func (p *Plan) UnmarshalYAML(value *yaml.Node) error {
+	var f planFile
+	if err := value.Decode(&f); err != nil {
+		return err
+	}
+	return p.load(&f)
+}
+
+// TaskFromDict creates a task from the representation returned by ToDict,
+// adding it to parent's subtasks if parent is not nil. Without a parent the
+// task keeps its id.
+func TaskFromDict(dict map[string]interface{}, parent *Task) (*Task, error) {
+	data, err := json.Marshal(dict)
+	if err != nil {
+		return nil, fmt.Errorf("invalid task: %v", err)
+	}
+	var f taskFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid task: %v", err)
+	}
+	return f.build(parent)
+}
+
+// FormatFromPath returns the format for a file name: YAML for .yaml and
+// .yml files and JSON otherwise
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	default:
+		return FormatJSON
+	}
+}
+
+// Marshal encodes the plan in the given format
+func Marshal(p *Plan, format string) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		data, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case FormatYAML:
+		var b bytes.Buffer
+		enc := yaml.NewEncoder(&b)
+		enc.SetIndent(2)
+		if err := enc.Encode(p); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown plan format: %s", format)
+	}
+}
+
+// Unmarshal decodes a plan in the given format
+func Unmarshal(data []byte, format string) (*Plan, error) {
+	p := &Plan{}
+	var err error
+	switch format {
+	case FormatJSON:
+		err = json.Unmarshal(data, p)
+	case FormatYAML:
+		err = yaml.Unmarshal(data, p)
+	default:
+		return nil, fmt.Errorf("unknown plan format: %s", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode plan: %v", err)
+	}
+	if p.Task == nil {
+		return nil, fmt.Errorf("failed to decode plan: empty document")
+	}
+	return p, nil
+}
+
+// Save writes the plan to a file in the format its name implies. The file
+// is replaced atomically, so a crash never leaves a partial plan.
+func (p *Plan) Save(path string) error {
+	data, err := Marshal(p, FormatFromPath(path))
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create plan directory: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write plan: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write plan: %v", err)
+	}
+	return nil
+}
+
+// Load reads a plan saved with Save or written by hand
+func Load(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Unmarshal(data, FormatFromPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return p, nil
+}
@@ -0,0 +1,341 @@
+package plan
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Actors that change plans
+const (
+	ActorUser  = "user"
+	ActorAgent = "agent"
+)
+
+// Event kinds
+const (
+	EventAddSubtask       = "add_subtask"
+	EventSetState         = "set_state"
+	EventSetGoal          = "set_goal"
+	EventAddDependency    = "add_dependency"
+	EventRemoveDependency = "remove_dependency"
+)
+
+// Event records a change to the plan, with who made it and why. Changes
+// made together in one Change share a batch and are undone together.
+type Event struct {
+	Batch  int       `json:"batch" yaml:"batch"`
+	Time   time.Time `json:"time" yaml:"time"`
+	Actor  string    `json:"actor,omitempty" yaml:"actor,omitempty"`
+	Reason string    `json:"reason,omitempty" yaml:"reason,omitempty"`
+	Kind   string    `json:"kind" yaml:"kind"`
+	TaskID string    `json:"task_id" yaml:"task_id"`
+
+	// Goal is the goal of an added task, or the new goal of an edited one,
+	// which had OldGoal before
+	Goal    string `json:"goal,omitempty" yaml:"goal,omitempty"`
+	OldGoal string `json:"old_goal,omitempty" yaml:"old_goal,omitempty"`
+
+	// DependsOn is the task a dependency was added on or removed from
+	DependsOn string `json:"depends_on,omitempty" yaml:"depends_on,omitempty"`
+
+	// States lists every task whose state changed, including the changes
+	// that cascaded to subtasks and parents
+	States []StateChange `json:"states,omitempty" yaml:"states,omitempty"`
+
+	// removed holds an undone task so redo restores it with its subtasks
+	removed *Task
+}
+
+// StateChange is a change of one task's state
+type StateChange struct {
+	TaskID string `json:"task_id" yaml:"task_id"`
+	From   string `json:"from" yaml:"from"`
+	To     string `json:"to" yaml:"to"`
+}
+
+// String describes the event for timelines
+func (e *Event) String() string {
+	switch e.Kind {
+	case EventAddSubtask:
+		return fmt.Sprintf("added %s: %s", e.TaskID, e.Goal)
+	case EventSetState:
+		s := ""
+		for i, change := range e.States {
+			if i > 0 {
+				s += ", "
+			}
+			s += fmt.Sprintf("%s %s → %s", change.TaskID, change.From, change.To)
+		}
+		return "changed " + s
+	case EventSetGoal:
+		return fmt.Sprintf("renamed %s from %q to %q", e.TaskID, e.OldGoal, e.Goal)
+	case EventAddDependency:
+		return fmt.Sprintf("made %s depend on %s", e.TaskID, e.DependsOn)
+	case EventRemoveDependency:
+		return fmt.Sprintf("made %s no longer depend on %s", e.TaskID, e.DependsOn)
+	default:
+		return e.Kind + " " + e.TaskID
+	}
+}
+
+// cause is who is changing the plan and why, for the current batch
+type cause struct {
+	actor  string
+	reason string
+	batch  int
+}
+
+// Change calls fn to change the plan, recording its changes as one batch
+// made by actor for reason. Changes made outside Change are recorded in
+// batches of their own with no actor or reason. A Change made within
+// another is part of the outer batch.
+func (p *Plan) Change(actor, reason string, fn func() error) error {
+	if p.cause != nil {
+		return fn()
+	}
+	p.cause = &cause{actor: actor, reason: reason, batch: p.nextBatch()}
+	defer func() { p.cause = nil }()
+	return fn()
+}
+
+func (p *Plan) nextBatch() int {
+	if len(p.History) == 0 {
+		return 1
+	}
+	return p.History[len(p.History)-1].Batch + 1
+}
+
+// record adds an event for a change just made, which makes earlier undone
+// changes impossible to redo
+func (p *Plan) record(e *Event) {
+	if p.cause != nil {
+		e.Batch = p.cause.batch
+		e.Actor = p.cause.actor
+		e.Reason = p.cause.reason
+	} else {
+		e.Batch = p.nextBatch()
+	}
+	e.Time = time.Now()
+	p.History = append(p.History, e)
+	p.undone = nil
+}
+
+// states returns the state of every task in the plan
+func (p *Plan) states() map[string]string {
+	states := make(map[string]string)
+	var walk func(t *Task)
+	walk = func(t *Task) {
+		states[t.ID] = t.State
+		for _, subtask := range t.Subtasks {
+			walk(subtask)
+		}
+	}
+	walk(p.Task)
+	return states
+}
+
+// stateChanges lists the tasks whose state differs from before, in plan
+// order
+func (p *Plan) stateChanges(before map[string]string) []StateChange {
+	var changes []StateChange
+	var walk func(t *Task)
+	walk = func(t *Task) {
+		if from := before[t.ID]; from != t.State {
+			changes = append(changes, StateChange{TaskID: t.ID, From: from, To: t.State})
+		}
+		for _, subtask := range t.Subtasks {
+			walk(subtask)
+		}
+	}
+	walk(p.Task)
+	return changes
+}
+
+// CanUndo reports whether there are changes to undo
+func (p *Plan) CanUndo() bool {
+	return len(p.History) > 0
+}
+
+// CanRedo reports whether there are undone changes to redo
+func (p *Plan) CanRedo() bool {
+	return len(p.undone) > 0
+}
+
+// Undo reverts the last batch of changes. The batch is reverted on a copy
+// of the plan, so the plan is left as it was if any change cannot be.
+func (p *Plan) Undo() error {
+	if !p.CanUndo() {
+		return fmt.Errorf("nothing to undo")
+	}
+	c := p.clone()
+	batch := c.History[len(c.History)-1].Batch
+	for len(c.History) > 0 {
+		e := c.History[len(c.History)-1]
+		if e.Batch != batch {
+			break
+		}
+		if err := c.revert(e); err != nil {
+			return fmt.Errorf("cannot undo %s: %v", e, err)
+		}
+		c.History = c.History[:len(c.History)-1]
+		c.undone = append(c.undone, e)
+	}
+	*p = *c
+	return nil
+}
+
+// Redo makes the last batch of undone changes again. Like Undo, it leaves
+// the plan as it was if any change cannot be made.
+func (p *Plan) Redo() error {
+	if !p.CanRedo() {
+		return fmt.Errorf("nothing to redo")
+	}
+	c := p.clone()
+	batch := c.undone[len(c.undone)-1].Batch
+	for len(c.undone) > 0 {
+		e := c.undone[len(c.undone)-1]
+		if e.Batch != batch {
+			break
+		}
+		if err := c.reapply(e); err != nil {
+			return fmt.Errorf("cannot redo %s: %v", e, err)
+		}
+		c.undone = c.undone[:len(c.undone)-1]
+		c.History = append(c.History, e)
+	}
+	*p = *c
+	return nil
+}
+
+// clone returns a deep copy of the plan, including its history and the
+// tasks held by undone events
+func (p *Plan) clone() *Plan {
+	return &Plan{
+		MainGoal: p.MainGoal,
+		Task:     p.Task.clone(nil),
+		History:  cloneEvents(p.History),
+		undone:   cloneEvents(p.undone),
+		cause:    p.cause,
+	}
+}
+
+func cloneEvents(events []*Event) []*Event {
+	if events == nil {
+		return nil
+	}
+	clones := make([]*Event, len(events))
+	for i, e := range events {
+		clone := *e
+		clone.States = append([]StateChange(nil), e.States...)
+		if e.removed != nil {
+			clone.removed = e.removed.clone(nil)
+		}
+		clones[i] = &clone
+	}
+	return clones
+}
+
+// clone returns a deep copy of the task and its subtasks under parent
+func (t *Task) clone(parent *Task) *Task {
+	clone := *t
+	clone.Parent = parent
+	clone.DependsOn = append([]string(nil), t.DependsOn...)
+	clone.Subtasks = make([]*Task, len(t.Subtasks))
+	for i, subtask := range t.Subtasks {
+		clone.Subtasks[i] = subtask.clone(&clone)
+	}
+	return &clone
+}
+
+// revert undoes a single event
+func (p *Plan) revert(e *Event) error {
+	task, err := p.GetTaskByID(e.TaskID)
+	if err != nil {
+		return err
+	}
+
+	switch e.Kind {
+	case EventAddSubtask:
+		parent := task.Parent
+		if parent == nil || parent.Subtasks[len(parent.Subtasks)-1] != task {
+			return fmt.Errorf("task %s is not the last subtask", e.TaskID)
+		}
+		parent.Subtasks = parent.Subtasks[:len(parent.Subtasks)-1]
+		e.removed = task
+	case EventSetState:
+		for i := len(e.States) - 1; i >= 0; i-- {
+			if err := p.setStateOnly(e.States[i].TaskID, e.States[i].From); err != nil {
+				return err
+			}
+		}
+	case EventSetGoal:
+		task.Goal = e.OldGoal
+	case EventAddDependency:
+		return p.removeDependency(task, e.DependsOn)
+	case EventRemoveDependency:
+		task.DependsOn = append(task.DependsOn, e.DependsOn)
+	default:
+		return fmt.Errorf("unknown event kind: %s", e.Kind)
+	}
+	return nil
+}
+
+// reapply redoes a single event reverted by revert
+func (p *Plan) reapply(e *Event) error {
+	if e.Kind == EventAddSubtask {
+		i := strings.LastIndex(e.TaskID, ".")
+		if i < 0 {
+			return fmt.Errorf("invalid task id: %s", e.TaskID)
+		}
+		parent, err := p.GetTaskByID(e.TaskID[:i])
+		if err != nil {
+			return err
+		}
+		task := e.removed
+		if task == nil {
+			task = NewTask(parent, e.Goal, "", nil)
+		}
+		if task.ID != parent.ID+"."+strconv.Itoa(len(parent.Subtasks)) {
+			return fmt.Errorf("task %s would get a different id", e.TaskID)
+		}
+		task.Parent = parent
+		parent.Subtasks = append(parent.Subtasks, task)
+		e.removed = nil
+		return nil
+	}
+
+	task, err := p.GetTaskByID(e.TaskID)
+	if err != nil {
+		return err
+	}
+	switch e.Kind {
+	case EventSetState:
+		for _, change := range e.States {
+			if err := p.setStateOnly(change.TaskID, change.To); err != nil {
+				return err
+			}
+		}
+	case EventSetGoal:
+		task.Goal = e.Goal
+	case EventAddDependency:
+		task.DependsOn = append(task.DependsOn, e.DependsOn)
+	case EventRemoveDependency:
+		return p.removeDependency(task, e.DependsOn)
+	default:
+		return fmt.Errorf("unknown event kind: %s", e.Kind)
+	}
+	return nil
+}
+
+// setStateOnly sets a task's state without cascading, since the event
+// lists every task that changed
+func (p *Plan) setStateOnly(id, state string) error {
+	task, err := p.GetTaskByID(id)
+	if err != nil {
+		return err
+	}
+	task.State = state
+	return nil
+}
@@ -0,0 +1,182 @@
+package plan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// marshalPlan returns the plan as JSON, to compare it before and after a
+// change
+func marshalPlan(t *testing.T, p *Plan) string {
+	t.Helper()
+	data, err := Marshal(p, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestUndoRedo(t *testing.T) {
+	p := NewPlan("goal")
+	err := p.Change(ActorAgent, "decomposed", func() error {
+		if err := p.AddSubtask("0", "first", nil); err != nil {
+			return err
+		}
+		if err := p.AddSubtask("0.0", "first, part one", nil); err != nil {
+			return err
+		}
+		return p.SetSubtaskState("0.0.0", InProgressState)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := NewPlan("goal")
+	changed := marshalPlan(t, p)
+
+	if err := p.Undo(); err != nil {
+		t.Fatalf("Undo() error = %v", err)
+	}
+	if len(p.Task.Subtasks) != 0 || p.Task.State != OpenState || len(p.History) != 0 {
+		t.Errorf("Undo() left %s", marshalPlan(t, p))
+	}
+	if marshalPlan(t, p) != marshalPlan(t, before) {
+		t.Errorf("Undo() = %s, want %s", marshalPlan(t, p), marshalPlan(t, before))
+	}
+
+	if err := p.Redo(); err != nil {
+		t.Fatalf("Redo() error = %v", err)
+	}
+	if got := marshalPlan(t, p); got != changed {
+		t.Errorf("Redo() = %s, want %s", got, changed)
+	}
+	task, err := p.GetTaskByID("0.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Parent != p.Task.Subtasks[0] || task.Parent.Parent != p.Task {
+		t.Errorf("Redo() restored 0.0.0 with the wrong parents")
+	}
+	if p.CanRedo() {
+		t.Errorf("CanRedo() = true after redoing everything")
+	}
+}
+
+func TestUndoFailureLeavesPlan(t *testing.T) {
+	p := NewPlan("goal")
+	err := p.Change(ActorAgent, "added a task", func() error {
+		if err := p.AddSubtask("0", "first", nil); err != nil {
+			return err
+		}
+		return p.SetGoal("0.0", "renamed")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A task added without being recorded keeps 0.0 from being removed, but
+	// only after the rename has been reverted
+	p.Task.Subtasks = append(p.Task.Subtasks, NewTask(p.Task, "untracked", "", nil))
+	before := marshalPlan(t, p)
+
+	err = p.Undo()
+	if err == nil || !strings.Contains(err.Error(), "not the last subtask") {
+		t.Fatalf("Undo() error = %v, want the task not to be the last subtask", err)
+	}
+	if got := marshalPlan(t, p); got != before {
+		t.Errorf("failed Undo() changed the plan to %s, want %s", got, before)
+	}
+	if p.Task.Subtasks[0].Goal != "renamed" {
+		t.Errorf("failed Undo() reverted the goal of 0.0 to %q", p.Task.Subtasks[0].Goal)
+	}
+	if p.CanRedo() {
+		t.Errorf("CanRedo() = true after a failed Undo()")
+	}
+}
+
+func TestRedoFailureLeavesPlan(t *testing.T) {
+	p := NewPlan("goal")
+	err := p.Change(ActorAgent, "added a task", func() error {
+		if err := p.SetGoal("0", "renamed"); err != nil {
+			return err
+		}
+		return p.AddSubtask("0", "first", nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	// A task added without being recorded takes the id the undone task
+	// would get back, but only after the rename has been made again
+	p.Task.Subtasks = append(p.Task.Subtasks, NewTask(p.Task, "untracked", "", nil))
+	before := marshalPlan(t, p)
+
+	err = p.Redo()
+	if err == nil || !strings.Contains(err.Error(), "would get a different id") {
+		t.Fatalf("Redo() error = %v, want the task to get a different id", err)
+	}
+	if got := marshalPlan(t, p); got != before {
+		t.Errorf("failed Redo() changed the plan to %s, want %s", got, before)
+	}
+	if p.Task.Goal != "goal" {
+		t.Errorf("failed Redo() renamed the root task to %q", p.Task.Goal)
+	}
+	if !p.CanRedo() || p.CanUndo() {
+		t.Errorf("failed Redo() moved events between the undo and redo stacks")
+	}
+}
+
+func TestNestedChange(t *testing.T) {
+	p := NewPlan("goal")
+	if err := p.Change(ActorUser, "first", func() error { return p.AddSubtask("0", "first", nil) }); err != nil {
+		t.Fatal(err)
+	}
+	err := p.Change(ActorAgent, "outer", func() error {
+		if err := p.AddSubtask("0", "second", nil); err != nil {
+			return err
+		}
+		return p.Change(ActorUser, "inner", func() error {
+			return p.AddSubtask("0", "third", nil)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The inner change joins the outer batch rather than starting a new one
+	// with an id already in use
+	var batches []int
+	var reasons []string
+	for _, e := range p.History {
+		batches = append(batches, e.Batch)
+		reasons = append(reasons, e.Reason)
+	}
+	if want := []int{1, 2, 2}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches = %v, want %v", batches, want)
+	}
+	if want := []string{"first", "outer", "outer"}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("reasons = %v, want %v", reasons, want)
+	}
+
+	if err := p.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if got := taskIDs(p.Task.Subtasks); !reflect.DeepEqual(got, []string{"0.0"}) {
+		t.Errorf("Undo() left tasks %v, want the outer change undone as a whole", got)
+	}
+
+	// Changes after the nested one start a batch of their own
+	if err := p.Change(ActorUser, "later", func() error { return p.SetGoal("0.0", "renamed") }); err != nil {
+		t.Fatal(err)
+	}
+	if last := p.History[len(p.History)-1]; last.Batch != 2 || last.Reason != "later" {
+		t.Errorf("later change = batch %d %q, want batch 2 \"later\"", last.Batch, last.Reason)
+	}
+	if err := p.Change(ActorUser, "after", func() error { return p.SetGoal("0.0", "again") }); err != nil {
+		t.Fatal(err)
+	}
+	if last := p.History[len(p.History)-1]; last.Batch != 3 {
+		t.Errorf("change after the nested one = batch %d, want 3", last.Batch)
+	}
+}
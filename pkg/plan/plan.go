@@ -24,6 +24,10 @@ type Task struct {
 	Parent   *Task
 	Subtasks []*Task
 	State    string
+
+	// DependsOn holds the IDs of tasks that must be done before this one
+	// and its subtasks can start
+	DependsOn []string
 }
 
 // NewTask creates a new Task
@@ -72,7 +76,11 @@ func (t *Task) toString(indent string) string {
 		emoji = "🔵"
 	}
 
-	result := fmt.Sprintf("%s%s %s %s\n", indent, emoji, t.ID, t.Goal)
+	result := fmt.Sprintf("%s%s %s %s", indent, emoji, t.ID, t.Goal)
+	if len(t.DependsOn) > 0 {
+		result += " (after " + strings.Join(t.DependsOn, ", ") + ")"
+	}
+	result += "\n"
 	for _, subtask := range t.Subtasks {
 		result += subtask.toString(indent + "    ")
 	}
@@ -87,16 +95,24 @@ func (t *Task) ToDict() map[string]interface{} {
 	}
 
 	return map[string]interface{}{
-		"id":       t.ID,
-		"goal":     t.Goal,
-		"state":    t.State,
-		"subtasks": subtasks,
+		"id":         t.ID,
+		"goal":       t.Goal,
+		"state":      t.State,
+		"subtasks":   subtasks,
+		"depends_on": append([]string{}, t.DependsOn...),
 	}
 }
 
+// IsDone reports whether the task is completed or verified. Abandoned tasks
+// are not done, so tasks that depend on them stay blocked until the plan is
+// revised.
+func (t *Task) IsDone() bool {
+	return t.State == CompletedState || t.State == VerifiedState
+}
+
 // SetState sets the state of the task and its subtasks
 func (t *Task) SetState(state string) error {
-	if !isValidState(state) {
+	if !IsValidState(state) {
 		return fmt.Errorf("invalid state: %s", state)
 	}
 
@@ -138,6 +154,12 @@ func (t *Task) GetCurrentTask() *Task {
 type Plan struct {
 	MainGoal string
 	Task     *Task
+
+	// History records the changes made to the plan, oldest first
+	History []*Event
+
+	undone []*Event
+	cause  *cause
 }
 
 // NewPlan creates a new Plan
@@ -153,7 +175,8 @@ func (p *Plan) String() string {
 	return p.Task.String()
 }
 
-// GetTaskByID retrieves a task by its ID
+// GetTaskByID retrieves a task by its ID, which is 0 for the root task
+// followed by the index of each subtask on the way to the task, e.g. 0.2.1
 func (p *Plan) GetTaskByID(id string) (*Task, error) {
 	parts := strings.Split(id, ".")
 	if parts[0] != "0" {
@@ -163,8 +186,8 @@ func (p *Plan) GetTaskByID(id string) (*Task, error) {
 	task := p.Task
 	for _, part := range parts[1:] {
 		index, err := strconv.Atoi(part)
-		if err != nil {
-			return nil, fmt.Errorf("invalid task id, non-integer: %s", id)
+		if err != nil || index < 0 || part != strconv.Itoa(index) {
+			return nil, fmt.Errorf("invalid task id, not a list of subtask indices: %s", id)
 		}
 		if index >= len(task.Subtasks) {
 			return nil, fmt.Errorf("task does not exist: %s", id)
@@ -183,16 +206,40 @@ func (p *Plan) AddSubtask(parentID, goal string, subtasks []*Task) error {
 
 	child := NewTask(parent, goal, "", subtasks)
 	parent.Subtasks = append(parent.Subtasks, child)
+	p.record(&Event{Kind: EventAddSubtask, TaskID: child.ID, Goal: goal})
 	return nil
 }
 
-// SetSubtaskState sets the state of a subtask
+// SetSubtaskState sets the state of a subtask, recording the changes it
+// cascades to other tasks
 func (p *Plan) SetSubtaskState(id, state string) error {
 	task, err := p.GetTaskByID(id)
 	if err != nil {
 		return err
 	}
-	return task.SetState(state)
+
+	before := p.states()
+	err = task.SetState(state)
+	if changes := p.stateChanges(before); len(changes) > 0 {
+		p.record(&Event{Kind: EventSetState, TaskID: id, States: changes})
+	}
+	return err
+}
+
+// SetGoal changes the goal of a task
+func (p *Plan) SetGoal(id, goal string) error {
+	task, err := p.GetTaskByID(id)
+	if err != nil {
+		return err
+	}
+	if task.Goal == goal {
+		return nil
+	}
+
+	old := task.Goal
+	task.Goal = goal
+	p.record(&Event{Kind: EventSetGoal, TaskID: id, Goal: goal, OldGoal: old})
+	return nil
 }
 
 // GetCurrentTask retrieves the current task in progress
@@ -200,7 +247,197 @@ func (p *Plan) GetCurrentTask() *Task {
 	return p.Task.GetCurrentTask()
 }
 
-func isValidState(state string) bool {
+// CurrentTaskID returns the id of the current task in progress, or of the
+// root task if no task is in progress
+func (p *Plan) CurrentTaskID() string {
+	if task := p.GetCurrentTask(); task != nil {
+		return task.ID
+	}
+	return p.Task.ID
+}
+
+// AddDependency makes the task with the given id depend on the task with
+// the dependsOn id. It fails if either task does not exist or if the
+// dependency would make the plan impossible to finish.
+func (p *Plan) AddDependency(id, dependsOn string) error {
+	task, err := p.GetTaskByID(id)
+	if err != nil {
+		return err
+	}
+	if _, err := p.GetTaskByID(dependsOn); err != nil {
+		return err
+	}
+	for _, dep := range task.DependsOn {
+		if dep == dependsOn {
+			return nil
+		}
+	}
+
+	task.DependsOn = append(task.DependsOn, dependsOn)
+	if err := p.CheckDependencies(); err != nil {
+		task.DependsOn = task.DependsOn[:len(task.DependsOn)-1]
+		return fmt.Errorf("cannot make %s depend on %s: %v", id, dependsOn, err)
+	}
+	p.record(&Event{Kind: EventAddDependency, TaskID: id, DependsOn: dependsOn})
+	return nil
+}
+
+// RemoveDependency removes a dependency added with AddDependency
+func (p *Plan) RemoveDependency(id, dependsOn string) error {
+	task, err := p.GetTaskByID(id)
+	if err != nil {
+		return err
+	}
+	if err := p.removeDependency(task, dependsOn); err != nil {
+		return err
+	}
+	p.record(&Event{Kind: EventRemoveDependency, TaskID: id, DependsOn: dependsOn})
+	return nil
+}
+
+func (p *Plan) removeDependency(task *Task, dependsOn string) error {
+	for i, dep := range task.DependsOn {
+		if dep == dependsOn {
+			task.DependsOn = append(task.DependsOn[:i], task.DependsOn[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("task %s does not depend on %s", task.ID, dependsOn)
+}
+
+// CheckDependencies reports dependencies on tasks that do not exist and
+// dependency cycles. A task waits for its own dependencies, those of its
+// ancestors and its subtasks, so a task depending on its ancestor or its
+// descendant is a cycle too.
+func (p *Plan) CheckDependencies() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[*Task]int)
+
+	var visit func(t *Task, path []string) error
+	visit = func(t *Task, path []string) error {
+		switch marks[t] {
+		case visiting:
+			for i, id := range path {
+				if id == t.ID {
+					path = path[i:]
+					break
+				}
+			}
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path, t.ID), " -> "))
+		case visited:
+			return nil
+		}
+		marks[t] = visiting
+		path = append(path, t.ID)
+
+		waits, err := p.waitsOn(t)
+		if err != nil {
+			return err
+		}
+		for _, w := range waits {
+			if err := visit(w, path); err != nil {
+				return err
+			}
+		}
+		marks[t] = visited
+		return nil
+	}
+
+	var walk func(t *Task) error
+	walk = func(t *Task) error {
+		if err := visit(t, nil); err != nil {
+			return err
+		}
+		for _, subtask := range t.Subtasks {
+			if err := walk(subtask); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(p.Task)
+}
+
+// waitsOn returns the tasks that must be done before t can be: its own and
+// its ancestors' dependencies, and its subtasks
+func (p *Plan) waitsOn(t *Task) ([]*Task, error) {
+	deps, err := p.dependencies(t)
+	if err != nil {
+		return nil, err
+	}
+	return append(deps, t.Subtasks...), nil
+}
+
+// dependencies returns the tasks t and its ancestors depend on
+func (p *Plan) dependencies(t *Task) ([]*Task, error) {
+	var deps []*Task
+	for task := t; task != nil; task = task.Parent {
+		for _, id := range task.DependsOn {
+			dep, err := p.GetTaskByID(id)
+			if err != nil {
+				return nil, fmt.Errorf("task %s depends on missing task: %v", task.ID, err)
+			}
+			deps = append(deps, dep)
+		}
+	}
+	return deps, nil
+}
+
+// BlockedBy returns the dependencies of the task with the given id, and of
+// its ancestors, that are not done yet
+func (p *Plan) BlockedBy(id string) ([]*Task, error) {
+	task, err := p.GetTaskByID(id)
+	if err != nil {
+		return nil, err
+	}
+	deps, err := p.dependencies(task)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocking []*Task
+	for _, dep := range deps {
+		if !dep.IsDone() {
+			blocking = append(blocking, dep)
+		}
+	}
+	return blocking, nil
+}
+
+// RunnableTasks returns the open tasks that can be worked on now, in plan
+// order: those whose dependencies are done and whose subtasks are done or
+// abandoned. The root task is the main goal rather than a task to work on,
+// so it is never returned. The tasks do not depend on each other, so they
+// can be worked on concurrently.
+func (p *Plan) RunnableTasks() []*Task {
+	var runnable []*Task
+	var walk func(t *Task)
+	walk = func(t *Task) {
+		ready := true
+		for _, subtask := range t.Subtasks {
+			walk(subtask)
+			if !subtask.IsDone() && subtask.State != AbandonedState {
+				ready = false
+			}
+		}
+		if t == p.Task || !ready || t.State != OpenState {
+			return
+		}
+		if blocking, err := p.BlockedBy(t.ID); err != nil || len(blocking) > 0 {
+			return
+		}
+		runnable = append(runnable, t)
+	}
+	walk(p.Task)
+	return runnable
+}
+
+// IsValidState reports whether state is one of the task states
+func IsValidState(state string) bool {
 	for _, s := range validStates {
 		if s == state {
 			return true
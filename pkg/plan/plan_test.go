@@ -0,0 +1,292 @@
+package plan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testPlan returns a plan whose task 0.1 depends on 0.0 and whose task 0.2
+// has a subtask 0.2.0
+func testPlan(t *testing.T) *Plan {
+	t.Helper()
+	p := NewPlan("goal")
+	for _, goal := range []string{"first", "second", "third"} {
+		if err := p.AddSubtask("0", goal, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.AddSubtask("0.2", "third, part one", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddDependency("0.1", "0.0"); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func taskIDs(tasks []*Task) []string {
+	ids := []string{}
+	for _, task := range tasks {
+		ids = append(ids, task.ID)
+	}
+	return ids
+}
+
+func TestRunnableTasks(t *testing.T) {
+	tests := []struct {
+		name   string
+		states map[string]string
+		want   []string
+	}{
+		{name: "blocked by dependency and subtask", want: []string{"0.0", "0.2.0"}},
+		{name: "dependency done", states: map[string]string{"0.0": CompletedState}, want: []string{"0.1", "0.2.0"}},
+		{name: "dependency abandoned", states: map[string]string{"0.0": AbandonedState}, want: []string{"0.2.0"}},
+		{name: "subtask done", states: map[string]string{"0.2.0": VerifiedState}, want: []string{"0.0", "0.2"}},
+		{name: "in progress", states: map[string]string{"0.0": InProgressState}, want: []string{"0.2.0"}},
+		{
+			name:   "all done",
+			states: map[string]string{"0.0": CompletedState, "0.1": CompletedState, "0.2": CompletedState},
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		p := testPlan(t)
+		for id, state := range tt.states {
+			task, err := p.GetTaskByID(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := task.SetState(state); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := taskIDs(p.RunnableTasks()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: RunnableTasks() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRunnableTasksSkipsRoot(t *testing.T) {
+	p := NewPlan("goal")
+	if got := p.RunnableTasks(); len(got) != 0 {
+		t.Errorf("RunnableTasks() = %v, want none for a plan without tasks", taskIDs(got))
+	}
+
+	p = testPlan(t)
+	for _, task := range p.Task.Subtasks {
+		task.SetState(CompletedState)
+	}
+	if got := p.RunnableTasks(); len(got) != 0 {
+		t.Errorf("RunnableTasks() = %v, want none when every task is done", taskIDs(got))
+	}
+}
+
+func TestBlockedBy(t *testing.T) {
+	p := testPlan(t)
+	if err := p.AddDependency("0.2", "0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id   string
+		want []string
+	}{
+		{id: "0.0", want: []string{}},
+		{id: "0.1", want: []string{"0.0"}},
+		// Subtasks wait for their parent's dependencies
+		{id: "0.2.0", want: []string{"0.1"}},
+	}
+	for _, tt := range tests {
+		blocking, err := p.BlockedBy(tt.id)
+		if err != nil {
+			t.Errorf("BlockedBy(%s) error = %v", tt.id, err)
+		} else if got := taskIDs(blocking); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("BlockedBy(%s) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+
+	if err := p.SetSubtaskState("0.1", CompletedState); err != nil {
+		t.Fatal(err)
+	}
+	if blocking, err := p.BlockedBy("0.2.0"); err != nil || len(blocking) != 0 {
+		t.Errorf("BlockedBy(0.2.0) = %v, %v after 0.1 was completed, want none", taskIDs(blocking), err)
+	}
+}
+
+func TestAddDependencyCycle(t *testing.T) {
+	tests := []struct {
+		id, dependsOn string
+		err           string
+	}{
+		{id: "0.0", dependsOn: "0.1", err: "dependency cycle: 0.0 -> 0.1 -> 0.0"},
+		{id: "0.0", dependsOn: "0.0", err: "dependency cycle"},
+		{id: "0.2.0", dependsOn: "0.2", err: "dependency cycle"},
+		{id: "0.2", dependsOn: "0.2.0", err: "dependency cycle"},
+		{id: "0.0", dependsOn: "0.3", err: "task does not exist: 0.3"},
+	}
+	for _, tt := range tests {
+		p := testPlan(t)
+		task, err := p.GetTaskByID(tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := append([]string{}, task.DependsOn...)
+		history := len(p.History)
+
+		err = p.AddDependency(tt.id, tt.dependsOn)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("AddDependency(%s, %s) error = %v, want %q", tt.id, tt.dependsOn, err, tt.err)
+		}
+		if !reflect.DeepEqual(append([]string{}, task.DependsOn...), before) {
+			t.Errorf("AddDependency(%s, %s) left DependsOn = %v, want %v", tt.id, tt.dependsOn, task.DependsOn, before)
+		}
+		if len(p.History) != history {
+			t.Errorf("AddDependency(%s, %s) recorded a failed change", tt.id, tt.dependsOn)
+		}
+	}
+}
+
+func TestUnmarshalDependencyCycle(t *testing.T) {
+	data := `{"version": 1, "task": {"goal": "goal", "subtasks": [
+		{"goal": "first", "depends_on": ["0.1"]},
+		{"goal": "second", "depends_on": ["0.0"]}
+	]}}`
+	_, err := Unmarshal([]byte(data), FormatJSON)
+	if err == nil || !strings.Contains(err.Error(), "dependency cycle") {
+		t.Errorf("Unmarshal() error = %v, want a dependency cycle", err)
+	}
+}
+
+func TestGetTaskByID(t *testing.T) {
+	p := testPlan(t)
+	tests := []struct {
+		id   string
+		goal string
+		err  string
+	}{
+		{id: "0", goal: "goal"},
+		{id: "0.1", goal: "second"},
+		{id: "0.2.0", goal: "third, part one"},
+		{id: "0.3", err: "task does not exist"},
+		{id: "0.2.1", err: "task does not exist"},
+		{id: "0.-1", err: "invalid task id"},
+		{id: "0.2.-1", err: "invalid task id"},
+		{id: "0.", err: "invalid task id"},
+		{id: "0..1", err: "invalid task id"},
+		{id: "0.+1", err: "invalid task id"},
+		{id: "0.01", err: "invalid task id"},
+		{id: "0.x", err: "invalid task id"},
+		{id: "1.0", err: "must start with 0"},
+		{id: "", err: "must start with 0"},
+	}
+	for _, tt := range tests {
+		task, err := p.GetTaskByID(tt.id)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("GetTaskByID(%q) error = %v, want %q", tt.id, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("GetTaskByID(%q) error = %v", tt.id, err)
+		} else if task.Goal != tt.goal {
+			t.Errorf("GetTaskByID(%q) = %q, want %q", tt.id, task.Goal, tt.goal)
+		}
+	}
+}
+
+func TestUnmarshalInvalidDependency(t *testing.T) {
+	for _, dep := range []string{"0.-1", "0.5", "0.", "1"} {
+		data := `{"version": 1, "task": {"goal": "goal", "subtasks": [
+			{"goal": "first", "depends_on": ["` + dep + `"]}
+		]}}`
+		_, err := Unmarshal([]byte(data), FormatJSON)
+		if err == nil || !strings.Contains(err.Error(), "depends on missing task") {
+			t.Errorf("Unmarshal() with a dependency on %q error = %v, want a missing task", dep, err)
+		}
+	}
+}
+
+// checkParents fails unless every subtask under task links back to its parent
+func checkParents(t *testing.T, task *Task) {
+	t.Helper()
+	for _, subtask := range task.Subtasks {
+		if subtask.Parent != task {
+			t.Errorf("%s has parent %v, want %s", subtask.ID, subtask.Parent, task.ID)
+		}
+		checkParents(t, subtask)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	p := testPlan(t)
+	err := p.Change(ActorUser, "started", func() error {
+		if err := p.AddSubtask("0.2.0", "third, part one, step one", nil); err != nil {
+			return err
+		}
+		if err := p.SetGoal("0.1", "second, renamed"); err != nil {
+			return err
+		}
+		return p.SetSubtaskState("0.0", InProgressState)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetSubtaskState("0.2.0.0", CompletedState); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{FormatJSON, FormatYAML} {
+		data, err := Marshal(p, format)
+		if err != nil {
+			t.Fatalf("Marshal(%s) error = %v", format, err)
+		}
+		loaded, err := Unmarshal(data, format)
+		if err != nil {
+			t.Fatalf("Unmarshal(%s) error = %v", format, err)
+		}
+		again, err := Marshal(loaded, format)
+		if err != nil {
+			t.Fatalf("Marshal(%s) error = %v", format, err)
+		}
+		if string(again) != string(data) {
+			t.Errorf("%s round trip = %s, want %s", format, again, data)
+		}
+
+		if loaded.Task.Parent != nil {
+			t.Errorf("%s: root task has parent %s", format, loaded.Task.Parent.ID)
+		}
+		checkParents(t, loaded.Task)
+		task, err := loaded.GetTaskByID("0.2.0.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.State != CompletedState || task.Parent.Parent.Goal != "third" {
+			t.Errorf("%s: 0.2.0.0 = %s under %q", format, task.State, task.Parent.Parent.Goal)
+		}
+		if !reflect.DeepEqual(loaded.Task.Subtasks[1].DependsOn, []string{"0.0"}) {
+			t.Errorf("%s: 0.1 depends on %v, want [0.0]", format, loaded.Task.Subtasks[1].DependsOn)
+		}
+		if len(loaded.History) != len(p.History) || !loaded.CanUndo() {
+			t.Errorf("%s: %d events, want %d", format, len(loaded.History), len(p.History))
+		}
+	}
+}
+
+func TestUnmarshalVersion(t *testing.T) {
+	tests := []struct {
+		data, format, err string
+	}{
+		{data: `{"version": 2, "task": {"goal": "goal"}}`, format: FormatJSON, err: "plan version 2 is newer"},
+		{data: `{"task": {"goal": "goal"}}`, format: FormatJSON, err: "plan has no version"},
+		{data: "version: 2\ntask:\n  goal: goal\n", format: FormatYAML, err: "plan version 2 is newer"},
+		{data: "task:\n  goal: goal\n", format: FormatYAML, err: "plan has no version"},
+	}
+	for _, tt := range tests {
+		_, err := Unmarshal([]byte(tt.data), tt.format)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Unmarshal(%q) error = %v, want %q", tt.data, err, tt.err)
+		}
+	}
+}
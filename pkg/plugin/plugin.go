@@ -17,7 +17,7 @@ type PluginRequirement struct {
 // SandboxProtocol defines the interface for sandbox operations
 type SandboxProtocol interface {
 	Execute(cmd string) (int, string)
-	CopyTo(hostSrc, sandboxDest string, recursive bool)
+	CopyTo(hostSrc, sandboxDest string, recursive bool) error
 }
 
 // PluginMixin provides plugin support for Sandbox
@@ -28,8 +28,9 @@ type PluginMixin struct {
 // InitPlugins initializes plugins in the sandbox
 func (pm *PluginMixin) InitPlugins(requirements []PluginRequirement) error {
 	for _, req := range requirements {
-		// Simulate copying files
-		pm.Sandbox.CopyTo(req.HostSrc, req.SandboxDest, true)
+		if err := pm.Sandbox.CopyTo(req.HostSrc, req.SandboxDest, true); err != nil {
+			return fmt.Errorf("failed to copy plugin %s: %v", req.Name, err)
+		}
 		// logger.Info(fmt.Sprintf("Copied files from [%s] to [%s] inside sandbox.", req.HostSrc, req.SandboxDest))
 
 		// Execute the bash script
@@ -64,9 +65,10 @@ func (ms *MockSandbox) Execute(cmd string) (int, string) {
 }
 
 // CopyTo simulates file copying in the sandbox
-func (ms *MockSandbox) CopyTo(hostSrc, sandboxDest string, recursive bool) {
+func (ms *MockSandbox) CopyTo(hostSrc, sandboxDest string, recursive bool) error {
 	// Simulate successful copy
 	// logger.Info(fmt.Sprintf("Copied %s to %s (recursive: %v)", hostSrc, sandboxDest, recursive))
+	return nil
 }
 
 // NewPluginMixin creates a new PluginMixin with a MockSandbox
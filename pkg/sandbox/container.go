@@ -0,0 +1,397 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openagentsinc/autodev/pkg/observation"
+	"github.com/openagentsinc/autodev/pkg/plugin"
+)
+
+// Container defaults
+const (
+	DefaultImage     = "ubuntu:22.04"
+	DefaultWorkspace = "/workspace"
+)
+
+// ContainerConfig configures a container sandbox
+type ContainerConfig struct {
+	// Runtime is the container CLI, "podman" or "docker". If empty, podman
+	// is used when installed, as it runs rootless by default.
+	Runtime string
+
+	// Image is the image to run, DefaultImage if empty
+	Image string
+
+	// Workspace is a host directory mounted read-write at DefaultWorkspace,
+	// where commands run. Nothing else from the host is visible.
+	Workspace string
+
+	// Network enables network access. Containers have no network by default.
+	Network bool
+
+	// CPUs, Memory (e.g. "2g") and PidsLimit limit the container's resources
+	// when set
+	CPUs      float64
+	Memory    string
+	PidsLimit int
+
+	// User runs commands as the given "uid:gid", e.g. so files written to
+	// the workspace are owned by the host user
+	User string
+
+	// Env is set in the container
+	Env []string
+
+	// Timeout limits foreground commands, DefaultTimeout if zero. It is
+	// rounded up to whole seconds.
+	Timeout time.Duration
+}
+
+// Container is a sandbox running commands in a container, isolated from the
+// host except for the mounted workspace. It implements both
+// plugin.SandboxProtocol and action.ActionManager.
+type Container struct {
+	// Session, if set, runs foreground commands instead of a new exec for
+	// each, e.g. a ShellSession started with StartSession
+	Session plugin.SandboxProtocol
+
+	config  ContainerConfig
+	runtime string
+	name    string
+
+	mu         sync.Mutex
+	nextID     int
+	background map[int]*containerCommand
+}
+
+type containerCommand struct {
+	command string
+	pid     int
+
+	mu     sync.Mutex // guards read and exited while the log is read
+	read   int
+	exited bool
+}
+
+// NewContainer starts a container sandbox
+func NewContainer(config ContainerConfig) (*Container, error) {
+	runtime := config.Runtime
+	if runtime == "" {
+		runtime = "docker"
+		if _, err := exec.LookPath("podman"); err == nil {
+			runtime = "podman"
+		}
+	}
+	if _, err := exec.LookPath(runtime); err != nil {
+		return nil, fmt.Errorf("container runtime %s not found: %v", runtime, err)
+	}
+	if config.Image == "" {
+		config.Image = DefaultImage
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Timeout < 0 {
+		return nil, fmt.Errorf("invalid timeout: %s", config.Timeout)
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	c := &Container{
+		config:     config,
+		runtime:    runtime,
+		name:       "autodev-sandbox-" + hex.EncodeToString(suffix),
+		background: make(map[int]*containerCommand),
+	}
+
+	args, err := runArgs(c.name, config)
+	if err != nil {
+		return nil, err
+	}
+	if output, err := exec.Command(runtime, args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to start container: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	// Make sure the workspace exists when no host directory is mounted.
+	if _, _, err := c.exec(context.Background(), "mkdir", "-p", DefaultWorkspace); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// runArgs returns the arguments starting a container named name with the
+// limits, network and workspace in config
+func runArgs(name string, config ContainerConfig) ([]string, error) {
+	args := []string{"run", "--detach", "--rm", "--init", "--name", name,
+		"--security-opt", "no-new-privileges",
+		"--workdir", DefaultWorkspace,
+	}
+	if !config.Network {
+		args = append(args, "--network", "none")
+	}
+	if config.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(config.CPUs, 'f', -1, 64))
+	}
+	if config.Memory != "" {
+		args = append(args, "--memory", config.Memory)
+	}
+	if config.PidsLimit > 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(config.PidsLimit))
+	}
+	if config.User != "" {
+		args = append(args, "--user", config.User)
+	}
+	if config.Workspace != "" {
+		workspace, err := filepath.Abs(config.Workspace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid workspace: %v", err)
+		}
+		args = append(args, "--volume", workspace+":"+DefaultWorkspace)
+	}
+	for _, env := range config.Env {
+		args = append(args, "--env", env)
+	}
+	return append(args, config.Image, "sleep", "infinity"), nil
+}
+
+// Name returns the container name
+func (c *Container) Name() string {
+	return c.name
+}
+
+// exec runs a program in the container and returns its exit code and
+// combined output
+func (c *Container) exec(ctx context.Context, args ...string) (int, string, error) {
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.runtime, append([]string{"exec", c.name}, args...)...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	exitCode, err := exitCode(cmd.Run())
+	if err != nil {
+		return -1, output.String(), fmt.Errorf("failed to exec in container: %v", err)
+	}
+	return exitCode, output.String(), nil
+}
+
+// Execute runs a command in the container, in the workspace
+func (c *Container) Execute(cmd string) (int, string) {
+	if c.Session != nil {
+		return c.Session.Execute(cmd)
+	}
+	exitCode, output, err := c.run(cmd)
+	if err != nil {
+		return -1, err.Error()
+	}
+	return exitCode, output
+}
+
+// run runs a command with the timeout enforced inside the container, so a
+// runaway command is killed there and not just detached from. The timeout
+// is rounded up, since timeout(1) takes 0 to mean no timeout.
+func (c *Container) run(command string) (int, string, error) {
+	seconds := strconv.Itoa(int(math.Ceil(c.config.Timeout.Seconds())))
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout+30*time.Second)
+	defer cancel()
+
+	exitCode, output, err := c.exec(ctx, "timeout", "--kill-after=5", seconds, "bash", "-c", command)
+	if err != nil {
+		return exitCode, output, err
+	}
+	if exitCode == TimeoutExitCode {
+		output += fmt.Sprintf("\n[Command timed out after %s]", c.config.Timeout)
+	}
+	return exitCode, output, nil
+}
+
+// CopyTo copies a file or directory from the host into the container. A
+// directory's contents are copied into sandboxDest, which is relative to the
+// workspace unless absolute.
+func (c *Container) CopyTo(hostSrc, sandboxDest string, recursive bool) error {
+	info, err := os.Stat(hostSrc)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() && !recursive {
+		return fmt.Errorf("%s is a directory", hostSrc)
+	}
+
+	if !path.IsAbs(sandboxDest) {
+		sandboxDest = path.Join(DefaultWorkspace, sandboxDest)
+	}
+	exitCode, output, err := c.exec(context.Background(), "mkdir", "-p", sandboxDest)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("failed to create %s: %s", sandboxDest, strings.TrimSpace(output))
+	}
+
+	src, dest := hostSrc, sandboxDest+"/"+filepath.Base(hostSrc)
+	if info.IsDir() {
+		src, dest = strings.TrimRight(hostSrc, "/")+"/.", sandboxDest
+	}
+	return runCopy(c.runtime, "cp", src, c.name+":"+dest)
+}
+
+// RunCommand runs a command in the container, in the background if asked
+func (c *Container) RunCommand(command string, background bool) (observation.Observation, error) {
+	if !background {
+		if c.Session != nil {
+			exitCode, output := c.Session.Execute(command)
+			return observation.NewCmdOutputObservation(output, -1, command, exitCode), nil
+		}
+		exitCode, output, err := c.run(command)
+		if err != nil {
+			return nil, err
+		}
+		return observation.NewCmdOutputObservation(output, -1, command, exitCode), nil
+	}
+
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	c.mu.Unlock()
+
+	// Start the command in its own session, so it can be killed along with
+	// its children, logging its output and exit code to files.
+	script := `setsid bash -c 'bash -c "$0" >"/tmp/autodev-bg-$1.log" 2>&1; echo $? >"/tmp/autodev-bg-$1.exit"' "$1" "$2" </dev/null >/dev/null 2>&1 & echo $!`
+	exitCode, output, err := c.exec(context.Background(), "bash", "-c", script, "_", command, strconv.Itoa(id))
+	if err != nil {
+		return nil, err
+	}
+	pid, convErr := strconv.Atoi(strings.TrimSpace(output))
+	if exitCode != 0 || convErr != nil {
+		return nil, fmt.Errorf("failed to start background command: %s", strings.TrimSpace(output))
+	}
+
+	c.mu.Lock()
+	c.background[id] = &containerCommand{command: command, pid: pid}
+	c.mu.Unlock()
+
+	content := fmt.Sprintf("Background command %d started.", id)
+	return observation.NewCmdOutputObservation(content, id, command, 0), nil
+}
+
+// KillCommand kills a background command and returns its unread output
+func (c *Container) KillCommand(id int) (observation.Observation, error) {
+	c.mu.Lock()
+	bg, ok := c.background[id]
+	delete(c.background, id)
+	c.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no background command with id %d", id)
+	}
+
+	c.exec(context.Background(), "kill", "-KILL", "--", "-"+strconv.Itoa(bg.pid))
+
+	bg.mu.Lock()
+	bg.exited = true
+	output, _ := c.unread(id, bg)
+	bg.mu.Unlock()
+	content := fmt.Sprintf("Background command %d killed.", id)
+	if output != "" {
+		content = output + "\n" + content
+	}
+	return observation.NewCmdOutputObservation(content, id, bg.command, 137), nil
+}
+
+// PollBackground returns the new output of each background command since
+// the last poll, reporting commands that have exited one last time
+func (c *Container) PollBackground() []observation.CmdOutputObservation {
+	c.mu.Lock()
+	ids := make([]int, 0, len(c.background))
+	for id := range c.background {
+		ids = append(ids, id)
+	}
+	c.mu.Unlock()
+	sort.Ints(ids)
+
+	var polled []observation.CmdOutputObservation
+	for _, id := range ids {
+		c.mu.Lock()
+		bg, ok := c.background[id]
+		c.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if obs, ok := c.poll(id, bg); ok {
+			polled = append(polled, obs)
+		}
+	}
+	return polled
+}
+
+// poll returns the new output of a background command, if any. The
+// command is locked throughout, so concurrent polls neither report output
+// twice nor report an exit twice.
+func (c *Container) poll(id int, bg *containerCommand) (observation.CmdOutputObservation, bool) {
+	bg.mu.Lock()
+	defer bg.mu.Unlock()
+	if bg.exited {
+		return observation.CmdOutputObservation{}, false
+	}
+
+	// Check for the exit code first, so no output written before the
+	// command exited is missed.
+	_, exit, _ := c.exec(context.Background(), "cat", fmt.Sprintf("/tmp/autodev-bg-%d.exit", id))
+	exitCode, exitErr := strconv.Atoi(strings.TrimSpace(exit))
+	exited := exitErr == nil
+
+	output, err := c.unread(id, bg)
+	if err != nil {
+		return observation.CmdOutputObservation{}, false
+	}
+	if exited {
+		bg.exited = true
+		c.mu.Lock()
+		delete(c.background, id)
+		c.mu.Unlock()
+		output += fmt.Sprintf("\n[Background command %d exited with code %d]", id, exitCode)
+		return *observation.NewCmdOutputObservation(output, id, bg.command, exitCode), true
+	}
+	if output == "" {
+		return observation.CmdOutputObservation{}, false
+	}
+	return *observation.NewCmdOutputObservation(output, id, bg.command, 0), true
+}
+
+// unread returns the log output of a background command since the last
+// call. The caller must hold bg.mu.
+func (c *Container) unread(id int, bg *containerCommand) (string, error) {
+	log := fmt.Sprintf("/tmp/autodev-bg-%d.log", id)
+	exitCode, output, err := c.exec(context.Background(), "tail", "-c", "+"+strconv.Itoa(bg.read+1), log)
+	if err != nil || exitCode != 0 {
+		return "", fmt.Errorf("failed to read output of background command %d", id)
+	}
+	bg.read += len(output)
+	return output, nil
+}
+
+// Close ends the session, if any, and stops and removes the container
+func (c *Container) Close() error {
+	if session, ok := c.Session.(io.Closer); ok {
+		session.Close()
+	}
+	if output, err := exec.Command(c.runtime, "rm", "--force", c.name).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to remove container: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
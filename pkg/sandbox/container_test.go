@@ -0,0 +1,262 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRuntime returns a container runtime that logs its arguments, one call
+// per line, and fails "cp" with an error on stderr when FAKE_CP_FAIL is set.
+// Background commands started in it get pid 42, and their output is read
+// from the file named by FAKE_BG_LOG.
+func fakeRuntime(t *testing.T) (runtime, log string) {
+	t.Helper()
+	dir := t.TempDir()
+	log = filepath.Join(dir, "calls.log")
+	runtime = filepath.Join(dir, "runtime")
+	script := `#!/bin/sh
+echo "$@" >> "` + log + `"
+if [ "$1" = cp ] && [ -n "$FAKE_CP_FAIL" ]; then
+	echo "Error: No such container" >&2
+	exit 1
+fi
+if [ "$1" = exec ] && [ "$3" = bash ] && [ "$4" = -c ]; then
+	echo 42
+fi
+if [ "$1" = exec ] && [ "$3" = tail ]; then
+	tail -c "$5" "$FAKE_BG_LOG"
+fi
+`
+	if err := os.WriteFile(runtime, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return runtime, log
+}
+
+func TestContainerCopyTo(t *testing.T) {
+	runtime, log := fakeRuntime(t)
+	c, err := NewContainer(ContainerConfig{Runtime: runtime})
+	if err != nil {
+		t.Skipf("cannot run the fake runtime: %v", err)
+	}
+	defer c.Close()
+
+	host := t.TempDir()
+	file := filepath.Join(host, "setup.sh")
+	if err := os.WriteFile(file, []byte("true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		src       string
+		dest      string
+		recursive bool
+		want      string
+	}{
+		{"file", file, "plugins", false, "cp " + file + " " + c.Name() + ":/workspace/plugins/setup.sh"},
+		{"file with recursive", file, "/opt/plugins", true, "cp " + file + " " + c.Name() + ":/opt/plugins/setup.sh"},
+		{"directory", host + "/", "/opt/plugins", true, "cp " + host + "/. " + c.Name() + ":/opt/plugins"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(log)
+			if err := c.CopyTo(tt.src, tt.dest, tt.recursive); err != nil {
+				t.Fatal(err)
+			}
+			data, _ := os.ReadFile(log)
+			calls := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if got := calls[len(calls)-1]; got != tt.want {
+				t.Errorf("last call = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if err := c.CopyTo(host, "plugins", false); err == nil {
+		t.Errorf("want an error copying a directory without recursive")
+	}
+	if err := c.CopyTo(filepath.Join(host, "missing"), "plugins", false); err == nil {
+		t.Errorf("want an error copying a missing file")
+	}
+
+	t.Setenv("FAKE_CP_FAIL", "1")
+	err = c.CopyTo(file, "plugins", false)
+	if err == nil || !strings.Contains(err.Error(), "No such container") {
+		t.Errorf("error = %v, want the runtime's stderr", err)
+	}
+}
+
+// calls returns the calls logged by a fake runtime
+func calls(t *testing.T, log string) []string {
+	t.Helper()
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestRunArgs(t *testing.T) {
+	workspace := t.TempDir()
+	base := []string{"run", "--detach", "--rm", "--init", "--name", "box",
+		"--security-opt", "no-new-privileges", "--workdir", "/workspace"}
+
+	tests := []struct {
+		name   string
+		config ContainerConfig
+		want   []string
+	}{
+		{
+			name:   "no network by default",
+			config: ContainerConfig{Image: "img"},
+			want:   []string{"--network", "none", "img", "sleep", "infinity"},
+		},
+		{
+			name:   "network",
+			config: ContainerConfig{Image: "img", Network: true},
+			want:   []string{"img", "sleep", "infinity"},
+		},
+		{
+			name:   "limits",
+			config: ContainerConfig{Image: "img", Network: true, CPUs: 1.5, Memory: "512m", PidsLimit: 64},
+			want:   []string{"--cpus", "1.5", "--memory", "512m", "--pids-limit", "64", "img", "sleep", "infinity"},
+		},
+		{
+			name:   "workspace and user",
+			config: ContainerConfig{Image: "img", Network: true, Workspace: workspace, User: "1000:1000", Env: []string{"A=1"}},
+			want:   []string{"--user", "1000:1000", "--volume", workspace + ":/workspace", "--env", "A=1", "img", "sleep", "infinity"},
+		},
+	}
+	for _, tt := range tests {
+		got, err := runArgs("box", tt.config)
+		if err != nil {
+			t.Errorf("%s: runArgs() error = %v", tt.name, err)
+			continue
+		}
+		if want := append(append([]string{}, base...), tt.want...); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: runArgs() = %q, want %q", tt.name, got, want)
+		}
+	}
+}
+
+func TestRunArgsRelativeWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	args, err := runArgs("box", ContainerConfig{Image: "img", Workspace: "workspace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mount := filepath.Join(dir, "workspace") + ":/workspace"
+	if !strings.Contains(strings.Join(args, " "), "--volume "+mount) {
+		t.Errorf("runArgs() = %q, want the workspace mounted by its absolute path %s", args, mount)
+	}
+}
+
+func TestNewContainer(t *testing.T) {
+	runtime, log := fakeRuntime(t)
+	workspace := t.TempDir()
+	c, err := NewContainer(ContainerConfig{Runtime: runtime, Workspace: workspace, Memory: "1g", CPUs: 2})
+	if err != nil {
+		t.Skipf("cannot run the fake runtime: %v", err)
+	}
+	defer c.Close()
+
+	want := "run --detach --rm --init --name " + c.Name() +
+		" --security-opt no-new-privileges --workdir /workspace --network none --cpus 2 --memory 1g" +
+		" --volume " + workspace + ":/workspace ubuntu:22.04 sleep infinity"
+	if got := calls(t, log)[0]; got != want {
+		t.Errorf("first call = %q, want %q", got, want)
+	}
+
+	if _, err := NewContainer(ContainerConfig{Runtime: runtime, Timeout: -time.Second}); err == nil {
+		t.Errorf("want an error for a negative timeout")
+	}
+}
+
+func TestContainerTimeout(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		want    string
+	}{
+		{timeout: 0, want: "120"},
+		{timeout: 100 * time.Millisecond, want: "1"},
+		{timeout: 1500 * time.Millisecond, want: "2"},
+		{timeout: 3 * time.Second, want: "3"},
+	}
+	for _, tt := range tests {
+		runtime, log := fakeRuntime(t)
+		c, err := NewContainer(ContainerConfig{Runtime: runtime, Timeout: tt.timeout})
+		if err != nil {
+			t.Skipf("cannot run the fake runtime: %v", err)
+		}
+		c.Execute("true")
+		c.Close()
+
+		want := "exec " + c.Name() + " timeout --kill-after=5 " + tt.want + " bash -c true"
+		if got := calls(t, log); !containsCall(got, want) {
+			t.Errorf("timeout %s: calls = %q, want %q", tt.timeout, got, want)
+		}
+	}
+}
+
+func containsCall(calls []string, call string) bool {
+	for _, c := range calls {
+		if c == call {
+			return true
+		}
+	}
+	return false
+}
+
+func TestContainerPollBackgroundConcurrently(t *testing.T) {
+	runtime, _ := fakeRuntime(t)
+	output := filepath.Join(t.TempDir(), "bg.log")
+	if err := os.WriteFile(output, []byte("line one\nline two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAKE_BG_LOG", output)
+
+	c, err := NewContainer(ContainerConfig{Runtime: runtime})
+	if err != nil {
+		t.Skipf("cannot run the fake runtime: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.RunCommand("server", true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each poll must see its own part of the output, so together they see
+	// it exactly once
+	var mu sync.Mutex
+	var seen strings.Builder
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, obs := range c.PollBackground() {
+				mu.Lock()
+				seen.WriteString(obs.Content)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := seen.String(); got != "line one\nline two\n" {
+		t.Errorf("polled output = %q, want each line once", got)
+	}
+}
@@ -0,0 +1,323 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openagentsinc/autodev/pkg/observation"
+	"github.com/openagentsinc/autodev/pkg/plugin"
+)
+
+// DefaultTimeout is how long a foreground command may run
+const DefaultTimeout = 2 * time.Minute
+
+// TimeoutExitCode is reported for commands killed by the timeout, matching
+// the timeout(1) utility
+const TimeoutExitCode = 124
+
+// Local runs agent commands as processes on the host, in a working
+// directory. It implements action.ActionManager.
+type Local struct {
+	// Dir is the working directory commands run in
+	Dir string
+
+	// Timeout limits foreground commands. Background commands run until
+	// they exit or are killed.
+	Timeout time.Duration
+
+	// Shell runs each command with "-c"
+	Shell string
+
+	// Env is added to the environment of every command
+	Env []string
+
+	// Session, if set, runs foreground commands instead of a new process
+	// for each, e.g. a ShellSession so state carries over between commands
+	Session plugin.SandboxProtocol
+
+	mu         sync.Mutex
+	nextID     int
+	background map[int]*backgroundCommand
+}
+
+type backgroundCommand struct {
+	command string
+	cmd     *exec.Cmd
+	output  *lockedBuffer
+	read    int
+	done    chan struct{}
+}
+
+// NewLocal creates a Local sandbox running commands in dir
+func NewLocal(dir string) *Local {
+	shell := "/bin/sh"
+	if path, err := exec.LookPath("bash"); err == nil {
+		shell = path
+	}
+	return &Local{
+		Dir:        dir,
+		Timeout:    DefaultTimeout,
+		Shell:      shell,
+		background: make(map[int]*backgroundCommand),
+	}
+}
+
+func (l *Local) command(ctx context.Context, command string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, l.Shell, "-c", command)
+	cmd.Dir = l.Dir
+	cmd.Env = append(os.Environ(), l.Env...)
+	setProcessGroup(cmd)
+	// Kill the whole process group, so children started by the shell don't
+	// outlive the command or hold its output open.
+	cmd.Cancel = func() error { return killProcessGroup(cmd) }
+	cmd.WaitDelay = time.Second
+	return cmd
+}
+
+// RunCommand runs a command, waiting for it to finish unless background is
+// set. Background commands get an id to kill them by, and their output is
+// collected by PollBackground.
+func (l *Local) RunCommand(command string, background bool) (observation.Observation, error) {
+	if background {
+		return l.startBackground(command)
+	}
+	if l.Session != nil {
+		exitCode, output := l.Session.Execute(command)
+		return observation.NewCmdOutputObservation(output, -1, command, exitCode), nil
+	}
+
+	ctx := context.Background()
+	if l.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.Timeout)
+		defer cancel()
+	}
+
+	var output bytes.Buffer
+	cmd := l.command(ctx, command)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// The command exited cleanly but left a child holding its output
+		// open, e.g. a server started with "&".
+		err = nil
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		fmt.Fprintf(&output, "\n[Command timed out after %s]", l.Timeout)
+		return observation.NewCmdOutputObservation(output.String(), -1, command, TimeoutExitCode), nil
+	}
+
+	exitCode, err := exitCode(err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run command: %v", err)
+	}
+	return observation.NewCmdOutputObservation(output.String(), -1, command, exitCode), nil
+}
+
+func (l *Local) startBackground(command string) (observation.Observation, error) {
+	output := &lockedBuffer{}
+	cmd := l.command(context.Background(), command)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start command: %v", err)
+	}
+
+	bg := &backgroundCommand{command: command, cmd: cmd, output: output, done: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(bg.done)
+	}()
+
+	l.mu.Lock()
+	l.nextID++
+	id := l.nextID
+	l.background[id] = bg
+	l.mu.Unlock()
+
+	content := fmt.Sprintf("Background command %d started.", id)
+	return observation.NewCmdOutputObservation(content, id, command, 0), nil
+}
+
+// KillCommand kills a background command and returns the output it
+// produced since it was last polled
+func (l *Local) KillCommand(id int) (observation.Observation, error) {
+	l.mu.Lock()
+	bg, ok := l.background[id]
+	delete(l.background, id)
+	l.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no background command with id %d", id)
+	}
+
+	select {
+	case <-bg.done:
+	default:
+		killProcessGroup(bg.cmd)
+		<-bg.done
+	}
+
+	output := bg.unread()
+	content := fmt.Sprintf("Background command %d killed.", id)
+	if output != "" {
+		content = output + "\n" + content
+	}
+	return observation.NewCmdOutputObservation(content, id, bg.command, bg.cmd.ProcessState.ExitCode()), nil
+}
+
+// PollBackground returns the new output of each background command since
+// the last poll. Commands that have exited are reported one last time with
+// their exit code and then forgotten.
+func (l *Local) PollBackground() []observation.CmdOutputObservation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var polled []observation.CmdOutputObservation
+	for _, id := range sortedIDs(l.background) {
+		bg := l.background[id]
+
+		exited := false
+		select {
+		case <-bg.done:
+			exited = true
+			delete(l.background, id)
+		default:
+		}
+
+		output := bg.unread()
+		if exited {
+			exitCode := bg.cmd.ProcessState.ExitCode()
+			output += fmt.Sprintf("\n[Background command %d exited with code %d]", id, exitCode)
+			polled = append(polled, *observation.NewCmdOutputObservation(output, id, bg.command, exitCode))
+		} else if output != "" {
+			polled = append(polled, *observation.NewCmdOutputObservation(output, id, bg.command, 0))
+		}
+	}
+	return polled
+}
+
+// Close kills all background commands and ends the session, if any
+func (l *Local) Close() error {
+	l.mu.Lock()
+	ids := sortedIDs(l.background)
+	l.mu.Unlock()
+
+	for _, id := range ids {
+		l.KillCommand(id)
+	}
+	if session, ok := l.Session.(io.Closer); ok {
+		session.Close()
+	}
+	return nil
+}
+
+// Execute runs a command in the foreground
+func (l *Local) Execute(cmd string) (int, string) {
+	obs, err := l.RunCommand(cmd, false)
+	if err != nil {
+		return -1, err.Error()
+	}
+	out := obs.(*observation.CmdOutputObservation)
+	return out.ExitCode, out.Content
+}
+
+// CopyTo copies a file or directory from the host into the working
+// directory. A directory's contents are copied into sandboxDest.
+func (l *Local) CopyTo(hostSrc, sandboxDest string, recursive bool) error {
+	if !filepath.IsAbs(sandboxDest) {
+		sandboxDest = filepath.Join(l.Dir, sandboxDest)
+	}
+	return copyLocal(hostSrc, sandboxDest, recursive)
+}
+
+// copyLocal copies src to the directory dest, creating it if needed
+func copyLocal(src, dest string, recursive bool) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		if !recursive {
+			return fmt.Errorf("%s is a directory", src)
+		}
+		return runCopy("cp", "-R", src+"/.", dest)
+	}
+	return runCopy("cp", src, filepath.Join(dest, filepath.Base(src)))
+}
+
+// runCopy runs a copy command, including its output in any error
+func runCopy(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("%s failed: %v: %s", name, err, msg)
+		}
+		return fmt.Errorf("%s failed: %v", name, err)
+	}
+	return nil
+}
+
+// unread returns the output written since the last call
+func (bg *backgroundCommand) unread() string {
+	output := bg.output.String()
+	unread := output[bg.read:]
+	bg.read = len(output)
+	return unread
+}
+
+// exitCode returns the exit code of a finished command, or an error if it
+// could not be run at all
+func exitCode(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return -1, err
+}
+
+func sortedIDs(commands map[int]*backgroundCommand) []int {
+	ids := make([]int, 0, len(commands))
+	for id := range commands {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// lockedBuffer is a bytes.Buffer safe for a process to write to while the
+// output is being read
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(p []byte) (int, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.Write(p)
+}
+
+func (lb *lockedBuffer) String() string {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.String()
+}
@@ -0,0 +1,196 @@
+//go:build unix
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openagentsinc/autodev/pkg/observation"
+)
+
+func runLocal(t *testing.T, l *Local, command string, background bool) *observation.CmdOutputObservation {
+	t.Helper()
+	obs, err := l.RunCommand(command, background)
+	if err != nil {
+		t.Fatalf("RunCommand(%q) error = %v", command, err)
+	}
+	return obs.(*observation.CmdOutputObservation)
+}
+
+func TestLocalRunCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "f.txt"), []byte("content\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l := NewLocal(dir)
+	l.Env = []string{"GREETING=hello"}
+	defer l.Close()
+
+	tests := []struct {
+		command  string
+		exitCode int
+		output   string
+	}{
+		{command: "echo hi", output: "hi\n"},
+		{command: "cat f.txt", output: "content\n"},
+		{command: "echo $GREETING", output: "hello\n"},
+		{command: "echo out; echo err >&2", output: "out\nerr\n"},
+		{command: "exit 3", exitCode: 3},
+		{command: "cat missing.txt", exitCode: 1, output: "missing.txt"},
+		// A child left running in the background does not hold up the command
+		{command: "sleep 30 & echo started", output: "started\n"},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		obs := runLocal(t, l, tt.command, false)
+		if obs.ExitCode != tt.exitCode || !strings.Contains(obs.Content, tt.output) {
+			t.Errorf("RunCommand(%q) = %d, %q, want %d, %q", tt.command, obs.ExitCode, obs.Content, tt.exitCode, tt.output)
+		}
+		if tt.output == "" && obs.Content != "" {
+			t.Errorf("RunCommand(%q) output = %q, want none", tt.command, obs.Content)
+		}
+		if obs.CommandID != -1 || obs.Command != tt.command {
+			t.Errorf("RunCommand(%q) = command %d %q, want a foreground command", tt.command, obs.CommandID, obs.Command)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Errorf("RunCommand(%q) took %s", tt.command, elapsed)
+		}
+	}
+}
+
+func TestLocalTimeout(t *testing.T) {
+	l := NewLocal(t.TempDir())
+	l.Timeout = 200 * time.Millisecond
+	defer l.Close()
+
+	start := time.Now()
+	obs := runLocal(t, l, "echo before; sh -c 'sleep 30'; echo after", false)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timed out command took %s", elapsed)
+	}
+	if obs.ExitCode != TimeoutExitCode {
+		t.Errorf("exit code = %d, want %d", obs.ExitCode, TimeoutExitCode)
+	}
+	if !strings.HasPrefix(obs.Content, "before\n") || strings.Contains(obs.Content, "\nafter") ||
+		!strings.Contains(obs.Content, "[Command timed out after 200ms]") {
+		t.Errorf("output = %q, want the output before the timeout and a note", obs.Content)
+	}
+}
+
+// pollUntilExit polls background commands until n of them exit, returning
+// the output of each across polls and the last observation of each
+func pollUntilExit(t *testing.T, l *Local, n int) (map[int]string, map[int]observation.CmdOutputObservation) {
+	t.Helper()
+	outputs := make(map[int]string)
+	last := make(map[int]observation.CmdOutputObservation)
+	deadline := time.Now().Add(10 * time.Second)
+	for len(last) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d background commands exited", len(last), n)
+		}
+		for _, obs := range l.PollBackground() {
+			outputs[obs.CommandID] += obs.Content
+			if strings.Contains(obs.Content, "exited with code") {
+				last[obs.CommandID] = obs
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return outputs, last
+}
+
+func TestLocalBackground(t *testing.T) {
+	l := NewLocal(t.TempDir())
+	defer l.Close()
+
+	first := runLocal(t, l, "echo one; sleep 0.2; echo two; exit 4", true)
+	second := runLocal(t, l, "echo other", true)
+	if first.CommandID != 1 || second.CommandID != 2 {
+		t.Fatalf("command ids = %d, %d, want 1, 2", first.CommandID, second.CommandID)
+	}
+	if first.Content != "Background command 1 started." {
+		t.Errorf("start output = %q", first.Content)
+	}
+
+	outputs, last := pollUntilExit(t, l, 2)
+	if want := "one\ntwo\n\n[Background command 1 exited with code 4]"; outputs[1] != want {
+		t.Errorf("polled output = %q, want %q", outputs[1], want)
+	}
+	if want := "other\n\n[Background command 2 exited with code 0]"; outputs[2] != want {
+		t.Errorf("polled output = %q, want %q", outputs[2], want)
+	}
+	if last[1].ExitCode != 4 || last[1].Command != "echo one; sleep 0.2; echo two; exit 4" {
+		t.Errorf("last poll = %d, %q", last[1].ExitCode, last[1].Command)
+	}
+
+	// Exited commands are forgotten after their last poll
+	if polled := l.PollBackground(); len(polled) != 0 {
+		t.Errorf("PollBackground() = %v after every command exited", polled)
+	}
+	if _, err := l.KillCommand(1); err == nil {
+		t.Errorf("want an error killing a command that exited")
+	}
+}
+
+func TestLocalKillCommand(t *testing.T) {
+	l := NewLocal(t.TempDir())
+	defer l.Close()
+
+	// The child sleep must be killed along with the shell
+	started := runLocal(t, l, "echo ready; sh -c 'sleep 30'", true)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		polled := l.PollBackground()
+		if len(polled) > 0 && polled[0].Content == "ready\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background command did not start")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	start := time.Now()
+	obs, err := l.KillCommand(started.CommandID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("KillCommand() took %s", elapsed)
+	}
+	killed := obs.(*observation.CmdOutputObservation)
+	if killed.Content != "Background command 1 killed." || killed.CommandID != 1 {
+		t.Errorf("KillCommand() = %d, %q", killed.CommandID, killed.Content)
+	}
+
+	if polled := l.PollBackground(); len(polled) != 0 {
+		t.Errorf("PollBackground() = %v after the command was killed", polled)
+	}
+	if _, err := l.KillCommand(started.CommandID); err == nil {
+		t.Errorf("want an error killing a command twice")
+	}
+}
+
+func TestLocalClose(t *testing.T) {
+	l := NewLocal(t.TempDir())
+	runLocal(t, l, "sleep 30", true)
+	runLocal(t, l, "sleep 30", true)
+
+	done := make(chan struct{})
+	go func() {
+		l.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Close() did not kill the background commands")
+	}
+	if polled := l.PollBackground(); len(polled) != 0 {
+		t.Errorf("PollBackground() = %v after Close()", polled)
+	}
+}
@@ -0,0 +1,353 @@
+//go:build unix
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+)
+
+// InterruptExitCode is reported for commands stopped with Ctrl-C
+const InterruptExitCode = 130
+
+// interruptGrace is how long to wait for a command to stop after Ctrl-C
+// before giving up on it
+const interruptGrace = 5 * time.Second
+
+// markerPrefix starts the sentinel line printed after each command
+const markerPrefix = "__AUTODEV_DONE_"
+
+// staleMarkers matches lines showing sentinels left over from interrupted
+// commands, or echoed back by programs that read them as input, along with
+// the newline each sentinel prints before its line
+var staleMarkers = regexp.MustCompile(`\n?[^\n]*` + markerPrefix + `[^\n]*\n?`)
+
+// shellEnv keeps the session's output free of prompts and escape sequences
+var shellEnv = []string{"TERM=dumb", "PS1=", "PS2=", "PROMPT_COMMAND="}
+
+// ErrSessionClosed is returned when the shell has exited
+var ErrSessionClosed = errors.New("shell session closed")
+
+// ShellSession is a long-lived bash process driven over a PTY. Commands run
+// one after another in the same shell, so the working directory, exported
+// variables and activated virtualenvs carry over between them. It
+// implements plugin.SandboxProtocol.
+type ShellSession struct {
+	// Timeout limits each command, after which it is interrupted
+	Timeout time.Duration
+
+	cmd *exec.Cmd
+	pty *os.File
+
+	run        sync.Mutex // serializes commands
+	next       int
+	mu         sync.Mutex // guards buf and err
+	buf        strings.Builder
+	err        error
+	notify     chan struct{}
+	interrupts chan struct{}
+}
+
+// NewShellSession starts a bash session in dir, with env added to the
+// environment
+func NewShellSession(dir string, env []string) (*ShellSession, error) {
+	cmd := exec.Command("bash", "--noprofile", "--norc")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(cmd.Env, shellEnv...)
+	return startShellSession(cmd)
+}
+
+// startShellSession starts a session running bash with cmd, which may run
+// it somewhere else, e.g. in a container
+func startShellSession(cmd *exec.Cmd) (*ShellSession, error) {
+	f, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: 50, Cols: 500})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start shell: %v", err)
+	}
+
+	s := &ShellSession{
+		Timeout:    DefaultTimeout,
+		cmd:        cmd,
+		pty:        f,
+		notify:     make(chan struct{}, 1),
+		interrupts: make(chan struct{}, 1),
+	}
+	go s.read()
+
+	// Stop the terminal echoing input back and keep the output free of
+	// prompts and escape sequences, so only command output is captured.
+	setup := "stty -echo; set +H; bind 'set enable-bracketed-paste off' 2>/dev/null; PS1=''; PS2=''"
+	if exitCode, output, err := s.Run(setup, 10*time.Second); err != nil || exitCode != 0 {
+		s.Close()
+		if err == nil {
+			err = fmt.Errorf("exit code %d: %s", exitCode, output)
+		}
+		return nil, fmt.Errorf("failed to set up shell: %v", err)
+	}
+
+	return s, nil
+}
+
+// read copies the PTY output into the buffer until the shell exits
+func (s *ShellSession) read() {
+	chunk := make([]byte, 4096)
+	for {
+		n, err := s.pty.Read(chunk)
+
+		s.mu.Lock()
+		s.buf.WriteString(strings.ReplaceAll(string(chunk[:n]), "\r\n", "\n"))
+		if err != nil {
+			s.err = err
+		}
+		s.mu.Unlock()
+
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Run runs a command in the session and returns its exit code and output.
+// A command still running after timeout is interrupted with Ctrl-C, and
+// one stopped with Interrupt returns InterruptExitCode too.
+func (s *ShellSession) Run(command string, timeout time.Duration) (int, string, error) {
+	s.run.Lock()
+	defer s.run.Unlock()
+
+	s.next++
+	marker := fmt.Sprintf("%s%d__", markerPrefix, s.next)
+	done := regexp.MustCompile(`\n?` + marker + `:(\d+)\n`)
+
+	// Drop output nobody waited for, such as from background jobs, and
+	// interrupts meant for earlier commands.
+	s.mu.Lock()
+	s.buf.Reset()
+	s.mu.Unlock()
+	select {
+	case <-s.interrupts:
+	default:
+	}
+
+	sentinel := fmt.Sprintf("printf '\\n%s:%%s\\n' \"$?\"\n", marker)
+	if _, err := io.WriteString(s.pty, command+"\n"+sentinel); err != nil {
+		return -1, "", fmt.Errorf("failed to write command: %v", err)
+	}
+
+	exitCode, output, err := s.wait(done, timeout)
+	if err == nil || err == ErrSessionClosed {
+		return exitCode, output, err
+	}
+	note := fmt.Sprintf("[Command timed out after %s and was interrupted]", timeout)
+	if err == errInterrupted {
+		note = "[Command was interrupted]"
+	} else if err := s.sendInterrupt(); err != nil {
+		return -1, output, err
+	}
+
+	// Ctrl-C discards input the command has not read yet, which may include
+	// the sentinel, so keep resending it until the shell answers.
+	var rest string
+	for start := time.Now(); time.Since(start) < interruptGrace; {
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(s.pty, sentinel)
+		if _, rest, err = s.wait(done, time.Second); err != errTimeout && err != errInterrupted {
+			break
+		}
+	}
+	if err == errTimeout || err == errInterrupted {
+		// The command ignores Ctrl-C, like an interactive interpreter does,
+		// so kill it instead.
+		if err = s.killForeground(); err == nil {
+			io.WriteString(s.pty, sentinel)
+			_, rest, err = s.wait(done, interruptGrace)
+		}
+	}
+	if err != nil {
+		return -1, rest, fmt.Errorf("command did not stop after interrupt: %v", err)
+	}
+
+	return InterruptExitCode, strings.TrimRight(rest, "\n") + "\n" + note, nil
+}
+
+var (
+	errTimeout     = errors.New("timed out")
+	errInterrupted = errors.New("interrupted")
+)
+
+// wait reads output until the sentinel matching done appears, the timeout
+// passes or the command is interrupted
+func (s *ShellSession) wait(done *regexp.Regexp, timeout time.Duration) (int, string, error) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		s.mu.Lock()
+		output := s.buf.String()
+		readErr := s.err
+		if m := done.FindStringSubmatchIndex(output); m != nil {
+			exitCode, _ := strconv.Atoi(output[m[2]:m[3]])
+			s.buf.Reset()
+			s.buf.WriteString(output[m[1]:])
+			s.mu.Unlock()
+			return exitCode, staleMarkers.ReplaceAllString(output[:m[0]], ""), nil
+		}
+		s.mu.Unlock()
+
+		if readErr != nil {
+			return -1, output, ErrSessionClosed
+		}
+
+		select {
+		case <-s.notify:
+		case <-s.interrupts:
+			return -1, staleMarkers.ReplaceAllString(output, ""), errInterrupted
+		case <-deadline:
+			return -1, staleMarkers.ReplaceAllString(output, ""), errTimeout
+		}
+	}
+}
+
+// Interrupt sends Ctrl-C to the command running in the session, whose Run
+// then returns once the command has stopped
+func (s *ShellSession) Interrupt() error {
+	if err := s.sendInterrupt(); err != nil {
+		return err
+	}
+	select {
+	case s.interrupts <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *ShellSession) sendInterrupt() error {
+	if _, err := s.pty.Write([]byte{0x03}); err != nil {
+		return fmt.Errorf("failed to send interrupt: %v", err)
+	}
+	return nil
+}
+
+// killForeground kills the process group in the foreground of the
+// terminal, unless that is the shell itself
+func (s *ShellSession) killForeground() error {
+	conn, err := s.pty.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var pgid int
+	var ioctlErr error
+	if err := conn.Control(func(fd uintptr) {
+		pgid, ioctlErr = unix.IoctlGetInt(int(fd), unix.TIOCGPGRP)
+	}); err != nil {
+		return err
+	}
+	if ioctlErr != nil {
+		return fmt.Errorf("failed to get foreground process group: %v", ioctlErr)
+	}
+	if pgid == s.cmd.Process.Pid {
+		return fmt.Errorf("no foreground command to kill")
+	}
+	return unix.Kill(-pgid, unix.SIGKILL)
+}
+
+// Execute runs a command with the session's timeout
+func (s *ShellSession) Execute(cmd string) (int, string) {
+	exitCode, output, err := s.Run(cmd, s.Timeout)
+	if err != nil {
+		return -1, strings.TrimSpace(output + "\n" + err.Error())
+	}
+	return exitCode, output
+}
+
+// CopyTo copies a file or directory from the host. The session runs on the
+// host too, so this is a local copy. A relative sandboxDest is resolved
+// against the session's current directory, and a directory's contents are
+// copied into sandboxDest.
+func (s *ShellSession) CopyTo(hostSrc, sandboxDest string, recursive bool) error {
+	if !filepath.IsAbs(sandboxDest) {
+		dir, err := s.dir()
+		if err != nil {
+			return err
+		}
+		sandboxDest = filepath.Join(dir, sandboxDest)
+	}
+	return copyLocal(hostSrc, sandboxDest, recursive)
+}
+
+// dir returns the session's current directory
+func (s *ShellSession) dir() (string, error) {
+	exitCode, output, err := s.Run("pwd", 10*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("failed to get shell directory: %v", err)
+	}
+	if exitCode != 0 {
+		return "", fmt.Errorf("failed to get shell directory: exit code %d: %s", exitCode, output)
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// Close ends the session
+func (s *ShellSession) Close() error {
+	s.pty.Close()
+	if s.cmd.Process != nil {
+		s.cmd.Process.Kill()
+	}
+	s.cmd.Wait()
+	return nil
+}
+
+// StartSession runs foreground commands in a ShellSession from now on, so
+// state such as the working directory and what plugin init scripts set up
+// carries over between them
+func (l *Local) StartSession() error {
+	session, err := NewShellSession(l.Dir, l.Env)
+	if err != nil {
+		return err
+	}
+	if l.Timeout > 0 {
+		session.Timeout = l.Timeout
+	}
+	l.Session = session
+	return nil
+}
+
+// StartSession runs foreground commands in a ShellSession in the container
+// from now on, like Local.StartSession
+func (c *Container) StartSession() error {
+	args := []string{"exec", "--interactive", "--tty", "--workdir", DefaultWorkspace}
+	for _, env := range shellEnv {
+		args = append(args, "--env", env)
+	}
+	args = append(args, c.name, "bash", "--noprofile", "--norc")
+
+	session, err := startShellSession(exec.Command(c.runtime, args...))
+	if err != nil {
+		return err
+	}
+	session.Timeout = c.config.Timeout
+	c.Session = session
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package sandbox
+
+import "fmt"
+
+// StartSession fails, since shell sessions need a Unix PTY
+func (l *Local) StartSession() error {
+	return fmt.Errorf("shell sessions are not supported on this platform")
+}
+
+// StartSession fails, since shell sessions need a Unix PTY
+func (c *Container) StartSession() error {
+	return fmt.Errorf("shell sessions are not supported on this platform")
+}
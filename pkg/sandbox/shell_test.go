@@ -0,0 +1,244 @@
+//go:build unix
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openagentsinc/autodev/pkg/observation"
+	"github.com/openagentsinc/autodev/pkg/plugin"
+)
+
+func TestShellSessionCopyTo(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	host := t.TempDir()
+	src := filepath.Join(host, "plugin.sh")
+	if err := os.WriteFile(src, []byte("echo hi\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewShellSession(dir, nil)
+	if err != nil {
+		t.Skipf("no shell: %v", err)
+	}
+	defer s.Close()
+
+	if _, _, err := s.Run("cd sub", 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// A relative destination follows the shell, not the process
+	if err := s.CopyTo(src, "plugins", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub", "plugins", "plugin.sh")); err != nil {
+		t.Errorf("file was not copied into the shell's directory: %v", err)
+	}
+
+	if err := s.CopyTo(filepath.Join(host, "missing"), "plugins", false); err == nil {
+		t.Errorf("want an error copying a missing file")
+	}
+	if err := s.CopyTo(host, filepath.Join(dir, "copy"), false); err == nil {
+		t.Errorf("want an error copying a directory without recursive")
+	}
+	if err := s.CopyTo(host, filepath.Join(dir, "copy"), true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "copy", "plugin.sh")); err != nil {
+		t.Errorf("directory contents were not copied: %v", err)
+	}
+}
+
+// newTestSession starts a session in a new directory, skipping the test
+// where bash cannot run
+func newTestSession(t *testing.T) (*ShellSession, string) {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := NewShellSession(dir, []string{"HOME=" + dir})
+	if err != nil {
+		t.Skipf("no shell: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, dir
+}
+
+func TestShellSessionRun(t *testing.T) {
+	s, _ := newTestSession(t)
+
+	tests := []struct {
+		command  string
+		exitCode int
+		output   string
+	}{
+		{command: "echo hello", output: "hello\n"},
+		{command: "printf 'no newline'", output: "no newline"},
+		{command: "echo one; echo two >&2", output: "one\ntwo\n"},
+		{command: "false", exitCode: 1},
+		{command: "(exit 7)", exitCode: 7},
+		{command: "echo '" + markerPrefix + "1__:0'; echo after", output: "after\n"},
+		{command: "for i in 1 2 3; do\n  echo $i\ndone", output: "1\n2\n3\n"},
+	}
+	for _, tt := range tests {
+		exitCode, output, err := s.Run(tt.command, 10*time.Second)
+		if err != nil {
+			t.Errorf("Run(%q) error = %v", tt.command, err)
+			continue
+		}
+		if exitCode != tt.exitCode || output != tt.output {
+			t.Errorf("Run(%q) = %d, %q, want %d, %q", tt.command, exitCode, output, tt.exitCode, tt.output)
+		}
+	}
+}
+
+func TestShellSessionState(t *testing.T) {
+	s, dir := newTestSession(t)
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		command string
+		output  string
+	}{
+		{command: "cd sub"},
+		{command: "pwd", output: filepath.Join(dir, "sub") + "\n"},
+		{command: "export GREETING=hi"},
+		{command: "greet() { echo \"$GREETING $1\"; }"},
+		{command: "greet there", output: "hi there\n"},
+	}
+	for _, step := range steps {
+		exitCode, output, err := s.Run(step.command, 10*time.Second)
+		if err != nil || exitCode != 0 {
+			t.Fatalf("Run(%q) = %d, %q, %v", step.command, exitCode, output, err)
+		}
+		// The directory may be reported through a symlink, e.g. on macOS
+		if step.command == "pwd" {
+			output, _ = filepath.EvalSymlinks(strings.TrimSpace(output))
+			want, _ := filepath.EvalSymlinks(filepath.Join(dir, "sub"))
+			if output != want {
+				t.Errorf("pwd = %q, want %q", output, want)
+			}
+			continue
+		}
+		if output != step.output {
+			t.Errorf("Run(%q) output = %q, want %q", step.command, output, step.output)
+		}
+	}
+}
+
+func TestShellSessionInterrupt(t *testing.T) {
+	s, _ := newTestSession(t)
+
+	type result struct {
+		exitCode int
+		output   string
+		err      error
+	}
+	done := make(chan result)
+	go func() {
+		exitCode, output, err := s.Run("echo started; sleep 30", time.Minute)
+		done <- result{exitCode, output, err}
+	}()
+
+	// Interrupt once the command is running
+	time.Sleep(500 * time.Millisecond)
+	if err := s.Interrupt(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-done:
+		if r.err != nil || r.exitCode != InterruptExitCode || r.output != "started\n[Command was interrupted]" {
+			t.Errorf("interrupted Run() = %d, %q, %v, want exit code %d", r.exitCode, r.output, r.err, InterruptExitCode)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("command did not stop after Ctrl-C")
+	}
+
+	if exitCode, output, err := s.Run("echo still here", 10*time.Second); err != nil || exitCode != 0 || output != "still here\n" {
+		t.Errorf("Run() after interrupt = %d, %q, %v", exitCode, output, err)
+	}
+}
+
+func TestShellSessionTimeout(t *testing.T) {
+	s, _ := newTestSession(t)
+
+	tests := []struct {
+		name    string
+		command string
+	}{
+		{name: "interrupted", command: "sleep 30"},
+		{name: "ignoring Ctrl-C is killed", command: "bash -c \"trap '' INT; sleep 30\""},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		exitCode, output, err := s.Run(tt.command, 300*time.Millisecond)
+		if err != nil {
+			t.Errorf("%s: Run() error = %v", tt.name, err)
+			continue
+		}
+		if exitCode != InterruptExitCode || !strings.Contains(output, "timed out after 300ms") {
+			t.Errorf("%s: Run() = %d, %q, want a timeout", tt.name, exitCode, output)
+		}
+		if elapsed := time.Since(start); elapsed > 15*time.Second {
+			t.Errorf("%s: Run() took %s", tt.name, elapsed)
+		}
+		if exitCode, output, err := s.Run("echo ok", 10*time.Second); err != nil || output != "ok\n" {
+			t.Errorf("%s: Run() after the timeout = %d, %q, %v", tt.name, exitCode, output, err)
+		}
+	}
+}
+
+func TestShellSessionClosed(t *testing.T) {
+	s, _ := newTestSession(t)
+	if _, _, err := s.Run("exit", 10*time.Second); err != ErrSessionClosed {
+		t.Errorf("Run(exit) error = %v, want %v", err, ErrSessionClosed)
+	}
+	if exitCode, output := s.Execute("echo hi"); exitCode != -1 || output == "" {
+		t.Errorf("Execute() after exit = %d, %q, want an error", exitCode, output)
+	}
+}
+
+func TestLocalSessionPlugins(t *testing.T) {
+	dir := t.TempDir()
+	l := NewLocal(dir)
+	l.Env = []string{"HOME=" + dir}
+	if err := l.StartSession(); err != nil {
+		t.Skipf("no shell: %v", err)
+	}
+	defer l.Close()
+
+	// A plugin that sets up the shell through ~/.bashrc
+	host := t.TempDir()
+	script := "#!/bin/sh\necho 'export PLUGIN_READY=yes' >> \"$HOME/.bashrc\"\n"
+	if err := os.WriteFile(filepath.Join(host, "setup.sh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	plugins := plugin.PluginMixin{Sandbox: l}
+	err := plugins.InitPlugins([]plugin.PluginRequirement{
+		{Name: "test", HostSrc: host, SandboxDest: "plugins", BashScriptPath: "setup.sh"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range []struct{ command, output string }{
+		{command: "echo $PLUGIN_READY", output: "yes\n"},
+		{command: "cd plugins"},
+		{command: "ls", output: "setup.sh\n"},
+	} {
+		obs, err := l.RunCommand(step.command, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := obs.(*observation.CmdOutputObservation).Content; got != step.output {
+			t.Errorf("RunCommand(%q) = %q, want %q", step.command, got, step.output)
+		}
+	}
+}
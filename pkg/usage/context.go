@@ -0,0 +1,32 @@
+package usage
+
+import "context"
+
+type contextKey int
+
+const (
+	conversationKey contextKey = iota
+	taskKey
+)
+
+// WithConversation returns a context that attributes model calls to a conversation
+func WithConversation(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, conversationKey, id)
+}
+
+// WithTask returns a context that attributes model calls to a plan task
+func WithTask(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, taskKey, id)
+}
+
+// ConversationFromContext returns the conversation set on ctx, or ""
+func ConversationFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(conversationKey).(string)
+	return id
+}
+
+// TaskFromContext returns the plan task set on ctx, or ""
+func TaskFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(taskKey).(string)
+	return id
+}
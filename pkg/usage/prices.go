@@ -0,0 +1,86 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/openagentsinc/autodev/llm"
+)
+
+// Price is the cost of a model in USD per million tokens
+type Price struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheWrite float64 `json:"cache_write"`
+	CacheRead  float64 `json:"cache_read"`
+}
+
+// PriceTable maps model names, or model name prefixes, to prices
+type PriceTable map[string]Price
+
+// DefaultPrices returns the list prices of the hosted models we use.
+// Models that are not listed, such as local models, are free.
+func DefaultPrices() PriceTable {
+	return PriceTable{
+		"claude-3-5-sonnet": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+		"claude-3-5-haiku":  {Input: 0.80, Output: 4, CacheWrite: 1, CacheRead: 0.08},
+		"claude-3-opus":     {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50},
+		"claude-3-sonnet":   {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+		"claude-3-haiku":    {Input: 0.25, Output: 1.25, CacheWrite: 0.30, CacheRead: 0.03},
+		"gpt-4o-mini":       {Input: 0.15, Output: 0.60, CacheRead: 0.075},
+		"gpt-4o":            {Input: 2.50, Output: 10, CacheRead: 1.25},
+	}
+}
+
+// LoadPrices reads a JSON price table from a file and merges it over the
+// default prices
+func LoadPrices(path string) (PriceTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read price table: %v", err)
+	}
+
+	var overrides PriceTable
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse price table: %v", err)
+	}
+
+	prices := DefaultPrices()
+	for model, price := range overrides {
+		prices[model] = price
+	}
+	return prices, nil
+}
+
+// Lookup returns the price for a model, matching the exact name first and
+// then the longest prefix, so "claude-3-5-sonnet" prices every snapshot
+func (pt PriceTable) Lookup(model string) (Price, bool) {
+	if price, ok := pt[model]; ok {
+		return price, true
+	}
+
+	best := ""
+	for prefix := range pt {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return Price{}, false
+	}
+	return pt[best], true
+}
+
+// Cost returns the cost in USD of a model call
+func (pt PriceTable) Cost(model string, u llm.Usage) float64 {
+	price, ok := pt.Lookup(model)
+	if !ok {
+		return 0
+	}
+	return (float64(u.InputTokens)*price.Input +
+		float64(u.OutputTokens)*price.Output +
+		float64(u.CacheCreationInputTokens)*price.CacheWrite +
+		float64(u.CacheReadInputTokens)*price.CacheRead) / 1e6
+}
@@ -0,0 +1,79 @@
+package usage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openagentsinc/autodev/llm"
+)
+
+func TestLookup(t *testing.T) {
+	prices := PriceTable{
+		"claude-3":                   {Input: 1},
+		"claude-3-5-sonnet":          {Input: 2},
+		"claude-3-5-sonnet-20241022": {Input: 3},
+	}
+	tests := []struct {
+		model string
+		input float64
+		ok    bool
+	}{
+		{model: "claude-3-5-sonnet-20241022", input: 3, ok: true},
+		{model: "claude-3-5-sonnet-20240620", input: 2, ok: true},
+		{model: "claude-3-opus-20240229", input: 1, ok: true},
+		{model: "llama3", ok: false},
+	}
+	for _, tt := range tests {
+		price, ok := prices.Lookup(tt.model)
+		if ok != tt.ok || price.Input != tt.input {
+			t.Errorf("Lookup(%q) = %v, %v, want input %v, %v", tt.model, price, ok, tt.input, tt.ok)
+		}
+	}
+}
+
+func TestCost(t *testing.T) {
+	prices := PriceTable{"model": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30}}
+	u := llm.Usage{InputTokens: 1000, OutputTokens: 100, CacheCreationInputTokens: 2000, CacheReadInputTokens: 10000}
+
+	want := (1000*3 + 100*15 + 2000*3.75 + 10000*0.30) / 1e6
+	if got := prices.Cost("model-20240101", u); got != want {
+		t.Errorf("Cost() = %v, want %v", got, want)
+	}
+	if got := prices.Cost("local", u); got != 0 {
+		t.Errorf("Cost() = %v for an unpriced model, want 0", got)
+	}
+}
+
+func TestLoadPrices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.json")
+	data := `{"gpt-4o": {"input": 1, "output": 2}, "llama3": {"input": 0.1}}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	prices, err := LoadPrices(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := prices["gpt-4o"]; got != (Price{Input: 1, Output: 2}) {
+		t.Errorf("gpt-4o = %+v, want the override", got)
+	}
+	if got := prices["llama3"]; got != (Price{Input: 0.1}) {
+		t.Errorf("llama3 = %+v, want the added price", got)
+	}
+	if got := prices["claude-3-5-sonnet"]; got != DefaultPrices()["claude-3-5-sonnet"] {
+		t.Errorf("claude-3-5-sonnet = %+v, want the default price", got)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPrices(path); err == nil || !strings.Contains(err.Error(), "failed to parse") {
+		t.Errorf("LoadPrices() error = %v, want a parse error", err)
+	}
+	if _, err := LoadPrices(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("LoadPrices() of a missing file succeeded")
+	}
+}
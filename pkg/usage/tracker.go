@@ -0,0 +1,126 @@
+package usage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/openagentsinc/autodev/llm"
+)
+
+// Totals is the aggregated usage and cost of a set of model calls
+type Totals struct {
+	Requests                 int     `json:"requests"`
+	InputTokens              int     `json:"input_tokens"`
+	OutputTokens             int     `json:"output_tokens"`
+	CacheCreationInputTokens int     `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int     `json:"cache_read_input_tokens"`
+	Cost                     float64 `json:"cost_usd"`
+}
+
+func (t *Totals) add(u llm.Usage, cost float64) {
+	t.Requests++
+	t.InputTokens += u.InputTokens
+	t.OutputTokens += u.OutputTokens
+	t.CacheCreationInputTokens += u.CacheCreationInputTokens
+	t.CacheReadInputTokens += u.CacheReadInputTokens
+	t.Cost += cost
+}
+
+// TotalTokens returns the number of input, cache and output tokens
+func (t Totals) TotalTokens() int {
+	return t.InputTokens + t.OutputTokens + t.CacheCreationInputTokens + t.CacheReadInputTokens
+}
+
+// Report is a snapshot of the usage recorded by a Tracker
+type Report struct {
+	Session       Totals            `json:"session"`
+	Conversations map[string]Totals `json:"conversations"`
+	Tasks         map[string]Totals `json:"tasks"`
+	Models        map[string]Totals `json:"models"`
+}
+
+// Tracker aggregates token usage and cost per conversation, per plan task,
+// per model and for the whole session. It is safe for concurrent use.
+type Tracker struct {
+	mu            sync.Mutex
+	prices        PriceTable
+	session       Totals
+	conversations map[string]*Totals
+	tasks         map[string]*Totals
+	models        map[string]*Totals
+}
+
+// NewTracker creates a new Tracker using the given price table
+func NewTracker(prices PriceTable) *Tracker {
+	return &Tracker{
+		prices:        prices,
+		conversations: make(map[string]*Totals),
+		tasks:         make(map[string]*Totals),
+		models:        make(map[string]*Totals),
+	}
+}
+
+// Record adds the usage of a model call. The call is attributed to the
+// conversation and task set on ctx, if any. It returns the cost of the call.
+func (t *Tracker) Record(ctx context.Context, model string, u llm.Usage) float64 {
+	cost := t.prices.Cost(model, u)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.session.add(u, cost)
+	bucket(t.models, model).add(u, cost)
+	if id := ConversationFromContext(ctx); id != "" {
+		bucket(t.conversations, id).add(u, cost)
+	}
+	if id := TaskFromContext(ctx); id != "" {
+		bucket(t.tasks, id).add(u, cost)
+	}
+	return cost
+}
+
+// Session returns the totals for the whole session
+func (t *Tracker) Session() Totals {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.session
+}
+
+// Task returns the totals for a plan task
+func (t *Tracker) Task(id string) Totals {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if totals, ok := t.tasks[id]; ok {
+		return *totals
+	}
+	return Totals{}
+}
+
+// Report returns a snapshot of all recorded usage
+func (t *Tracker) Report() Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return Report{
+		Session:       t.session,
+		Conversations: snapshot(t.conversations),
+		Tasks:         snapshot(t.tasks),
+		Models:        snapshot(t.models),
+	}
+}
+
+func bucket(m map[string]*Totals, key string) *Totals {
+	totals, ok := m[key]
+	if !ok {
+		totals = &Totals{}
+		m[key] = totals
+	}
+	return totals
+}
+
+func snapshot(m map[string]*Totals) map[string]Totals {
+	result := make(map[string]Totals, len(m))
+	for key, totals := range m {
+		result[key] = *totals
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+package usage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/openagentsinc/autodev/llm"
+)
+
+func TestRecord(t *testing.T) {
+	tracker := NewTracker(PriceTable{"priced": {Input: 1, Output: 2}})
+	ctx := context.Background()
+	conversation := WithConversation(ctx, "chat")
+	task := WithTask(conversation, "0.1")
+
+	calls := []struct {
+		ctx   context.Context
+		model string
+		usage llm.Usage
+		cost  float64
+	}{
+		{ctx: ctx, model: "priced", usage: llm.Usage{InputTokens: 10, OutputTokens: 5}, cost: 20e-6},
+		{ctx: conversation, model: "priced", usage: llm.Usage{InputTokens: 4, CacheReadInputTokens: 6}, cost: 4e-6},
+		{ctx: task, model: "local", usage: llm.Usage{InputTokens: 7, OutputTokens: 3, CacheCreationInputTokens: 1}},
+	}
+	for _, call := range calls {
+		if got := tracker.Record(call.ctx, call.model, call.usage); got != call.cost {
+			t.Errorf("Record(%s, %+v) = %v, want %v", call.model, call.usage, got, call.cost)
+		}
+	}
+
+	report := tracker.Report()
+	want := Report{
+		Session: Totals{Requests: 3, InputTokens: 21, OutputTokens: 8, CacheCreationInputTokens: 1, CacheReadInputTokens: 6, Cost: 24e-6},
+		Conversations: map[string]Totals{
+			"chat": {Requests: 2, InputTokens: 11, OutputTokens: 3, CacheCreationInputTokens: 1, CacheReadInputTokens: 6, Cost: 4e-6},
+		},
+		Tasks: map[string]Totals{
+			"0.1": {Requests: 1, InputTokens: 7, OutputTokens: 3, CacheCreationInputTokens: 1},
+		},
+		Models: map[string]Totals{
+			"priced": {Requests: 2, InputTokens: 14, OutputTokens: 5, CacheReadInputTokens: 6, Cost: 24e-6},
+			"local":  {Requests: 1, InputTokens: 7, OutputTokens: 3, CacheCreationInputTokens: 1},
+		},
+	}
+	if report.Session != want.Session {
+		t.Errorf("Session = %+v, want %+v", report.Session, want.Session)
+	}
+	for name, buckets := range map[string][2]map[string]Totals{
+		"Conversations": {report.Conversations, want.Conversations},
+		"Tasks":         {report.Tasks, want.Tasks},
+		"Models":        {report.Models, want.Models},
+	} {
+		if len(buckets[0]) != len(buckets[1]) {
+			t.Errorf("%s = %+v, want %+v", name, buckets[0], buckets[1])
+		}
+		for key, totals := range buckets[1] {
+			if buckets[0][key] != totals {
+				t.Errorf("%s[%s] = %+v, want %+v", name, key, buckets[0][key], totals)
+			}
+		}
+	}
+
+	if got := tracker.Task("0.1"); got != want.Tasks["0.1"] {
+		t.Errorf("Task(0.1) = %+v, want %+v", got, want.Tasks["0.1"])
+	}
+	if got := tracker.Task("0.2"); got != (Totals{}) {
+		t.Errorf("Task(0.2) = %+v, want nothing", got)
+	}
+	if got := report.Session.TotalTokens(); got != 36 {
+		t.Errorf("TotalTokens() = %d, want 36", got)
+	}
+}
+
+func TestReportIsSnapshot(t *testing.T) {
+	tracker := NewTracker(nil)
+	ctx := WithConversation(context.Background(), "chat")
+	tracker.Record(ctx, "model", llm.Usage{InputTokens: 1})
+
+	report := tracker.Report()
+	tracker.Record(ctx, "model", llm.Usage{InputTokens: 1})
+	if got := report.Conversations["chat"].InputTokens; got != 1 {
+		t.Errorf("earlier report changed to %d input tokens", got)
+	}
+}
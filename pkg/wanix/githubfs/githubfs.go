@@ -34,6 +34,8 @@ type FS struct {
 
 	branches        map[string]Tree
 	branchesExpired bool
+
+	client *http.Client
 }
 
 func New(owner, repoName, accessToken string) *FS {
@@ -43,9 +45,16 @@ func New(owner, repoName, accessToken string) *FS {
 		token:           accessToken,
 		branches:        make(map[string]Tree),
 		branchesExpired: true,
+		client:          http.DefaultClient,
 	}
 }
 
+// SetHTTPClient sets the client used for GitHub API requests, e.g. to record
+// or replay them in tests
+func (g *FS) SetHTTPClient(client *http.Client) {
+	g.client = client
+}
+
 type Tree struct {
 	Expired bool `json:"-"`
 
@@ -94,7 +103,7 @@ func (g *FS) apiRequest(method, url, acceptHeader string, body io.Reader) (*http
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", g.token))
 	req.Header.Add("X-GitHub-Api-Version", "2022-11-28")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := g.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -313,6 +322,20 @@ func (g *FS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error)
 	return &f, nil
 }
 
+// WriteFile writes data to the named file, creating it if necessary, and
+// commits the change to its branch. Together with Open this makes a branch
+// usable as an agent workspace, see workspace.Sub.
+func (g *FS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f, err := g.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.(*file).Write(data); err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	return f.Close()
+}
+
 func (g *FS) Remove(name string) error {
 	if !fs.ValidPath(name) {
 		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
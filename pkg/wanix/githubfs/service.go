@@ -39,6 +39,11 @@ func NewGitHubFSService(repo string) (*GitHubFSService, error) {
 	}, nil
 }
 
+// SetHTTPClient sets the client used for all GitHub API requests
+func (s *GitHubFSService) SetHTTPClient(client *http.Client) {
+	s.fs.SetHTTPClient(client)
+}
+
 func (s *GitHubFSService) GetBranches() ([]string, error) {
 	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/branches", s.owner, s.repo)
 	req, err := http.NewRequest("GET", url, nil)
@@ -49,8 +54,7 @@ func (s *GitHubFSService) GetBranches() ([]string, error) {
 	req.Header.Set("Authorization", "token "+s.token)
 	req.Header.Set("Accept", "application/vnd.github.v3+json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := s.fs.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,75 @@
+package workspace
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Dir returns a workspace backed by a local directory
+func Dir(root string) FS {
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	return &dirFS{root: root}
+}
+
+type dirFS struct {
+	root string
+}
+
+func (d *dirFS) Root() string {
+	return filepath.ToSlash(d.root)
+}
+
+// path returns the host path for a name, refusing names that leave the
+// directory, including through symlinks
+func (d *dirFS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	full := filepath.Join(d.root, filepath.FromSlash(name))
+
+	// Check the deepest existing ancestor, since the file itself may not
+	// exist yet.
+	existing := full
+	for {
+		if _, err := os.Lstat(existing); err == nil || existing == d.root {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	root, err := filepath.EvalSymlinks(d.root)
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return "", &fs.PathError{Op: op, Path: name, Err: ErrEscape}
+	}
+
+	return full, nil
+}
+
+func (d *dirFS) Open(name string) (fs.File, error) {
+	full, err := d.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(full)
+}
+
+func (d *dirFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	full, err := d.path("write", name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(full, data, perm)
+}
@@ -0,0 +1,99 @@
+package workspace
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/openagentsinc/autodev/pkg/plugin"
+)
+
+// writeChunkSize is the most file content sent in a single command
+const writeChunkSize = 32 * 1024
+
+// Sandbox returns a workspace backed by files inside a sandbox, rooted at
+// the absolute path root within it. Files are read and written by running
+// commands, so this works with any sandbox.
+func Sandbox(sb plugin.SandboxProtocol, root string) FS {
+	return &sandboxFS{sb: sb, root: path.Clean(root)}
+}
+
+type sandboxFS struct {
+	sb   plugin.SandboxProtocol
+	root string
+}
+
+func (s *sandboxFS) Root() string {
+	return s.root
+}
+
+func (s *sandboxFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	full := path.Join(s.root, name)
+	exitCode, output := s.sb.Execute(fmt.Sprintf("test -f %s && base64 < %s", quote(full), quote(full)))
+	if exitCode != 0 {
+		if strings.TrimSpace(output) == "" {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fmt.Errorf("%s", strings.TrimSpace(output))}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(output), ""))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &memFile{Reader: bytes.NewReader(data), name: path.Base(name), size: int64(len(data))}, nil
+}
+
+func (s *sandboxFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+
+	// Send the content in chunks, keeping each command well within the
+	// argument and line length limits of the sandbox.
+	full := path.Join(s.root, name)
+	temp := full + ".autodev-tmp"
+	commands := []string{fmt.Sprintf("mkdir -p %s && : > %s", quote(path.Dir(full)), quote(temp))}
+	for start := 0; start < len(data); start += writeChunkSize {
+		chunk := data[start:min(start+writeChunkSize, len(data))]
+		commands = append(commands, fmt.Sprintf("echo %s | base64 -d >> %s", base64.StdEncoding.EncodeToString(chunk), quote(temp)))
+	}
+	commands = append(commands, fmt.Sprintf("chmod %o %s && mv -f %s %s", perm.Perm(), quote(temp), quote(temp), quote(full)))
+
+	for _, command := range commands {
+		if exitCode, output := s.sb.Execute(command); exitCode != 0 {
+			s.sb.Execute("rm -f " + quote(temp))
+			return &fs.PathError{Op: "write", Path: name, Err: fmt.Errorf("exit code %d: %s", exitCode, strings.TrimSpace(output))}
+		}
+	}
+	return nil
+}
+
+// quote quotes a string for the shell
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// memFile is a regular file read from memory
+type memFile struct {
+	*bytes.Reader
+	name string
+	size int64
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f, nil }
+func (f *memFile) Close() error               { return nil }
+func (f *memFile) Name() string               { return f.name }
+func (f *memFile) Size() int64                { return f.size }
+func (f *memFile) Mode() fs.FileMode          { return 0644 }
+func (f *memFile) ModTime() time.Time         { return time.Time{} }
+func (f *memFile) IsDir() bool                { return false }
+func (f *memFile) Sys() any                   { return nil }
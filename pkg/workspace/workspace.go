@@ -0,0 +1,202 @@
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// FS is the filesystem an agent works in. Names are slash-separated and
+// relative to the workspace root, as with fs.FS.
+type FS interface {
+	fs.FS
+
+	// WriteFile writes data to the named file, creating it and any missing
+	// parent directories if necessary
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+}
+
+// Rooted is implemented by workspaces that the agent also sees at an
+// absolute path, such as the directory its commands run in, so absolute
+// paths inside it can be used too
+type Rooted interface {
+	Root() string
+}
+
+// ErrEscape is returned for paths outside the workspace
+var ErrEscape = errors.New("path is outside the workspace")
+
+// Resolve turns a path given by the agent into a name in the workspace.
+// Relative paths are relative to the workspace root. Absolute paths must be
+// inside the root of a Rooted workspace.
+func Resolve(fsys FS, p string) (string, error) {
+	if p == "" {
+		return "", &fs.PathError{Op: "resolve", Path: p, Err: fs.ErrInvalid}
+	}
+
+	p = strings.ReplaceAll(p, "\\", "/")
+	if path.IsAbs(p) {
+		root := "/"
+		if r, ok := fsys.(Rooted); ok {
+			root = path.Clean(r.Root())
+		}
+		rel, ok := strings.CutPrefix(path.Clean(p), root)
+		if !ok || (rel != "" && root != "/" && !strings.HasPrefix(rel, "/")) {
+			return "", &fs.PathError{Op: "resolve", Path: p, Err: ErrEscape}
+		}
+		p = strings.TrimPrefix(rel, "/")
+	}
+
+	name := path.Clean(p)
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", &fs.PathError{Op: "resolve", Path: p, Err: ErrEscape}
+	}
+	if name == "" {
+		name = "."
+	}
+	return name, nil
+}
+
+// Sub returns the workspace rooted at dir within fsys, e.g. one branch of a
+// GitHub repository
+func Sub(fsys FS, dir string) FS {
+	return &subFS{fsys: fsys, dir: path.Clean(dir)}
+}
+
+type subFS struct {
+	fsys FS
+	dir  string
+}
+
+func (s *subFS) name(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join(s.dir, name), nil
+}
+
+func (s *subFS) Open(name string) (fs.File, error) {
+	full, err := s.name("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return s.fsys.Open(full)
+}
+
+func (s *subFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	full, err := s.name("write", name)
+	if err != nil {
+		return err
+	}
+	return s.fsys.WriteFile(full, data, perm)
+}
+
+// ReadFile reads the file at a path given by the agent
+func ReadFile(fsys FS, p string) ([]byte, error) {
+	name, err := Resolve(fsys, p)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadFile(fsys, name)
+}
+
+// WriteFile writes the file at a path given by the agent
+func WriteFile(fsys FS, p string, data []byte) error {
+	name, err := Resolve(fsys, p)
+	if err != nil {
+		return err
+	}
+	return fsys.WriteFile(name, data, 0644)
+}
+
+// EOF is the end line that stands for the end of the file in ReadLines and
+// WriteLines
+const EOF = -1
+
+// ReadLines reads lines start up to but not including end of the file at
+// path, counting from 0. An end of EOF reads to the end of the file.
+func ReadLines(fsys FS, p string, start, end int) (string, error) {
+	name, err := Resolve(fsys, p)
+	if err != nil {
+		return "", err
+	}
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return "", err
+	}
+
+	lines := splitLines(string(data))
+	start, end, err = lineSpan(len(lines), start, end)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(lines[start:end], ""), nil
+}
+
+// WriteLines replaces lines start up to but not including end of the file
+// at path with content, counting from 0. An end of EOF replaces everything
+// from start to the end of the file, so with a start of 0 the whole file is
+// written. Missing files are created.
+func WriteLines(fsys FS, p, content string, start, end int) error {
+	name, err := Resolve(fsys, p)
+	if err != nil {
+		return err
+	}
+
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	lines := splitLines(string(data))
+	start, end, err = lineSpan(len(lines), start, end)
+	if err != nil {
+		return err
+	}
+
+	// Keep the following lines on their own line.
+	if end < len(lines) && content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	before := strings.Join(lines[:start], "")
+	if before != "" && !strings.HasSuffix(before, "\n") {
+		before += "\n"
+	}
+
+	updated := before + content + strings.Join(lines[end:], "")
+	return fsys.WriteFile(name, []byte(updated), 0644)
+}
+
+// lineSpan checks a line range against the number of lines in a file
+func lineSpan(count, start, end int) (int, int, error) {
+	switch {
+	case end == EOF:
+		end = count
+	case end <= 0:
+		return 0, 0, fmt.Errorf("end line %d is invalid, use %d for the end of the file", end, EOF)
+	}
+	if start < 0 || start > count {
+		return 0, 0, fmt.Errorf("start line %d is out of range, the file has %d lines", start, count)
+	}
+	if end > count {
+		end = count
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("end line %d is before start line %d", end, start)
+	}
+	return start, end, nil
+}
+
+// splitLines splits text into lines, keeping their line endings
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
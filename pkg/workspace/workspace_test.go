@@ -0,0 +1,106 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const threeLines = "one\ntwo\nthree\n"
+
+func TestReadLines(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "f.txt"), []byte(threeLines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fsys := Dir(dir)
+
+	tests := []struct {
+		start, end int
+		want       string
+		err        string
+	}{
+		{start: 0, end: EOF, want: threeLines},
+		{start: 1, end: EOF, want: "two\nthree\n"},
+		{start: 0, end: 1, want: "one\n"},
+		{start: 1, end: 2, want: "two\n"},
+		{start: 1, end: 10, want: "two\nthree\n"},
+		{start: 3, end: EOF, want: ""},
+		{start: 0, end: 0, err: "end line 0 is invalid"},
+		{start: 0, end: -2, err: "end line -2 is invalid"},
+		{start: 4, end: EOF, err: "start line 4 is out of range"},
+		{start: -1, end: EOF, err: "start line -1 is out of range"},
+		{start: 2, end: 1, err: "end line 1 is before start line 2"},
+	}
+	for _, tt := range tests {
+		got, err := ReadLines(fsys, "f.txt", tt.start, tt.end)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ReadLines(%d, %d) error = %v, want %q", tt.start, tt.end, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ReadLines(%d, %d) error = %v", tt.start, tt.end, err)
+		} else if got != tt.want {
+			t.Errorf("ReadLines(%d, %d) = %q, want %q", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestWriteLines(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		start, end int
+		want       string
+		err        string
+	}{
+		{name: "whole file", content: "new\n", start: 0, end: EOF, want: "new\n"},
+		{name: "from a line to the end", content: "2\n3\n", start: 1, end: EOF, want: "one\n2\n3\n"},
+		{name: "one line", content: "2", start: 1, end: 2, want: "one\n2\nthree\n"},
+		{name: "insert", content: "1.5\n", start: 1, end: 1, want: "one\n1.5\ntwo\nthree\n"},
+		{name: "append", content: "four\n", start: 3, end: EOF, want: "one\ntwo\nthree\nfour\n"},
+		{name: "zero end", content: "x\n", start: 0, end: 0, err: "end line 0 is invalid"},
+		{name: "negative end", content: "x\n", start: 0, end: -5, err: "end line -5 is invalid"},
+		{name: "start past the end", content: "x\n", start: 5, end: EOF, err: "start line 5 is out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "f.txt")
+			if err := os.WriteFile(path, []byte(threeLines), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := WriteLines(Dir(dir), "f.txt", tt.content, tt.start, tt.end)
+			data, _ := os.ReadFile(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error = %v, want %q", err, tt.err)
+				}
+				if string(data) != threeLines {
+					t.Errorf("file changed after an error: %q", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("file = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteLinesCreates(t *testing.T) {
+	dir := t.TempDir()
+	if err := WriteLines(Dir(dir), "a/b.txt", "hi\n", 0, EOF); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "a", "b.txt")); err != nil || string(data) != "hi\n" {
+		t.Errorf("created file = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/openagentsinc/autodev/agent"
+	"github.com/openagentsinc/autodev/config"
+	"github.com/openagentsinc/autodev/pkg/action"
+	agents "github.com/openagentsinc/autodev/pkg/agent"
+	"github.com/openagentsinc/autodev/pkg/browser"
+	"github.com/openagentsinc/autodev/pkg/controller"
+	"github.com/openagentsinc/autodev/pkg/memory"
+	"github.com/openagentsinc/autodev/pkg/observation"
+	"github.com/openagentsinc/autodev/pkg/plan"
+	"github.com/openagentsinc/autodev/pkg/plugin"
+	"github.com/openagentsinc/autodev/pkg/sandbox"
+	"github.com/openagentsinc/autodev/pkg/state"
+	"github.com/openagentsinc/autodev/pkg/usage"
+	"github.com/openagentsinc/autodev/pkg/workspace"
+	"github.com/openagentsinc/autodev/views/tabs"
+)
+
+// coderConversationID identifies the coding agent's requests in usage
+// reports
+const coderConversationID = "coder"
+
+// Limits for a coding run
+const (
+	coderMaxIterations = 30
+	coderMaxStepChars  = 2000
+)
+
+// errCoderRunning is returned when a run is asked for while one is going
+var errCoderRunning = errors.New("the coding agent is already running")
+
+// agentEvent is a server-sent event for the browsers following the coding
+// agent
+type agentEvent struct {
+	name string
+	data string
+}
+
+// coder runs the CodeAct agent on the plan, one run at a time, in a
+// sandbox with the configured workspace directory. Each step is broadcast to the browsers
+// connected to /agent/stream, and when the run ends the planner updates the
+// plan from what the agent did. The agent remembers its steps in memory
+// and can recall them, and what was remembered in earlier runs, later.
+type coder struct {
+	cfg      *config.Config
+	myAgent  *agent.Agent
+	planner  *agents.Planner
+	browser  *browser.Browser
+	memory   *memory.Memory
+	registry *agents.Registry
+
+	mu          sync.Mutex
+	cancel      context.CancelFunc
+	subscribers map[chan agentEvent]bool
+}
+
+func newCoder(cfg *config.Config, myAgent *agent.Agent, planner *agents.Planner, pageBrowser *browser.Browser, agentMemory *memory.Memory) *coder {
+	return &coder{
+		cfg:         cfg,
+		myAgent:     myAgent,
+		planner:     planner,
+		browser:     pageBrowser,
+		memory:      agentMemory,
+		registry:    newRegistry(cfg, agentMemory),
+		subscribers: make(map[chan agentEvent]bool),
+	}
+}
+
+// start begins a run in the background unless one is already going
+func (cd *coder) start() error {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	if cd.cancel != nil {
+		return errCoderRunning
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cd.cancel = cancel
+	go cd.run(ctx)
+	return nil
+}
+
+// stop cancels the current run, reporting whether there was one
+func (cd *coder) stop() bool {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	if cd.cancel == nil {
+		return false
+	}
+	cd.cancel()
+	return true
+}
+
+func (cd *coder) run(ctx context.Context) {
+	defer func() {
+		cd.mu.Lock()
+		cd.cancel()
+		cd.cancel = nil
+		cd.mu.Unlock()
+	}()
+
+	notes, err := cd.work(ctx)
+	if err != nil {
+		cd.broadcast("step", messageBubble(errorMessage(err)))
+		return
+	}
+	cd.broadcast("step", messageBubble(notes))
+
+	// Plan even if the run was stopped, since some steps may have been taken
+	if err := updatePlan(context.Background(), cd.planner, cd.myAgent, notes); err != nil {
+		cd.broadcast("step", messageBubble(errorMessage(err)))
+	}
+	if planHTML, err := planTasksOOB(cd.myAgent); err == nil {
+		cd.broadcast("plan", planHTML)
+	}
+}
+
+// work runs the agent until it finishes or a limit stops it, and returns
+// notes on what it did for the planner
+func (cd *coder) work(ctx context.Context) (string, error) {
+	// The agent works from a copy of the plan, so the shared plan can still
+	// be read and updated during the run
+	var snapshot *plan.Plan
+	var err error
+	cd.myAgent.ReadPlan(func(p *plan.Plan) {
+		snapshot, err = copyPlan(p)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(cd.cfg.WorkspaceDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create workspace: %v", err)
+	}
+	commands, err := cd.sandbox()
+	if err != nil {
+		return "", err
+	}
+	defer commands.Close()
+
+	coderAgent := agents.NewCodeActAgent(cd.cfg.LLM, nil, cd.cfg.Agent("coder").Options()...)
+	if cd.memory != nil {
+		coderAgent.SetMemory(cd.memory)
+	}
+	coderAgent.Delegates = cd.registry.Names()
+	coderAgent.Tools = cd.cfg.Agent("coder").Tools
+
+	// Run the commands in one shell, so the directory, exported variables
+	// and whatever the plugins set up carry over from step to step
+	if err := commands.StartSession(); err != nil {
+		cd.broadcast("step", messageBubble(fmt.Sprintf("Each command runs in a new shell: %v", err)))
+	}
+	plugins := plugin.PluginMixin{Sandbox: commands}
+	if err := plugins.InitPlugins(coderAgent.Requirements()); err != nil {
+		return "", err
+	}
+
+	if current := snapshot.GetCurrentTask(); current != nil {
+		cd.broadcast("step", messageBubble("Working on: "+current.Goal))
+	}
+	s := state.NewState(snapshot)
+	c := controller.New(coderAgent, commands, workspace.Dir(cd.cfg.WorkspaceDir), s)
+	c.MaxIterations = coderMaxIterations
+	c.Tracker = cd.cfg.Usage
+	c.Browser = cd.browser
+	c.Registry = cd.registry
+	c.OnStep = func(entry state.HistoryEntry) {
+		cd.broadcast("step", stepBubble(entry))
+		// Show the pages the agent visits in the browser tab
+		if obs, ok := entry.Observation.(*observation.BrowserOutputObservation); ok {
+			if pageHTML, err := browserResultOOB(obs); err == nil {
+				cd.broadcast("browse", pageHTML)
+			}
+		}
+	}
+
+	runErr := c.Run(usage.WithConversation(ctx, coderConversationID))
+	return runNotes(s, runErr), nil
+}
+
+// newRegistry returns the agents the coding agent can delegate to: a
+// browser that researches on the web, a verifier that checks work by
+// running commands, and the planner. Delegates share the coder's sandbox
+// and memory.
+func newRegistry(cfg *config.Config, agentMemory *memory.Memory) *agents.Registry {
+	registry := agents.NewRegistry()
+	for _, name := range []string{"browser", "verifier"} {
+		ac := cfg.Agent(name)
+		registry.Register(name, func() agents.Agent {
+			a := agents.NewCodeActAgent(cfg.LLM, nil, ac.Options()...)
+			a.Tools = ac.Tools
+			if agentMemory != nil {
+				a.SetMemory(agentMemory)
+			}
+			return a
+		})
+	}
+	registry.Register("planner", func() agents.Agent {
+		return agents.NewPlannerAgent(cfg.LLM, nil, cfg.Agent("planner").Options()...)
+	})
+	return registry
+}
+
+// commandSandbox runs the coding agent's commands and plugins
+type commandSandbox interface {
+	action.ActionManager
+	plugin.SandboxProtocol
+	StartSession() error
+	Close() error
+}
+
+// sandbox returns the sandbox for a run: a container with the workspace
+// mounted, unless the configuration opts out and runs commands on the host
+func (cd *coder) sandbox() (commandSandbox, error) {
+	if cd.cfg.Sandbox == config.SandboxLocal {
+		return sandbox.NewLocal(cd.cfg.WorkspaceDir), nil
+	}
+	container, err := sandbox.NewContainer(cd.cfg.Container)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start sandbox (set SANDBOX=local to run commands on the host instead): %v", err)
+	}
+	return container, nil
+}
+
+// subscribe returns a channel receiving the coder's events until it is
+// unsubscribed
+func (cd *coder) subscribe() chan agentEvent {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	events := make(chan agentEvent, 64)
+	cd.subscribers[events] = true
+	return events
+}
+
+func (cd *coder) unsubscribe(events chan agentEvent) {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	delete(cd.subscribers, events)
+}
+
+// broadcast sends an event to every subscriber, skipping those too far
+// behind rather than holding up the agent
+func (cd *coder) broadcast(name, data string) {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	for events := range cd.subscribers {
+		select {
+		case events <- agentEvent{name: name, data: data}:
+		default:
+		}
+	}
+}
+
+// HandleAgentStream streams the coding agent's steps as server-sent events.
+// "step" events carry a message bubble for the message list, while "browse"
+// and "plan" events swap the pages the agent visits and the updated plan
+// into the browser and planner tabs out of band.
+func (cd *coder) HandleAgentStream(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+	c.Response().Header().Set(echo.HeaderConnection, "keep-alive")
+	c.Response().WriteHeader(http.StatusOK)
+	c.Response().Flush()
+
+	events := cd.subscribe()
+	defer cd.unsubscribe(events)
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case event := <-events:
+			if err := writeSSE(c, event.name, event.data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// browserResultOOB renders a page the agent visited to replace the one in
+// the browser tab out of band
+func browserResultOOB(obs *observation.BrowserOutputObservation) (string, error) {
+	var b strings.Builder
+	b.WriteString(`<div id="browser-result" hx-swap-oob="innerHTML">`)
+	if err := tabs.BrowserResult(obs).Render(context.Background(), &b); err != nil {
+		return "", fmt.Errorf("failed to render page: %v", err)
+	}
+	b.WriteString(`</div>`)
+	return b.String(), nil
+}
+
+// copyPlan returns a deep copy of the plan
+func copyPlan(p *plan.Plan) (*plan.Plan, error) {
+	data, err := plan.Marshal(p, plan.FormatJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy plan: %v", err)
+	}
+	return plan.Unmarshal(data, plan.FormatJSON)
+}
+
+// runNotes describes a coding run for the planner: each action the agent
+// took and how the run ended
+func runNotes(s *state.State, runErr error) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "The coding agent took %d steps:\n", len(s.History))
+	for _, entry := range s.History {
+		switch act := entry.Action.(type) {
+		case *action.AgentFinishAction:
+			fmt.Fprintf(&b, "- Finished: %s\n", act.Thought)
+		case *action.AgentThinkAction:
+		default:
+			fmt.Fprintf(&b, "- %s\n", act.Message())
+		}
+	}
+	if runErr != nil {
+		fmt.Fprintf(&b, "It stopped before finishing: %v\n", runErr)
+	}
+	return b.String()
+}
+
+// stepBubble renders a step of the coding agent for the message list
+func stepBubble(entry state.HistoryEntry) string {
+	text := entry.Action.Message()
+	if content := entry.Observation.GetContent(); content != "" {
+		if len(content) > coderMaxStepChars {
+			content = content[:coderMaxStepChars] + "\n[...]"
+		}
+		text += "\n\n" + content
+	}
+	return messageBubble(text)
+}
+
+// messageBubble renders text from the coding agent for the message list
+func messageBubble(text string) string {
+	return fmt.Sprintf(`<div class="bg-zinc-900 rounded p-3 inline-block whitespace-pre-wrap text-sm">%s</div>`, html.EscapeString(text))
+}
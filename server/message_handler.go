@@ -1,57 +1,284 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"html"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/openagentsinc/autodev/agent"
 	"github.com/openagentsinc/autodev/config"
 	"github.com/openagentsinc/autodev/llm"
+	agents "github.com/openagentsinc/autodev/pkg/agent"
+	"github.com/openagentsinc/autodev/pkg/history"
+	"github.com/openagentsinc/autodev/pkg/memory"
+	"github.com/openagentsinc/autodev/pkg/plan"
+	"github.com/openagentsinc/autodev/pkg/usage"
+	"github.com/openagentsinc/autodev/views/tabs"
 )
 
+// chatConversationID identifies the workspace chat in usage reports
+const chatConversationID = "chat"
+
+// pendingReplyTTL is how long a submitted message waits for the browser to
+// connect to /message-stream before it is dropped
+const pendingReplyTTL = 5 * time.Minute
+
+// pendingReplies holds conversations whose assistant reply has not been
+// streamed yet, keyed by the id handed to the browser.
+type pendingReplies struct {
+	mu      sync.Mutex
+	nextID  int
+	pending map[string]pendingReply
+}
+
+type pendingReply struct {
+	history []llm.Message
+	added   time.Time
+}
+
+var replies = &pendingReplies{pending: make(map[string]pendingReply)}
+
+// add stores a conversation, dropping those the browser never streamed
+func (p *pendingReplies) add(history []llm.Message) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for id, reply := range p.pending {
+		if now.Sub(reply.added) > pendingReplyTTL {
+			delete(p.pending, id)
+		}
+	}
+	p.nextID++
+	id := strconv.Itoa(p.nextID)
+	p.pending[id] = pendingReply{history: history, added: now}
+	return id
+}
+
+func (p *pendingReplies) get(id string) ([]llm.Message, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	reply, ok := p.pending[id]
+	return reply.history, ok
+}
+
+func (p *pendingReplies) remove(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, id)
+}
+
+// HandleSubmitMessage records the user's message and returns an empty
+// assistant bubble that connects to /message-stream to receive the reply.
 func HandleSubmitMessage(cfg *config.Config, myAgent *agent.Agent) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		message := c.FormValue("message")
 
-		conversationHistory := myAgent.GetConversationHistory()
-		conversationHistory = append(conversationHistory, llm.Message{
-			Role:    "user",
-			Content: message,
+		conversationHistory := myAgent.AddToConversationHistory(llm.NewTextMessage("user", message))
+		id := replies.add(conversationHistory)
+
+		htmlResponse := fmt.Sprintf(`
+			<div class="bg-zinc-800 rounded p-3 inline-block whitespace-pre-wrap" hx-ext="sse" sse-connect="/message-stream?id=%s" sse-swap="delta,plan" hx-swap="beforeend" sse-close="done"></div>
+		`, id)
+
+		return c.HTML(http.StatusOK, htmlResponse)
+	}
+}
+
+// HandleMessageStream streams the assistant reply for a submitted message as
+// server-sent events. Each "delta" event carries an escaped chunk of text.
+// The planner then updates the plan from the exchange, and a "plan" event
+// swaps the updated plan into the planner tab out of band before a final
+// "done" event tells the browser to close the connection. Each exchange is
+// added to the agent memory, so the coding agent can recall what was
+// discussed.
+func HandleMessageStream(cfg *config.Config, myAgent *agent.Agent, planner *agents.Planner, agentMemory *memory.Memory) echo.HandlerFunc {
+	historyManager := history.NewManager(cfg.History, history.NewLLMSummarizer(cfg.LLM))
+
+	return func(c echo.Context) error {
+		id := c.QueryParam("id")
+		conversationHistory, ok := replies.get(id)
+		defer replies.remove(id)
+		if !ok {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown message"})
+		}
+
+		c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+		c.Response().Header().Set(echo.HeaderConnection, "keep-alive")
+		c.Response().WriteHeader(http.StatusOK)
+
+		ctx := usage.WithConversation(c.Request().Context(), chatConversationID)
+		// The goal and plan change rarely, so they go in the system prompt
+		var system string
+		myAgent.ReadPlan(func(p *plan.Plan) {
+			system = planContext(p)
+			ctx = usage.WithTask(ctx, p.CurrentTaskID())
 		})
+		opts := append(cfg.Agent("planner").Options(),
+			llm.WithSystemBlocks(llm.NewTextBlock(system)),
+		)
+		request := llm.NewRequest(conversationHistory, 1024, opts...)
 
-		response, err := cfg.LLM.GenerateResponse(conversationHistory, 1024)
+		// Keep the conversation within the context window, condensing
+		// older turns if needed
+		answered := conversationHistory
+		conversationHistory, err := historyManager.Compact(ctx, conversationHistory, request.System)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeSSE(c, "delta", html.EscapeString(errorMessage(err)))
+			writeSSE(c, "done", "")
+			return nil
 		}
+		// Cache the whole prefix up to the new message
+		request.Messages = conversationHistory
+		request.Apply(llm.WithPromptCaching())
 
-		conversationHistory = append(conversationHistory, llm.Message{
-			Role:    "assistant",
-			Content: response,
-		})
+		events, err := cfg.LLM.Stream(ctx, request)
+		if err != nil {
+			writeSSE(c, "delta", html.EscapeString(errorMessage(err)))
+			writeSSE(c, "done", "")
+			return nil
+		}
 
-		myAgent.SetConversationHistory(conversationHistory)
+		var response strings.Builder
+		for event := range events {
+			if event.Err != nil {
+				writeSSE(c, "delta", html.EscapeString(errorMessage(event.Err)))
+				writeSSE(c, "done", "")
+				return nil
+			}
+			if event.Text == "" {
+				continue
+			}
+			response.WriteString(event.Text)
+			if err := writeSSE(c, "delta", html.EscapeString(event.Text)); err != nil {
+				return err
+			}
+		}
 
-		newTask := &agent.Task{
-			ID:    fmt.Sprintf("%d", len(myAgent.GetPlan().Tasks)+1),
-			Goal:  response,
-			State: "open",
+		if c.Request().Context().Err() != nil {
+			return nil
 		}
-		myAgent.GetPlan().Tasks = append(myAgent.GetPlan().Tasks, newTask)
 
-		planHTML := generatePlanHTML(myAgent.GetPlan())
+		// Other messages may have arrived meanwhile, so add the reply
+		// rather than overwrite the conversation
+		reply := llm.NewTextMessage("assistant", response.String())
+		myAgent.AddReply(answered, conversationHistory, reply)
+		conversationHistory = append(conversationHistory, reply)
 
-		htmlResponse := fmt.Sprintf(`
-			<div class="bg-zinc-800 rounded p-3 inline-block">%s</div>
-			<div id="plan-display" hx-swap-oob="true">%s</div>
-		`, response, planHTML)
+		// Plan even if the browser goes away meanwhile
+		notes := exchangeNotes(conversationHistory)
+		if agentMemory != nil {
+			if err := agentMemory.AddText(context.Background(), notes); err != nil {
+				c.Logger().Warn(err)
+			}
+		}
+		if err := updatePlan(context.Background(), planner, myAgent, notes); err != nil {
+			c.Logger().Error(err)
+		}
+		planHTML, err := planTasksOOB(myAgent)
+		if err != nil {
+			c.Logger().Error(err)
+		} else if err := writeSSE(c, "plan", planHTML); err != nil {
+			return err
+		}
 
-		return c.HTML(http.StatusOK, htmlResponse)
+		return writeSSE(c, "done", "")
 	}
 }
 
-func generatePlanHTML(plan *agent.Plan) string {
-	// Implement this function to generate the HTML for the plan
-	// You can move the existing implementation from setup.go if it exists
-	return "" // Placeholder return
+// planTasksOOB renders the plan's tasks to replace those in the planner tab
+// out of band
+func planTasksOOB(myAgent *agent.Agent) (string, error) {
+	var b strings.Builder
+	b.WriteString(`<div id="plan-tasks" hx-swap-oob="innerHTML">`)
+	var err error
+	myAgent.ReadPlan(func(p *plan.Plan) {
+		err = tabs.PlanTasks(p).Render(context.Background(), &b)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render plan: %v", err)
+	}
+	b.WriteString(`</div>`)
+	return b.String(), nil
+}
+
+// errorMessage describes an LLM error in terms the user can act on
+func errorMessage(err error) string {
+	switch {
+	case errors.Is(err, llm.ErrRateLimited):
+		return "The model is rate limited right now. Please wait a moment and try again."
+	case errors.Is(err, llm.ErrOverloaded):
+		return "The model is overloaded right now. Please try again shortly."
+	case errors.Is(err, llm.ErrAuthentication):
+		return "The model API rejected our credentials. Check the API key configuration."
+	case errors.Is(err, llm.ErrInvalidRequest):
+		return "The model API rejected the request: " + err.Error()
+	default:
+		return "Error: " + err.Error()
+	}
+}
+
+// writeSSE writes a single server-sent event and flushes it to the client.
+// Multi-line data is split across data fields as the SSE format requires.
+func writeSSE(c echo.Context, event, data string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "event: %s\n", event)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+
+	if _, err := c.Response().Write([]byte(b.String())); err != nil {
+		return err
+	}
+	c.Response().Flush()
+	return nil
+}
+
+// exchangeNotes describes the latest exchange in the conversation for the
+// planner
+func exchangeNotes(conversation []llm.Message) string {
+	var b strings.Builder
+	start := len(conversation) - 2
+	if start < 0 {
+		start = 0
+	}
+	for _, message := range conversation[start:] {
+		role := "User"
+		if message.Role == "assistant" {
+			role = "Assistant"
+		}
+		fmt.Fprintf(&b, "%s: %s\n", role, message.Text())
+	}
+	return b.String()
+}
+
+// planContext describes the main goal and current plan for the system prompt
+func planContext(p *plan.Plan) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Main goal:\n%s\n", p.MainGoal)
+	if len(p.Task.Subtasks) > 0 {
+		b.WriteString("\nCurrent plan:\n")
+		writeTasks(&b, p.Task.Subtasks, "")
+	}
+	return b.String()
+}
+
+// writeTasks lists tasks and their subtasks, indented by depth
+func writeTasks(b *strings.Builder, tasks []*plan.Task, indent string) {
+	for _, task := range tasks {
+		fmt.Fprintf(b, "%s%s [%s] %s", indent, task.ID, task.State, task.Goal)
+		if len(task.DependsOn) > 0 {
+			fmt.Fprintf(b, " (after %s)", strings.Join(task.DependsOn, ", "))
+		}
+		b.WriteString("\n")
+		writeTasks(b, task.Subtasks, indent+"  ")
+	}
 }
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"context"
+
+	"github.com/openagentsinc/autodev/agent"
+	agents "github.com/openagentsinc/autodev/pkg/agent"
+	"github.com/openagentsinc/autodev/pkg/usage"
+)
+
+// plannerConversationID identifies planning requests in usage reports
+const plannerConversationID = "planner"
+
+// updatePlan has the planner break the main goal down into tasks if the
+// plan has none yet, and otherwise revise the plan given notes on what has
+// happened, dropping the update if the plan changed meanwhile
+func updatePlan(ctx context.Context, planner *agents.Planner, myAgent *agent.Agent, notes string) error {
+	return planner.Update(usage.WithConversation(ctx, plannerConversationID), myAgent, notes)
+}
@@ -2,10 +2,12 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/extism/go-sdk"
@@ -13,11 +15,21 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/openagentsinc/autodev/agent"
 	"github.com/openagentsinc/autodev/config"
+	agents "github.com/openagentsinc/autodev/pkg/agent"
+	"github.com/openagentsinc/autodev/pkg/browser"
+	"github.com/openagentsinc/autodev/pkg/memory"
+	"github.com/openagentsinc/autodev/pkg/observation"
+	"github.com/openagentsinc/autodev/pkg/plan"
+	"github.com/openagentsinc/autodev/pkg/usage"
 	"github.com/openagentsinc/autodev/pkg/wanix/githubfs"
 	"github.com/openagentsinc/autodev/plugins"
 	"github.com/openagentsinc/autodev/views"
+	"github.com/openagentsinc/autodev/views/tabs"
 )
 
+// maxPlanSize limits uploaded plans
+const maxPlanSize = 1 << 20
+
 func SetupServer(cfg *config.Config, extismPlugin *extism.Plugin) *echo.Echo {
 	e := echo.New()
 	e.Use(middleware.Logger())
@@ -27,12 +39,43 @@ func SetupServer(cfg *config.Config, extismPlugin *extism.Plugin) *echo.Echo {
 
 	cssVersion := fmt.Sprintf("v=%d", time.Now().Unix())
 
-	// Create a new agent with a hardcoded plan
-	initialPlan := agent.NewPlan(
-		"We are cloning OpenDevin, a web UI for managing semi-autonomous AI coding agents that implements the CodeAct paper. Their codebase is in Python and we are converting it to Golang.",
-		[]*agent.Task{},
-	)
+	// Resume the saved plan, or start one with a hardcoded goal
+	initialPlan, err := plan.Load(cfg.PlanPath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			e.Logger.Warnf("Starting a new plan: %v", err)
+		}
+		initialPlan = plan.NewPlan(
+			"We are cloning OpenDevin, a web UI for managing semi-autonomous AI coding agents that implements the CodeAct paper. Their codebase is in Python and we are converting it to Golang.",
+		)
+	}
 	myAgent := agent.NewAgent(initialPlan)
+	myAgent.PlanPath = cfg.PlanPath
+	planner := agents.NewPlanner(cfg.LLM, cfg.Agent("planner").Options()...)
+
+	pageBrowser := browser.New()
+	if cfg.ChromePath != "" {
+		path := cfg.ChromePath
+		if path == "auto" {
+			path = ""
+		}
+		chrome, err := browser.NewChrome(path)
+		if err != nil {
+			e.Logger.Warnf("Screenshots disabled: %v", err)
+		} else {
+			pageBrowser.Screenshotter = chrome
+		}
+	}
+
+	// The coding agent remembers its steps, chats and browsed pages, and
+	// recalls them by embedding similarity if embeddings are configured
+	// and by BM25 otherwise
+	embedder := newEmbedder(cfg)
+	agentMemory, err := memory.New(cfg.MemoryPath, embedder)
+	if err != nil {
+		e.Logger.Warnf("Starting with an empty memory: %v", err)
+		agentMemory, _ = memory.New("", embedder)
+	}
 
 	e.GET("/", func(c echo.Context) error {
 		return c.Render(http.StatusOK, "index", map[string]interface{}{
@@ -42,30 +85,130 @@ func SetupServer(cfg *config.Config, extismPlugin *extism.Plugin) *echo.Echo {
 	})
 
 	e.POST("/submit-message", HandleSubmitMessage(cfg, myAgent))
+	e.GET("/message-stream", HandleMessageStream(cfg, myAgent, planner, agentMemory))
 
-	e.POST("/replay", func(c echo.Context) error {
-		// Clear existing tasks and generate new plan
-		myAgent.ResetPlan()
+	coder := newCoder(cfg, myAgent, planner, pageBrowser, agentMemory)
+
+	e.POST("/agent/run", func(c echo.Context) error {
+		if err := coder.start(); err != nil {
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		}
+		return c.NoContent(http.StatusAccepted)
+	})
+
+	e.POST("/agent/stop", func(c echo.Context) error {
+		if !coder.stop() {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "the coding agent is not running"})
+		}
 		return c.NoContent(http.StatusOK)
 	})
 
-	e.GET("/plan-updates", func(c echo.Context) error {
-		c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
-		c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
-		c.Response().Header().Set(echo.HeaderConnection, "keep-alive")
-		c.Response().WriteHeader(http.StatusOK)
+	e.GET("/agent/stream", coder.HandleAgentStream)
 
-		updates := myAgent.GenerateDemoPlan()
-		for update := range updates {
-			taskListHTML := generateTaskListHTML(update)
+	e.POST("/replay", func(c echo.Context) error {
+		// Clear existing tasks and generate new plan, which the planner tab
+		// picks up when it is ready
+		if err := myAgent.ResetPlan(); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		go func() {
+			if err := updatePlan(context.Background(), planner, myAgent, ""); err != nil {
+				e.Logger.Error(err)
+			}
+		}()
+		return c.NoContent(http.StatusOK)
+	})
 
-			if _, err := c.Response().Write([]byte(fmt.Sprintf("data: %s\n\n", taskListHTML))); err != nil {
-				return err
+	e.POST("/browse", func(c echo.Context) error {
+		url := c.FormValue("url")
+		if url == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "URL not specified"})
+		}
+		obs := pageBrowser.Observe(c.Request().Context(), url)
+		if !obs.Error {
+			if err := agentMemory.Add(c.Request().Context(), obs.ToMemory()); err != nil {
+				c.Logger().Warn(err)
 			}
-			c.Response().Flush()
 		}
+		return c.Render(http.StatusOK, "browser_result", map[string]interface{}{
+			"Observation": obs,
+		})
+	})
+
+	e.GET("/usage", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, cfg.Usage.Report())
+	})
+
+	e.GET("/usage/summary", func(c echo.Context) error {
+		return c.HTML(http.StatusOK, generateUsageHTML(cfg.Usage.Session()))
+	})
+
+	e.GET("/plan", func(c echo.Context) error {
+		return c.Render(http.StatusOK, "plan_tasks", map[string]interface{}{
+			"Agent": myAgent,
+		})
+	})
+
+	e.GET("/plan/timeline", func(c echo.Context) error {
+		return c.Render(http.StatusOK, "plan_timeline", map[string]interface{}{
+			"Agent": myAgent,
+		})
+	})
+
+	e.POST("/plan/undo", func(c echo.Context) error {
+		if err := myAgent.UpdatePlan((*plan.Plan).Undo); err != nil {
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		}
+		return c.Render(http.StatusOK, "plan_tasks", map[string]interface{}{
+			"Agent": myAgent,
+		})
+	})
+
+	e.POST("/plan/redo", func(c echo.Context) error {
+		if err := myAgent.UpdatePlan((*plan.Plan).Redo); err != nil {
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		}
+		return c.Render(http.StatusOK, "plan_tasks", map[string]interface{}{
+			"Agent": myAgent,
+		})
+	})
 
-		return nil
+	e.GET("/plan/export", func(c echo.Context) error {
+		format := c.QueryParam("format")
+		if format == "" {
+			format = plan.FormatJSON
+		}
+		var data []byte
+		var err error
+		myAgent.ReadPlan(func(p *plan.Plan) {
+			data, err = plan.Marshal(p, format)
+		})
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		contentType := echo.MIMEApplicationJSONCharsetUTF8
+		if format == plan.FormatYAML {
+			contentType = "application/yaml; charset=UTF-8"
+		}
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=plan.%s", format))
+		return c.Blob(http.StatusOK, contentType, data)
+	})
+
+	e.POST("/plan/import", func(c echo.Context) error {
+		data, format, err := readPlanUpload(c)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		p, err := plan.Unmarshal(data, format)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if err := myAgent.ReplacePlan(p); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		// The goal may have changed too, so have htmx reload the page
+		c.Response().Header().Set("HX-Refresh", "true")
+		return c.JSON(http.StatusOK, p)
 	})
 
 	e.GET("/repos", func(c echo.Context) error {
@@ -328,7 +471,11 @@ func (t *TemplRenderer) Render(w io.Writer, name string, data interface{}, c ech
 
 	switch name {
 	case "index":
-		return views.Index(cssVersion, myAgent).Render(context.Background(), w)
+		var err error
+		myAgent.ReadPlan(func(p *plan.Plan) {
+			err = views.Index(cssVersion, myAgent).Render(context.Background(), w)
+		})
+		return err
 	case "repos":
 		return views.Repos(cssVersion, viewContext).Render(context.Background(), w)
 	case "greptile":
@@ -358,6 +505,21 @@ func (t *TemplRenderer) Render(w io.Writer, name string, data interface{}, c ech
 		branch, _ := viewContext["Branch"].(string)
 		repo, _ := viewContext["Repo"].(string)
 		return views.DirectoryList(entries, path, branch, repo).Render(context.Background(), w)
+	case "plan_tasks":
+		var err error
+		myAgent.ReadPlan(func(p *plan.Plan) {
+			err = tabs.PlanTasks(p).Render(context.Background(), w)
+		})
+		return err
+	case "plan_timeline":
+		var err error
+		myAgent.ReadPlan(func(p *plan.Plan) {
+			err = tabs.PlanTimeline(p).Render(context.Background(), w)
+		})
+		return err
+	case "browser_result":
+		obs, _ := viewContext["Observation"].(*observation.BrowserOutputObservation)
+		return tabs.BrowserResult(obs).Render(context.Background(), w)
 	case "file_content":
 		content, _ := viewContext["Content"].(string)
 		path, _ := viewContext["Path"].(string)
@@ -367,7 +529,52 @@ func (t *TemplRenderer) Render(w io.Writer, name string, data interface{}, c ech
 	}
 }
 
-func generateTaskListHTML(update agent.PlanUpdate) string {
-	return fmt.Sprintf(`<li class="mb-2"><span class="text-blue-400 mr-2">%s.</span><span>%s</span><span class="ml-2 text-yellow-400">(%s)</span>
-</li>`, update.TaskID, update.Goal, update.State)
+// readPlanUpload reads a plan from the "plan" file of a multipart form or
+// else from the request body. The format is taken from the format query
+// parameter, the file name or the content type, defaulting to JSON.
+func readPlanUpload(c echo.Context) ([]byte, string, error) {
+	format := c.QueryParam("format")
+	var r io.Reader = c.Request().Body
+	if file, err := c.FormFile("plan"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			return nil, "", err
+		}
+		defer f.Close()
+		r = f
+		if format == "" {
+			format = plan.FormatFromPath(file.Filename)
+		}
+	} else if strings.Contains(c.Request().Header.Get(echo.HeaderContentType), "yaml") && format == "" {
+		format = plan.FormatYAML
+	}
+	if format == "" {
+		format = plan.FormatJSON
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, maxPlanSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read plan: %v", err)
+	}
+	if len(data) > maxPlanSize {
+		return nil, "", fmt.Errorf("plan is larger than %d bytes", maxPlanSize)
+	}
+	return data, format, nil
+}
+
+// newEmbedder returns the embedder for the agent's memory: OpenAI
+// embeddings with EMBEDDING_MODEL, or with the default model when an OpenAI
+// key is set for the OpenAI API itself. It returns nil otherwise, and memory
+// is searched with BM25 alone.
+func newEmbedder(cfg *config.Config) memory.Embedder {
+	if cfg.EmbeddingModel != "" || (cfg.OpenAIAPIKey != "" && cfg.OpenAIBaseURL == "") {
+		return memory.NewOpenAIEmbedder(cfg.OpenAIAPIKey, cfg.OpenAIBaseURL, cfg.EmbeddingModel)
+	}
+	return nil
+}
+
+func generateUsageHTML(totals usage.Totals) string {
+	return fmt.Sprintf(`<span title="%d requests, %d input, %d output, %d cache write, %d cache read tokens">$%.4f &middot; %d tokens</span>`,
+		totals.Requests, totals.InputTokens, totals.OutputTokens, totals.CacheCreationInputTokens, totals.CacheReadInputTokens,
+		totals.Cost, totals.TotalTokens())
 }